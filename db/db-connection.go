@@ -14,9 +14,9 @@ var (
 	err error
 )
 
-func ConnectDB() {
-
-	dsn := fmt.Sprintf(`host=%s port=%s user=%s password=%s dbname=%s sslmode=%s`,
+// DSN is the connection string built from the DB_* environment variables
+func DSN() string {
+	return fmt.Sprintf(`host=%s port=%s user=%s password=%s dbname=%s sslmode=%s`,
 		os.Getenv("DB_HOST"),
 		os.Getenv("DB_PORT"),
 		os.Getenv("DB_USER"),
@@ -24,8 +24,11 @@ func ConnectDB() {
 		os.Getenv("DB_NAME"),
 		os.Getenv("DB_SSL_MODE"),
 	)
+}
+
+func ConnectDB() {
 
-	DB, err = sql.Open("postgres", dsn)
+	DB, err = sql.Open("postgres", DSN())
 	if err != nil {
 		log.Println("Failed to establish connection to DB", err)
 	}
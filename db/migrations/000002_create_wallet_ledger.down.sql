@@ -0,0 +1,17 @@
+ALTER TABLE payments ALTER COLUMN invoice_url SET NOT NULL;
+
+ALTER TABLE payments DROP COLUMN IF EXISTS method;
+
+DROP TYPE IF EXISTS payment_method_enum;
+
+DROP TABLE IF EXISTS wallet_top_ups;
+
+DROP TABLE IF EXISTS ledger_entries;
+
+DROP TYPE IF EXISTS ledger_entry_direction_enum;
+
+DROP TABLE IF EXISTS ledger_transactions;
+
+DROP TABLE IF EXISTS ledger_accounts;
+
+DROP TYPE IF EXISTS ledger_account_type_enum;
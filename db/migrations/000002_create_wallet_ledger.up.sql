@@ -0,0 +1,79 @@
+CREATE TYPE ledger_account_type_enum AS ENUM (
+  'USER_WALLET',
+  'PAYMENT_GATEWAY',
+  'SHIPMENT_REVENUE'
+);
+
+-- Balance is always SUM(credit) - SUM(debit) of the account's entries
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+  id SERIAL PRIMARY KEY,
+  code VARCHAR(255) NOT NULL UNIQUE,
+  user_id INT UNIQUE,
+  type ledger_account_type_enum NOT NULL,
+  balance INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT fk_ledger_accounts_user FOREIGN KEY (user_id) REFERENCES users(id),
+  CONSTRAINT chk_ledger_accounts_wallet_balance CHECK (type <> 'USER_WALLET' OR balance >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+  id SERIAL PRIMARY KEY,
+  type VARCHAR(50) NOT NULL,
+  reference VARCHAR(255) NOT NULL UNIQUE,
+  "desc" TEXT,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL
+);
+
+CREATE TYPE ledger_entry_direction_enum AS ENUM (
+  'DEBIT',
+  'CREDIT'
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+  id SERIAL PRIMARY KEY,
+  transaction_id INT NOT NULL,
+  account_id INT NOT NULL,
+  direction ledger_entry_direction_enum NOT NULL,
+  amount INT NOT NULL,
+  balance_after INT NOT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  CONSTRAINT fk_ledger_entries_transaction FOREIGN KEY (transaction_id) REFERENCES ledger_transactions(id),
+  CONSTRAINT fk_ledger_entries_account FOREIGN KEY (account_id) REFERENCES ledger_accounts(id),
+  CONSTRAINT chk_ledger_entries_amount CHECK (amount > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries (account_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS wallet_top_ups (
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL,
+  account_id INT NOT NULL,
+  amount INT NOT NULL,
+  invoice_id VARCHAR(255) UNIQUE NOT NULL,
+  external_id VARCHAR(255) UNIQUE NOT NULL,
+  invoice_url TEXT UNIQUE NOT NULL,
+  status payment_status_enum NOT NULL DEFAULT 'PENDING',
+  ledger_transaction_id INT DEFAULT NULL,
+  paid_at TIMESTAMP DEFAULT NULL,
+  expired_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT fk_wallet_top_ups_user FOREIGN KEY (user_id) REFERENCES users(id),
+  CONSTRAINT fk_wallet_top_ups_account FOREIGN KEY (account_id) REFERENCES ledger_accounts(id),
+  CONSTRAINT fk_wallet_top_ups_transaction FOREIGN KEY (ledger_transaction_id) REFERENCES ledger_transactions(id)
+);
+
+CREATE TYPE payment_method_enum AS ENUM (
+  'INVOICE',
+  'WALLET'
+);
+
+ALTER TABLE payments ADD COLUMN method payment_method_enum NOT NULL DEFAULT 'INVOICE';
+ALTER TABLE payments ALTER COLUMN invoice_url DROP NOT NULL;
+
+
+-- SEEDER
+INSERT INTO ledger_accounts (code, type)
+VALUES ('SYS-PAYMENT-GATEWAY', 'PAYMENT_GATEWAY'),
+('SYS-SHIPMENT-REVENUE', 'SHIPMENT_REVENUE');
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS billing_invoice_items;
+
+DROP TABLE IF EXISTS billing_invoices;
+
+-- Postgres cannot drop a single enum value, 'POSTPAID' stays on payment_method_enum
+
+ALTER TABLE users DROP COLUMN IF EXISTS credit_limit;
+
+ALTER TABLE users DROP COLUMN IF EXISTS is_credit_account;
@@ -0,0 +1,34 @@
+ALTER TABLE users ADD COLUMN is_credit_account BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN credit_limit INT NOT NULL DEFAULT 0;
+
+ALTER TYPE payment_method_enum ADD VALUE IF NOT EXISTS 'POSTPAID';
+
+CREATE TABLE IF NOT EXISTS billing_invoices (
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL,
+  period_start DATE NOT NULL,
+  period_end DATE NOT NULL,
+  amount INT NOT NULL DEFAULT 0,
+  invoice_id VARCHAR(255) UNIQUE DEFAULT NULL,
+  external_id VARCHAR(255) UNIQUE DEFAULT NULL,
+  invoice_url TEXT DEFAULT NULL,
+  status payment_status_enum NOT NULL DEFAULT 'PENDING',
+  paid_at TIMESTAMP DEFAULT NULL,
+  expired_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT fk_billing_invoices_user FOREIGN KEY (user_id) REFERENCES users(id),
+  CONSTRAINT uq_billing_invoices_user_period UNIQUE (user_id, period_start)
+);
+
+CREATE TABLE IF NOT EXISTS billing_invoice_items (
+  id SERIAL PRIMARY KEY,
+  billing_invoice_id INT NOT NULL,
+  shipment_id INT NOT NULL UNIQUE,
+  tracking_number VARCHAR(255) NOT NULL,
+  "desc" TEXT NOT NULL,
+  amount INT NOT NULL,
+  shipped_at TIMESTAMP NOT NULL,
+  CONSTRAINT fk_billing_invoice_items_invoice FOREIGN KEY (billing_invoice_id) REFERENCES billing_invoices(id),
+  CONSTRAINT fk_billing_invoice_items_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id)
+);
@@ -0,0 +1,9 @@
+ALTER TABLE shipments DROP COLUMN IF EXISTS discount_price;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS promo_code;
+
+DROP TABLE IF EXISTS promotion_redemptions;
+
+DROP TABLE IF EXISTS promotions;
+
+DROP TYPE IF EXISTS discount_type_enum;
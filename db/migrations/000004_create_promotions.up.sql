@@ -0,0 +1,47 @@
+CREATE TYPE discount_type_enum AS ENUM (
+  'PERCENTAGE',
+  'FIXED'
+);
+
+CREATE TABLE IF NOT EXISTS promotions (
+  id SERIAL PRIMARY KEY,
+  code VARCHAR(50) NOT NULL UNIQUE,
+  "desc" TEXT NOT NULL DEFAULT '',
+  discount_type discount_type_enum NOT NULL,
+  discount_value INT NOT NULL,
+  max_discount INT DEFAULT NULL,
+  min_spend INT NOT NULL DEFAULT 0,
+  usage_limit INT DEFAULT NULL,
+  per_user_limit INT DEFAULT NULL,
+  used_count INT NOT NULL DEFAULT 0,
+  min_distance INT DEFAULT NULL,
+  max_distance INT DEFAULT NULL,
+  min_weight DECIMAL(10, 2) DEFAULT NULL,
+  max_weight DECIMAL(10, 2) DEFAULT NULL,
+  origin_keyword VARCHAR(255) DEFAULT NULL,
+  destination_keyword VARCHAR(255) DEFAULT NULL,
+  valid_from TIMESTAMP NOT NULL,
+  valid_until TIMESTAMP NOT NULL,
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT chk_promotions_discount_value CHECK (discount_value > 0 AND (discount_type <> 'PERCENTAGE' OR discount_value <= 100)),
+  CONSTRAINT chk_promotions_validity CHECK (valid_until > valid_from)
+);
+
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+  id SERIAL PRIMARY KEY,
+  promotion_id INT NOT NULL,
+  user_id INT NOT NULL,
+  shipment_id INT NOT NULL UNIQUE,
+  discount INT NOT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  CONSTRAINT fk_promotion_redemptions_promotion FOREIGN KEY (promotion_id) REFERENCES promotions(id),
+  CONSTRAINT fk_promotion_redemptions_user FOREIGN KEY (user_id) REFERENCES users(id),
+  CONSTRAINT fk_promotion_redemptions_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_promotion_user ON promotion_redemptions (promotion_id, user_id);
+
+ALTER TABLE shipments ADD COLUMN promo_code VARCHAR(50) DEFAULT NULL;
+ALTER TABLE shipments ADD COLUMN discount_price INT NOT NULL DEFAULT 0;
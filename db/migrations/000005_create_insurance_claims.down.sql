@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS claim_payouts;
+
+DROP TYPE IF EXISTS claim_payout_method_enum;
+
+DROP TABLE IF EXISTS claim_evidences;
+
+DROP TABLE IF EXISTS claims;
+
+DROP TYPE IF EXISTS claim_status_enum;
+
+DROP TYPE IF EXISTS claim_type_enum;
+
+-- Postgres cannot drop a single enum value, 'INSURANCE_CLAIMS' stays on ledger_account_type_enum
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS insurance_price;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS is_insured;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS declared_value;
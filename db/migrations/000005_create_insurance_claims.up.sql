@@ -0,0 +1,70 @@
+ALTER TABLE shipments ADD COLUMN declared_value INT NOT NULL DEFAULT 0;
+ALTER TABLE shipments ADD COLUMN is_insured BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE shipments ADD COLUMN insurance_price INT NOT NULL DEFAULT 0;
+
+ALTER TYPE ledger_account_type_enum ADD VALUE IF NOT EXISTS 'INSURANCE_CLAIMS';
+
+CREATE TYPE claim_type_enum AS ENUM (
+  'LOST',
+  'DAMAGED'
+);
+
+CREATE TYPE claim_status_enum AS ENUM (
+  'OPEN',
+  'APPROVED',
+  'REJECTED',
+  'PAID'
+);
+
+CREATE TABLE IF NOT EXISTS claims (
+  id SERIAL PRIMARY KEY,
+  shipment_id INT NOT NULL,
+  claimant_id INT NOT NULL,
+  type claim_type_enum NOT NULL,
+  "desc" TEXT NOT NULL,
+  claimed_amount INT NOT NULL,
+  approved_amount INT DEFAULT NULL,
+  status claim_status_enum NOT NULL DEFAULT 'OPEN',
+  resolution_note TEXT DEFAULT NULL,
+  reviewed_by INT DEFAULT NULL,
+  reviewed_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT fk_claims_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id),
+  CONSTRAINT fk_claims_claimant FOREIGN KEY (claimant_id) REFERENCES users(id),
+  CONSTRAINT fk_claims_reviewer FOREIGN KEY (reviewed_by) REFERENCES users(id),
+  CONSTRAINT chk_claims_claimed_amount CHECK (claimed_amount > 0)
+);
+
+-- A shipment can only have one claim that is not rejected
+CREATE UNIQUE INDEX IF NOT EXISTS uq_claims_active_shipment ON claims (shipment_id) WHERE status <> 'REJECTED';
+
+CREATE TABLE IF NOT EXISTS claim_evidences (
+  id SERIAL PRIMARY KEY,
+  claim_id INT NOT NULL,
+  url TEXT NOT NULL,
+  "desc" TEXT,
+  uploaded_by INT NOT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  CONSTRAINT fk_claim_evidences_claim FOREIGN KEY (claim_id) REFERENCES claims(id),
+  CONSTRAINT fk_claim_evidences_user FOREIGN KEY (uploaded_by) REFERENCES users(id)
+);
+
+CREATE TYPE claim_payout_method_enum AS ENUM (
+  'WALLET',
+  'BANK_TRANSFER'
+);
+
+CREATE TABLE IF NOT EXISTS claim_payouts (
+  id SERIAL PRIMARY KEY,
+  claim_id INT NOT NULL UNIQUE,
+  amount INT NOT NULL,
+  method claim_payout_method_enum NOT NULL,
+  reference VARCHAR(255) NOT NULL,
+  ledger_transaction_id INT DEFAULT NULL,
+  paid_by INT NOT NULL,
+  paid_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  CONSTRAINT fk_claim_payouts_claim FOREIGN KEY (claim_id) REFERENCES claims(id),
+  CONSTRAINT fk_claim_payouts_transaction FOREIGN KEY (ledger_transaction_id) REFERENCES ledger_transactions(id),
+  CONSTRAINT fk_claim_payouts_user FOREIGN KEY (paid_by) REFERENCES users(id)
+);
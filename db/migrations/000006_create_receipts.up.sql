@@ -0,0 +1,13 @@
+-- Gapless numbering per year, a sequence could skip numbers on rollback
+CREATE TABLE IF NOT EXISTS receipt_counters (
+  year INT PRIMARY KEY,
+  last_number INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS receipts (
+  id SERIAL PRIMARY KEY,
+  shipment_id INT NOT NULL UNIQUE,
+  receipt_number VARCHAR(50) NOT NULL UNIQUE,
+  issued_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  CONSTRAINT fk_receipts_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id)
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS branch_holidays;
+
+DROP TABLE IF EXISTS branch_operating_hours;
+
+ALTER TABLE branches DROP COLUMN IF EXISTS timezone;
+
+ALTER TABLE branches DROP COLUMN IF EXISTS service_radius;
+
+ALTER TABLE branches DROP COLUMN IF EXISTS longitude;
+
+ALTER TABLE branches DROP COLUMN IF EXISTS latitude;
@@ -0,0 +1,30 @@
+ALTER TABLE branches ADD COLUMN latitude DOUBLE PRECISION DEFAULT NULL;
+ALTER TABLE branches ADD COLUMN longitude DOUBLE PRECISION DEFAULT NULL;
+-- In meters, NULL means the branch serves customers at any distance
+ALTER TABLE branches ADD COLUMN service_radius INT DEFAULT NULL;
+ALTER TABLE branches ADD COLUMN timezone TEXT NOT NULL DEFAULT 'Asia/Jakarta';
+
+-- A weekday without a row means the branch is closed on that day, weekday 0 is Sunday
+CREATE TABLE IF NOT EXISTS branch_operating_hours (
+  branch_id INT NOT NULL,
+  weekday SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+  opens_at TIME NOT NULL,
+  closes_at TIME NOT NULL CHECK (closes_at > opens_at),
+  PRIMARY KEY (branch_id, weekday),
+  CONSTRAINT fk_branch_operating_hours_branch FOREIGN KEY (branch_id) REFERENCES branches(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS branch_holidays (
+  id SERIAL PRIMARY KEY,
+  branch_id INT NOT NULL,
+  date DATE NOT NULL,
+  reason TEXT DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  UNIQUE (branch_id, date),
+  CONSTRAINT fk_branch_holidays_branch FOREIGN KEY (branch_id) REFERENCES branches(id) ON DELETE CASCADE
+);
+
+-- Existing branches keep their previous behaviour of being open during regular office hours, Monday to Saturday
+INSERT INTO branch_operating_hours (branch_id, weekday, opens_at, closes_at)
+SELECT b.id, d.weekday, '08:00', '17:00'
+FROM branches b CROSS JOIN generate_series(1, 6) AS d(weekday);
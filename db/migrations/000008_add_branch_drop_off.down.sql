@@ -0,0 +1,9 @@
+ALTER TABLE shipments DROP CONSTRAINT IF EXISTS fk_shipments_origin_branch;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS origin_branch_id;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS pickup_method;
+
+DROP TYPE IF EXISTS pickup_method_enum;
+
+-- Postgres cannot drop a single enum value, 'RECEIVED_AT_BRANCH' stays on shipment_status_enum
@@ -0,0 +1,10 @@
+ALTER TYPE shipment_status_enum ADD VALUE IF NOT EXISTS 'RECEIVED_AT_BRANCH' AFTER 'PICKED_UP';
+
+CREATE TYPE pickup_method_enum AS ENUM (
+  'PICKUP',
+  'DROP_OFF'
+);
+
+ALTER TABLE shipments ADD COLUMN pickup_method pickup_method_enum NOT NULL DEFAULT 'PICKUP';
+ALTER TABLE shipments ADD COLUMN origin_branch_id INT DEFAULT NULL;
+ALTER TABLE shipments ADD CONSTRAINT fk_shipments_origin_branch FOREIGN KEY (origin_branch_id) REFERENCES branches(id);
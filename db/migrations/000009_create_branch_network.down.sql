@@ -0,0 +1,9 @@
+ALTER TABLE shipment_histories DROP COLUMN IF EXISTS is_off_route;
+
+DROP TABLE IF EXISTS shipment_route_legs;
+
+ALTER TABLE shipments DROP CONSTRAINT IF EXISTS fk_shipments_destination_branch;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS destination_branch_id;
+
+DROP TABLE IF EXISTS branch_links;
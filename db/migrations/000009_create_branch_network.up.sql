@@ -0,0 +1,35 @@
+-- Line-haul links between branches, a link only carries packages in its own direction
+CREATE TABLE IF NOT EXISTS branch_links (
+  id SERIAL PRIMARY KEY,
+  from_branch_id INT NOT NULL,
+  to_branch_id INT NOT NULL,
+  transit_minutes INT NOT NULL CHECK (transit_minutes > 0),
+  cost INT NOT NULL DEFAULT 0 CHECK (cost >= 0),
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  UNIQUE (from_branch_id, to_branch_id),
+  CHECK (from_branch_id <> to_branch_id),
+  CONSTRAINT fk_branch_links_from_branch FOREIGN KEY (from_branch_id) REFERENCES branches(id) ON DELETE CASCADE,
+  CONSTRAINT fk_branch_links_to_branch FOREIGN KEY (to_branch_id) REFERENCES branches(id) ON DELETE CASCADE
+);
+
+ALTER TABLE shipments ADD COLUMN destination_branch_id INT DEFAULT NULL;
+ALTER TABLE shipments ADD CONSTRAINT fk_shipments_destination_branch FOREIGN KEY (destination_branch_id) REFERENCES branches(id);
+
+CREATE TABLE IF NOT EXISTS shipment_route_legs (
+  id SERIAL PRIMARY KEY,
+  shipment_id INT NOT NULL,
+  sequence INT NOT NULL,
+  from_branch_id INT NOT NULL,
+  to_branch_id INT NOT NULL,
+  transit_minutes INT NOT NULL,
+  cost INT NOT NULL,
+  arrived_at TIMESTAMP DEFAULT NULL,
+  UNIQUE (shipment_id, sequence),
+  CONSTRAINT fk_shipment_route_legs_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id),
+  CONSTRAINT fk_shipment_route_legs_from_branch FOREIGN KEY (from_branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_shipment_route_legs_to_branch FOREIGN KEY (to_branch_id) REFERENCES branches(id)
+);
+
+ALTER TABLE shipment_histories ADD COLUMN is_off_route BOOLEAN NOT NULL DEFAULT FALSE;
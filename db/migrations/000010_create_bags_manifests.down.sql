@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS manifest_bags;
+
+DROP TABLE IF EXISTS manifests;
+
+DROP TYPE IF EXISTS manifest_status_enum;
+
+DROP TABLE IF EXISTS bag_items;
+
+DROP TABLE IF EXISTS bags;
+
+DROP TYPE IF EXISTS bag_status_enum;
@@ -0,0 +1,68 @@
+CREATE TYPE bag_status_enum AS ENUM (
+  'OPEN',
+  'SEALED',
+  'IN_TRANSIT',
+  'ARRIVED'
+);
+
+CREATE TABLE IF NOT EXISTS bags (
+  id SERIAL PRIMARY KEY,
+  barcode VARCHAR(255) NOT NULL UNIQUE,
+  origin_branch_id INT NOT NULL,
+  destination_branch_id INT NOT NULL,
+  status bag_status_enum NOT NULL DEFAULT 'OPEN',
+  created_by INT NOT NULL,
+  sealed_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CHECK (origin_branch_id <> destination_branch_id),
+  CONSTRAINT fk_bags_origin_branch FOREIGN KEY (origin_branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_bags_destination_branch FOREIGN KEY (destination_branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_bags_created_by FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS bag_items (
+  bag_id INT NOT NULL,
+  shipment_id INT NOT NULL,
+  added_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  PRIMARY KEY (bag_id, shipment_id),
+  CONSTRAINT fk_bag_items_bag FOREIGN KEY (bag_id) REFERENCES bags(id) ON DELETE CASCADE,
+  CONSTRAINT fk_bag_items_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bag_items_shipment ON bag_items (shipment_id);
+
+CREATE TYPE manifest_status_enum AS ENUM (
+  'DRAFT',
+  'DISPATCHED',
+  'ARRIVED'
+);
+
+CREATE TABLE IF NOT EXISTS manifests (
+  id SERIAL PRIMARY KEY,
+  manifest_number VARCHAR(255) NOT NULL UNIQUE,
+  origin_branch_id INT NOT NULL,
+  destination_branch_id INT NOT NULL,
+  vehicle_plate VARCHAR(20) NOT NULL,
+  driver_name VARCHAR(255) NOT NULL,
+  status manifest_status_enum NOT NULL DEFAULT 'DRAFT',
+  created_by INT NOT NULL,
+  dispatched_at TIMESTAMP DEFAULT NULL,
+  arrived_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CHECK (origin_branch_id <> destination_branch_id),
+  CONSTRAINT fk_manifests_origin_branch FOREIGN KEY (origin_branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_manifests_destination_branch FOREIGN KEY (destination_branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_manifests_created_by FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+-- A bag travels on a single trip
+CREATE TABLE IF NOT EXISTS manifest_bags (
+  manifest_id INT NOT NULL,
+  bag_id INT NOT NULL UNIQUE,
+  added_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  PRIMARY KEY (manifest_id, bag_id),
+  CONSTRAINT fk_manifest_bags_manifest FOREIGN KEY (manifest_id) REFERENCES manifests(id) ON DELETE CASCADE,
+  CONSTRAINT fk_manifest_bags_bag FOREIGN KEY (bag_id) REFERENCES bags(id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS branch_staff;
+
+-- Postgres cannot drop a single enum value, 'BRANCH_ADMIN' stays on role_enum
@@ -0,0 +1,13 @@
+ALTER TYPE role_enum ADD VALUE IF NOT EXISTS 'BRANCH_ADMIN' AFTER 'ADMIN';
+
+-- Staff can work at several branches, BRANCH_ADMINs may only manage the branches they are assigned to
+CREATE TABLE IF NOT EXISTS branch_staff (
+  user_id INT NOT NULL,
+  branch_id INT NOT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  PRIMARY KEY (user_id, branch_id),
+  CONSTRAINT fk_branch_staff_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT fk_branch_staff_branch FOREIGN KEY (branch_id) REFERENCES branches(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_branch_staff_branch ON branch_staff (branch_id);
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS stock_take_items;
+
+DROP TYPE IF EXISTS stock_take_result_enum;
+
+DROP TABLE IF EXISTS stock_takes;
+
+DROP INDEX IF EXISTS idx_shipments_current_branch;
+
+ALTER TABLE shipments
+  DROP CONSTRAINT IF EXISTS fk_shipments_current_courier,
+  DROP CONSTRAINT IF EXISTS fk_shipments_current_branch,
+  DROP COLUMN IF EXISTS located_at,
+  DROP COLUMN IF EXISTS current_courier_id,
+  DROP COLUMN IF EXISTS current_branch_id;
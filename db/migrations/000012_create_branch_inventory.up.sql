@@ -0,0 +1,60 @@
+-- Where the package physically is right now, either at a branch or with a courier. Both are empty while it is on a line-haul vehicle or no longer in the network
+ALTER TABLE shipments
+  ADD COLUMN IF NOT EXISTS current_branch_id INT DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS current_courier_id INT DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS located_at TIMESTAMP DEFAULT NULL,
+  ADD CONSTRAINT fk_shipments_current_branch FOREIGN KEY (current_branch_id) REFERENCES branches(id),
+  ADD CONSTRAINT fk_shipments_current_courier FOREIGN KEY (current_courier_id) REFERENCES users(id);
+
+CREATE INDEX IF NOT EXISTS idx_shipments_current_branch ON shipments (current_branch_id) WHERE current_branch_id IS NOT NULL;
+
+-- Backfill from the latest scan of every shipment still in the network, skipping the ones riding in a dispatched bag
+UPDATE shipments s
+SET
+  current_branch_id = h.branch_id,
+  current_courier_id = CASE WHEN h.branch_id IS NULL THEN h.courier_id END,
+  located_at = h.timestamp
+FROM (
+  SELECT DISTINCT ON (shipment_id) shipment_id, branch_id, courier_id, timestamp
+  FROM shipment_histories
+  WHERE branch_id IS NOT NULL OR courier_id IS NOT NULL
+  ORDER BY shipment_id, timestamp DESC
+) h
+WHERE h.shipment_id = s.id
+  AND s.status IN ('PICKED_UP', 'RECEIVED_AT_BRANCH', 'IN_TRANSIT')
+  AND NOT EXISTS (
+    SELECT 1 FROM bag_items bi
+    JOIN bags b ON b.id = bi.bag_id
+    WHERE bi.shipment_id = s.id AND b.status = 'IN_TRANSIT'
+  );
+
+CREATE TABLE IF NOT EXISTS stock_takes (
+  id SERIAL PRIMARY KEY,
+  branch_id INT NOT NULL,
+  taken_by INT NOT NULL,
+  expected_count INT NOT NULL,
+  scanned_count INT NOT NULL,
+  missing_count INT NOT NULL,
+  unexpected_count INT NOT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  CONSTRAINT fk_stock_takes_branch FOREIGN KEY (branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_stock_takes_user FOREIGN KEY (taken_by) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_takes_branch ON stock_takes (branch_id, created_at);
+
+CREATE TYPE stock_take_result_enum AS ENUM (
+  'FOUND',
+  'MISSING',
+  'UNEXPECTED'
+);
+
+CREATE TABLE IF NOT EXISTS stock_take_items (
+  stock_take_id INT NOT NULL,
+  tracking_number VARCHAR(255) NOT NULL,
+  shipment_id INT DEFAULT NULL, -- empty when the scanned tracking number does not exist
+  result stock_take_result_enum NOT NULL,
+  PRIMARY KEY (stock_take_id, tracking_number),
+  CONSTRAINT fk_stock_take_items_stock_take FOREIGN KEY (stock_take_id) REFERENCES stock_takes(id) ON DELETE CASCADE,
+  CONSTRAINT fk_stock_take_items_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_branches_active;
+
+ALTER TABLE branches DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,4 @@
+-- Deleted branches are kept so shipment histories, stock-takes and line-haul records can still resolve them
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_branches_active ON branches (id) WHERE deleted_at IS NULL;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_branches_name;
+DROP INDEX IF EXISTS idx_branches_region;
+DROP INDEX IF EXISTS idx_branches_city;
+DROP INDEX IF EXISTS idx_branches_search;
+
+ALTER TABLE branches DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE branches DROP COLUMN IF EXISTS region;
+ALTER TABLE branches DROP COLUMN IF EXISTS city;
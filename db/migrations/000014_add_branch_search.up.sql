@@ -0,0 +1,51 @@
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS city VARCHAR(255) DEFAULT NULL;
+-- Province the branch is in, e.g. "Jawa Timur"
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS region VARCHAR(255) DEFAULT NULL;
+
+-- The seeded branches are named after their city
+UPDATE branches b
+SET city = b.name, region = r.region
+FROM (VALUES
+  ('Jakarta', 'DKI Jakarta'),
+  ('Surabaya', 'Jawa Timur'),
+  ('Bandung', 'Jawa Barat'),
+  ('Semarang', 'Jawa Tengah'),
+  ('Yogyakarta', 'DI Yogyakarta'),
+  ('Malang', 'Jawa Timur'),
+  ('Solo', 'Jawa Tengah'),
+  ('Cirebon', 'Jawa Barat'),
+  ('Bogor', 'Jawa Barat'),
+  ('Depok', 'Jawa Barat'),
+  ('Tangerang', 'Banten'),
+  ('Bekasi', 'Jawa Barat'),
+  ('Kediri', 'Jawa Timur'),
+  ('Madiun', 'Jawa Timur'),
+  ('Purwokerto', 'Jawa Tengah'),
+  ('Pekalongan', 'Jawa Tengah'),
+  ('Tegal', 'Jawa Tengah'),
+  ('Salatiga', 'Jawa Tengah'),
+  ('Magelang', 'Jawa Tengah'),
+  ('Sukabumi', 'Jawa Barat'),
+  ('Tasikmalaya', 'Jawa Barat'),
+  ('Jember', 'Jawa Timur'),
+  ('Banyuwangi', 'Jawa Timur'),
+  ('Lumajang', 'Jawa Timur'),
+  ('Probolinggo', 'Jawa Timur'),
+  ('Pasuruan', 'Jawa Timur'),
+  ('Mojokerto', 'Jawa Timur'),
+  ('Gresik', 'Jawa Timur'),
+  ('Denpasar', 'Bali'),
+  ('Singaraja', 'Bali'),
+  ('Ubud', 'Bali')
+) AS r(name, region)
+WHERE b.name = r.name AND b.city IS NULL;
+
+-- The 'simple' configuration keeps Indonesian street and place names as they are instead of stemming them as English
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+  to_tsvector('simple', name || ' ' || COALESCE(city, '') || ' ' || address)
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_branches_search ON branches USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_branches_city ON branches (LOWER(city));
+CREATE INDEX IF NOT EXISTS idx_branches_region ON branches (LOWER(region));
+CREATE INDEX IF NOT EXISTS idx_branches_name ON branches (name);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS courier_task_declines;
+
+DROP TABLE IF EXISTS courier_tasks;
+
+DROP TYPE IF EXISTS courier_task_status_enum;
+
+DROP TYPE IF EXISTS courier_task_type_enum;
@@ -0,0 +1,59 @@
+CREATE TYPE courier_task_type_enum AS ENUM (
+  'PICKUP',
+  'DELIVERY'
+);
+
+CREATE TYPE courier_task_status_enum AS ENUM (
+  'UNASSIGNED',
+  'ASSIGNED',
+  'ACCEPTED',
+  'COMPLETED',
+  'CANCELLED'
+);
+
+-- Couriers work out of the branches they are assigned to in branch_staff, branch_id is where the task is dispatched from
+CREATE TABLE IF NOT EXISTS courier_tasks (
+  id SERIAL PRIMARY KEY,
+  shipment_id INT NOT NULL,
+  type courier_task_type_enum NOT NULL,
+  branch_id INT DEFAULT NULL,
+  courier_id INT DEFAULT NULL,
+  status courier_task_status_enum NOT NULL DEFAULT 'UNASSIGNED',
+  assigned_by INT DEFAULT NULL, -- empty when assigned automatically
+  assigned_at TIMESTAMP DEFAULT NULL,
+  accepted_at TIMESTAMP DEFAULT NULL,
+  completed_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT fk_courier_tasks_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id),
+  CONSTRAINT fk_courier_tasks_branch FOREIGN KEY (branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_courier_tasks_courier FOREIGN KEY (courier_id) REFERENCES users(id),
+  CONSTRAINT fk_courier_tasks_assigned_by FOREIGN KEY (assigned_by) REFERENCES users(id)
+);
+
+-- A shipment has at most one open task of each type
+CREATE UNIQUE INDEX IF NOT EXISTS idx_courier_tasks_open ON courier_tasks (shipment_id, type) WHERE status IN ('UNASSIGNED', 'ASSIGNED', 'ACCEPTED');
+CREATE INDEX IF NOT EXISTS idx_courier_tasks_courier ON courier_tasks (courier_id, status);
+CREATE INDEX IF NOT EXISTS idx_courier_tasks_branch ON courier_tasks (branch_id, status);
+
+-- The auto-assigner never offers a task again to a courier who declined it
+CREATE TABLE IF NOT EXISTS courier_task_declines (
+  task_id INT NOT NULL,
+  courier_id INT NOT NULL,
+  reason TEXT DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  PRIMARY KEY (task_id, courier_id),
+  CONSTRAINT fk_courier_task_declines_task FOREIGN KEY (task_id) REFERENCES courier_tasks(id) ON DELETE CASCADE,
+  CONSTRAINT fk_courier_task_declines_courier FOREIGN KEY (courier_id) REFERENCES users(id)
+);
+
+-- Shipments already waiting for a courier get an unassigned task for admins to hand out
+INSERT INTO courier_tasks (shipment_id, type, branch_id)
+SELECT id, 'PICKUP', origin_branch_id
+FROM shipments
+WHERE status = 'READY_TO_PICKUP' AND pickup_method = 'PICKUP';
+
+INSERT INTO courier_tasks (shipment_id, type, branch_id)
+SELECT id, 'DELIVERY', current_branch_id
+FROM shipments
+WHERE status = 'IN_TRANSIT' AND current_branch_id IS NOT NULL AND current_branch_id = destination_branch_id;
@@ -0,0 +1,5 @@
+ALTER TABLE courier_tasks DROP COLUMN IF EXISTS estimated_arrival_at;
+ALTER TABLE courier_tasks DROP COLUMN IF EXISTS stop_sequence;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS recipient_longitude;
+ALTER TABLE shipments DROP COLUMN IF EXISTS recipient_latitude;
@@ -0,0 +1,7 @@
+-- Geocoded lazily the first time the shipment is put on a delivery run
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS recipient_latitude DOUBLE PRECISION DEFAULT NULL;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS recipient_longitude DOUBLE PRECISION DEFAULT NULL;
+
+-- Position of the stop in the courier's optimized run, empty until the run is (re)optimized
+ALTER TABLE courier_tasks ADD COLUMN IF NOT EXISTS stop_sequence INT DEFAULT NULL;
+ALTER TABLE courier_tasks ADD COLUMN IF NOT EXISTS estimated_arrival_at TIMESTAMP DEFAULT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS courier_last_locations;
+DROP TABLE IF EXISTS courier_locations;
@@ -0,0 +1,25 @@
+-- GPS pings sent by courier devices, partitioned by day so old pings are dropped a whole partition at a time.
+-- The partitions themselves are created and dropped by the application (helpers/location).
+CREATE TABLE IF NOT EXISTS courier_locations (
+  id BIGSERIAL,
+  courier_id INT NOT NULL,
+  latitude DOUBLE PRECISION NOT NULL,
+  longitude DOUBLE PRECISION NOT NULL,
+  accuracy REAL DEFAULT NULL, -- in meters, as reported by the device
+  recorded_at TIMESTAMP NOT NULL, -- when the device took the fix
+  received_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  PRIMARY KEY (id, recorded_at),
+  CONSTRAINT fk_courier_locations_courier FOREIGN KEY (courier_id) REFERENCES users(id)
+) PARTITION BY RANGE (recorded_at);
+
+CREATE INDEX IF NOT EXISTS idx_courier_locations_courier ON courier_locations (courier_id, recorded_at DESC);
+
+-- Newest ping of every courier, kept apart so tracking never has to scan the partitions
+CREATE TABLE IF NOT EXISTS courier_last_locations (
+  courier_id INT PRIMARY KEY,
+  latitude DOUBLE PRECISION NOT NULL,
+  longitude DOUBLE PRECISION NOT NULL,
+  accuracy REAL DEFAULT NULL,
+  recorded_at TIMESTAMP NOT NULL,
+  CONSTRAINT fk_courier_last_locations_courier FOREIGN KEY (courier_id) REFERENCES users(id)
+);
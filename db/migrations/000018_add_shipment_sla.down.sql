@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_shipments_sla_breached;
+DROP INDEX IF EXISTS idx_shipments_sla_open;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS sla_breached_at;
+ALTER TABLE shipments DROP COLUMN IF EXISTS estimated_delivery_at;
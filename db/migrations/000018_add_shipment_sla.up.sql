@@ -0,0 +1,10 @@
+-- Promised delivery deadline, the end of the estimated delivery day in the destination branch's time zone.
+-- Shipments created before the estimate existed have none and are never flagged late.
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS estimated_delivery_at TIMESTAMP DEFAULT NULL;
+
+-- Set by the SLA monitor once an open shipment passes its deadline, or on delivery when it was delivered late
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS sla_breached_at TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_shipments_sla_open ON shipments (estimated_delivery_at)
+  WHERE sla_breached_at IS NULL AND status NOT IN ('DELIVERED', 'CANCELLED');
+CREATE INDEX IF NOT EXISTS idx_shipments_sla_breached ON shipments (sla_breached_at) WHERE sla_breached_at IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE shipments DROP COLUMN IF EXISTS service_price;
+ALTER TABLE shipments DROP COLUMN IF EXISTS service_type;
+
+DROP TYPE IF EXISTS shipment_service_enum;
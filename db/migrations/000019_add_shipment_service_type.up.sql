@@ -0,0 +1,12 @@
+CREATE TYPE shipment_service_enum AS ENUM (
+  'ECONOMY',
+  'REGULAR',
+  'EXPRESS',
+  'SAME_DAY'
+);
+
+-- Every shipment so far was the regular product
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS service_type shipment_service_enum NOT NULL DEFAULT 'REGULAR';
+
+-- What the service level adds to the base, distance and weight price, negative for economy
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS service_price INT NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_shipments_pickup_window;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS pickup_window_end;
+ALTER TABLE shipments DROP COLUMN IF EXISTS pickup_window_start;
+
+ALTER TABLE branches DROP COLUMN IF EXISTS pickup_slot_capacity;
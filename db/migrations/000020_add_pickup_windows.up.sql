@@ -0,0 +1,9 @@
+-- Courier pickups a branch can take in one time window
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS pickup_slot_capacity INT NOT NULL DEFAULT 20;
+
+-- Time window the sender chose for the courier pickup, empty when any time is fine
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS pickup_window_start TIMESTAMP DEFAULT NULL;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS pickup_window_end TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_shipments_pickup_window ON shipments (origin_branch_id, pickup_window_start)
+  WHERE pickup_window_start IS NOT NULL;
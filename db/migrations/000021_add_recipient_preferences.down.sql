@@ -0,0 +1,5 @@
+ALTER TABLE shipments DROP COLUMN IF EXISTS is_self_collect;
+
+ALTER TABLE shipments DROP COLUMN IF EXISTS delivery_instructions;
+ALTER TABLE shipments DROP COLUMN IF EXISTS delivery_window_end;
+ALTER TABLE shipments DROP COLUMN IF EXISTS delivery_window_start;
@@ -0,0 +1,7 @@
+-- Delivery preferences the recipient left through their access link
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS delivery_window_start TIMESTAMP DEFAULT NULL;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS delivery_window_end TIMESTAMP DEFAULT NULL;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS delivery_instructions TEXT DEFAULT NULL;
+
+-- The recipient collects the package at the destination branch, no courier delivers it
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS is_self_collect BOOLEAN NOT NULL DEFAULT FALSE;
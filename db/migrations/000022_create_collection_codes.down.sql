@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS collection_codes;
+
+-- Postgres cannot drop a single enum value, 'READY_FOR_COLLECTION' stays on shipment_status_enum.
+-- Packages waiting for collection go back to being in transit at their branch
+UPDATE shipments SET status = 'IN_TRANSIT' WHERE status = 'READY_FOR_COLLECTION';
@@ -0,0 +1,12 @@
+-- Self-collect packages wait at their destination branch until the recipient shows their one-time code
+ALTER TYPE shipment_status_enum ADD VALUE IF NOT EXISTS 'READY_FOR_COLLECTION' AFTER 'IN_TRANSIT';
+
+-- Only the latest code of a shipment is kept, a new code replaces it and resets the attempts
+CREATE TABLE IF NOT EXISTS collection_codes (
+  shipment_id INT PRIMARY KEY,
+  code_hash VARCHAR(64) NOT NULL,
+  attempts INT NOT NULL DEFAULT 0, -- wrong codes entered at the counter
+  used_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  CONSTRAINT fk_collection_codes_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id)
+);
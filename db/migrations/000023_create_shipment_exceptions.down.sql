@@ -0,0 +1,13 @@
+ALTER TABLE shipments DROP COLUMN IF EXISTS is_returned_to_sender;
+
+DROP INDEX IF EXISTS idx_shipment_exceptions_queue;
+DROP INDEX IF EXISTS uq_shipment_exceptions_open;
+DROP TABLE IF EXISTS shipment_exceptions;
+
+DROP TYPE IF EXISTS shipment_exception_resolution_enum;
+DROP TYPE IF EXISTS shipment_exception_status_enum;
+DROP TYPE IF EXISTS shipment_exception_type_enum;
+
+-- Postgres cannot drop a single enum value, 'ON_HOLD' and 'WRITTEN_OFF' stay on shipment_status_enum.
+-- Held packages go back to being in transit
+UPDATE shipments SET status = 'IN_TRANSIT' WHERE status = 'ON_HOLD';
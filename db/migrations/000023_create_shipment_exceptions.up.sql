@@ -0,0 +1,49 @@
+-- Held shipments are frozen until their exception is resolved, written off ones never leave the network
+ALTER TYPE shipment_status_enum ADD VALUE IF NOT EXISTS 'ON_HOLD' AFTER 'READY_FOR_COLLECTION';
+ALTER TYPE shipment_status_enum ADD VALUE IF NOT EXISTS 'WRITTEN_OFF' AFTER 'CANCELLED';
+
+CREATE TYPE shipment_exception_type_enum AS ENUM (
+  'DAMAGED',
+  'HELD_FOR_INSPECTION',
+  'ADDRESS_PROBLEM',
+  'LOST'
+);
+
+CREATE TYPE shipment_exception_status_enum AS ENUM (
+  'OPEN',
+  'RESOLVED'
+);
+
+CREATE TYPE shipment_exception_resolution_enum AS ENUM (
+  'RESUME',
+  'RETURN',
+  'WRITE_OFF'
+);
+
+CREATE TABLE IF NOT EXISTS shipment_exceptions (
+  id SERIAL PRIMARY KEY,
+  shipment_id INT NOT NULL,
+  type shipment_exception_type_enum NOT NULL,
+  "desc" TEXT NOT NULL,
+  branch_id INT NOT NULL, -- branch that raised the exception
+  raised_by INT NOT NULL,
+  previous_status shipment_status_enum NOT NULL, -- status the shipment resumes with
+  status shipment_exception_status_enum NOT NULL DEFAULT 'OPEN',
+  resolution shipment_exception_resolution_enum DEFAULT NULL,
+  resolution_note TEXT DEFAULT NULL,
+  resolved_by INT DEFAULT NULL,
+  resolved_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT fk_shipment_exceptions_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id),
+  CONSTRAINT fk_shipment_exceptions_branch FOREIGN KEY (branch_id) REFERENCES branches(id),
+  CONSTRAINT fk_shipment_exceptions_raised_by FOREIGN KEY (raised_by) REFERENCES users(id),
+  CONSTRAINT fk_shipment_exceptions_resolved_by FOREIGN KEY (resolved_by) REFERENCES users(id)
+);
+
+-- A shipment is held by one exception at a time
+CREATE UNIQUE INDEX IF NOT EXISTS uq_shipment_exceptions_open ON shipment_exceptions (shipment_id) WHERE status = 'OPEN';
+CREATE INDEX IF NOT EXISTS idx_shipment_exceptions_queue ON shipment_exceptions (status, created_at);
+
+-- The recipient details were swapped for the sender's to bring the package back
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS is_returned_to_sender BOOLEAN NOT NULL DEFAULT FALSE;
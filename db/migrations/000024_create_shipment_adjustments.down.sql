@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_shipment_adjustments_shipment;
+DROP TABLE IF EXISTS shipment_adjustments;
@@ -0,0 +1,23 @@
+-- Price differences settled after a shipment was corrected, on top of its original payment
+CREATE TABLE IF NOT EXISTS shipment_adjustments (
+  id SERIAL PRIMARY KEY,
+  shipment_id INT NOT NULL,
+  amount INT NOT NULL, -- positive is charged to the sender, negative is credited back to their wallet
+  method payment_method_enum NOT NULL,
+  status payment_status_enum NOT NULL DEFAULT 'PENDING',
+  invoice_id VARCHAR(255) UNIQUE DEFAULT NULL,
+  external_id VARCHAR(255) UNIQUE NOT NULL,
+  invoice_url TEXT DEFAULT NULL,
+  ledger_transaction_id INT DEFAULT NULL,
+  created_by INT NOT NULL,
+  paid_at TIMESTAMP DEFAULT NULL,
+  expired_at TIMESTAMP DEFAULT NULL,
+  created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+  updated_at TIMESTAMP DEFAULT NULL,
+  CONSTRAINT fk_shipment_adjustments_shipment FOREIGN KEY (shipment_id) REFERENCES shipments(id),
+  CONSTRAINT fk_shipment_adjustments_transaction FOREIGN KEY (ledger_transaction_id) REFERENCES ledger_transactions(id),
+  CONSTRAINT fk_shipment_adjustments_user FOREIGN KEY (created_by) REFERENCES users(id),
+  CONSTRAINT chk_shipment_adjustments_amount CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_shipment_adjustments_shipment ON shipment_adjustments (shipment_id);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_shipments_return_of;
+DROP INDEX IF EXISTS uq_shipments_active_return;
+
+ALTER TABLE shipments DROP CONSTRAINT IF EXISTS fk_shipments_return_of;
+ALTER TABLE shipments DROP COLUMN IF EXISTS return_payer;
+ALTER TABLE shipments DROP COLUMN IF EXISTS return_of_shipment_id;
+
+DROP TYPE IF EXISTS return_payer_enum;
@@ -0,0 +1,13 @@
+CREATE TYPE return_payer_enum AS ENUM (
+  'MERCHANT',
+  'RECIPIENT'
+);
+
+-- A return is a shipment of its own sending a delivered package back, sender and recipient swapped
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS return_of_shipment_id INT DEFAULT NULL;
+ALTER TABLE shipments ADD COLUMN IF NOT EXISTS return_payer return_payer_enum DEFAULT NULL;
+ALTER TABLE shipments ADD CONSTRAINT fk_shipments_return_of FOREIGN KEY (return_of_shipment_id) REFERENCES shipments(id);
+
+-- A delivered package is sent back by one return at a time, a cancelled return can be requested again
+CREATE UNIQUE INDEX IF NOT EXISTS uq_shipments_active_return ON shipments (return_of_shipment_id) WHERE status <> 'CANCELLED';
+CREATE INDEX IF NOT EXISTS idx_shipments_return_of ON shipments (return_of_shipment_id);
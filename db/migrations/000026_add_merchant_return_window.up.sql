@@ -0,0 +1,3 @@
+-- Merchants opt in to paying for the returns their recipients request, within this many days of delivery.
+-- Without it recipients pay for the returns they request themselves.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS return_window_days INT DEFAULT NULL CHECK (return_window_days > 0);
@@ -0,0 +1,270 @@
+package adjustment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/wallet"
+	xenditService "github.com/masadamsahid/golang-gin-goldship-api/helpers/xendit-service"
+	"github.com/xendit/xendit-go/v7/invoice"
+)
+
+const ExternalIDPrefix = "ADJ-"
+
+// Supplementary invoices give the sender a day to pay, like wallet top-ups
+const invoiceDuration = 24 * 60 * 60
+
+// ErrRejected is returned while the shipment's payment, statement or last correction is still open.
+var ErrRejected = errors.New("price difference cannot be settled")
+
+const Columns = `
+	id,
+	shipment_id,
+	amount,
+	method,
+	status,
+	invoice_id,
+	external_id,
+	invoice_url,
+	ledger_transaction_id,
+	created_by,
+	paid_at,
+	expired_at,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func Scan(row scanner, a *models.ShipmentAdjustment) error {
+	return row.Scan(
+		&a.ID,
+		&a.ShipmentID,
+		&a.Amount,
+		&a.Method,
+		&a.Status,
+		&a.InvoiceID,
+		&a.ExternalID,
+		&a.InvoiceURL,
+		&a.LedgerTransactionID,
+		&a.CreatedBy,
+		&a.PaidAt,
+		&a.ExpiredAt,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+	)
+}
+
+// ListByShipment returns the shipment's adjustments, oldest first.
+func ListByShipment(q querier, shipmentID int) ([]models.ShipmentAdjustment, error) {
+	rows, err := q.Query(`SELECT `+Columns+` FROM shipment_adjustments WHERE shipment_id = $1 ORDER BY created_at ASC, id ASC`, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var adjustments []models.ShipmentAdjustment
+	for rows.Next() {
+		var a models.ShipmentAdjustment
+		if err := Scan(rows, &a); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+	return adjustments, rows.Err()
+}
+
+// Settle charges or credits the difference between the shipment's new total price and what was collected for it,
+// the original payment p plus the corrections paid since.
+// A postpaid shipment that is not on a monthly statement yet is simply billed the new price, the returned adjustment is nil then,
+// one on a statement that is not paid yet cannot change price.
+// A shipment whose invoice or last correction is still unpaid cannot change price.
+// s must be locked by the caller and already carry its new total price.
+func Settle(tx *sql.Tx, s models.Shipment, p models.Payment, createdBy uint) (*models.ShipmentAdjustment, error) {
+	var pending, paid int
+	sqlGetAdjusted := `
+	SELECT
+		COUNT(*) FILTER (WHERE status = $2),
+		COALESCE(SUM(amount) FILTER (WHERE status = $3), 0)
+	FROM shipment_adjustments
+	WHERE shipment_id = $1
+	`
+	err := tx.QueryRow(sqlGetAdjusted, s.ID, models.PaymentStatusPending, models.PaymentStatusPaid).Scan(&pending, &paid)
+	if err != nil {
+		return nil, err
+	}
+	// Its invoice could still be paid after a new difference is settled, the sender would pay twice
+	if pending > 0 {
+		return nil, fmt.Errorf("%w: the previous price correction is not paid yet, correct the shipment again once it is paid or expired", ErrRejected)
+	}
+
+	difference := s.TotalPrice - (p.Amount + paid)
+	if difference == 0 {
+		return nil, nil
+	}
+
+	if p.Status == models.PaymentStatusPending {
+		if p.Method != models.PaymentMethodPostpaid {
+			return nil, fmt.Errorf("%w: the shipment is not paid yet, cancel it and create a new one with the correct details", ErrRejected)
+		}
+
+		var billed bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM billing_invoice_items WHERE shipment_id = $1)`, s.ID).Scan(&billed)
+		if err != nil {
+			return nil, err
+		}
+		if !billed {
+			return nil, rebill(tx, s, p, difference)
+		}
+		// The statement bills the old price, neither a credit nor a second charge can be squared with it before it is paid
+		return nil, fmt.Errorf("%w: the shipment is on an unpaid monthly statement, correct it once the statement is paid", ErrRejected)
+	}
+
+	// Differences are only settled in cash against money that was actually received
+	if p.Status != models.PaymentStatusPaid {
+		return nil, fmt.Errorf("%w: the shipment payment is %s", ErrRejected, p.Status)
+	}
+
+	externalID := fmt.Sprintf("%s%s-%d", ExternalIDPrefix, s.TrackingNumber, time.Now().UnixNano())
+	switch {
+	case difference < 0:
+		return credit(tx, s, externalID, -difference, createdBy)
+	case p.Method == models.PaymentMethodWallet:
+		return chargeWallet(tx, s, externalID, difference, createdBy)
+	default:
+		return issueInvoice(tx, s, externalID, difference, createdBy)
+	}
+}
+
+// rebill moves the pending postpaid payment to the new price, the monthly statement bills the shipment's total price.
+func rebill(tx *sql.Tx, s models.Shipment, p models.Payment, difference int) error {
+	// The sender row stays locked so concurrent shipments cannot overrun the credit limit together
+	if difference > 0 {
+		var creditLimit int
+		err := tx.QueryRow(`SELECT credit_limit FROM users WHERE id = $1 FOR UPDATE`, s.SenderID).Scan(&creditLimit)
+		if err != nil {
+			return err
+		}
+
+		var outstanding int
+		sqlGetOutstanding := `
+		SELECT COALESCE(SUM(p.amount), 0)
+		FROM payments p
+		JOIN shipments s ON s.id = p.shipment_id
+		WHERE s.sender_id = $1 AND p.method = $2 AND p.status = $3
+		`
+		err = tx.QueryRow(sqlGetOutstanding, s.SenderID, models.PaymentMethodPostpaid, models.PaymentStatusPending).Scan(&outstanding)
+		if err != nil {
+			return err
+		}
+		if outstanding+difference > creditLimit {
+			return fmt.Errorf("%w: the new price exceeds the credit limit", ErrRejected)
+		}
+	}
+
+	_, err := tx.Exec(`UPDATE payments SET amount = amount + $2, updated_at = NOW() WHERE id = $1`, p.ID, difference)
+	return err
+}
+
+// credit refunds an overpayment to the sender's wallet out of the shipment revenue.
+func credit(tx *sql.Tx, s models.Shipment, externalID string, amount int, createdBy uint) (*models.ShipmentAdjustment, error) {
+	account, err := wallet.GetOrCreateUserAccount(tx, uint(s.SenderID))
+	if err != nil {
+		return nil, err
+	}
+	revenueAccountID, err := wallet.GetSystemAccountID(tx, wallet.AccountCodeShipmentRevenue)
+	if err != nil {
+		return nil, err
+	}
+
+	ledgerTx, err := wallet.Transfer(
+		tx,
+		wallet.TransactionTypeAdjustment,
+		externalID,
+		"Price correction credit for shipment "+s.TrackingNumber,
+		revenueAccountID,
+		account.ID,
+		amount,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return insert(tx, s, -amount, models.PaymentMethodWallet, models.PaymentStatusPaid, externalID, nil, nil, &ledgerTx.ID, createdBy)
+}
+
+// chargeWallet takes the difference from the wallet the shipment was paid with.
+func chargeWallet(tx *sql.Tx, s models.Shipment, externalID string, amount int, createdBy uint) (*models.ShipmentAdjustment, error) {
+	account, err := wallet.GetOrCreateUserAccount(tx, uint(s.SenderID))
+	if err != nil {
+		return nil, err
+	}
+	revenueAccountID, err := wallet.GetSystemAccountID(tx, wallet.AccountCodeShipmentRevenue)
+	if err != nil {
+		return nil, err
+	}
+
+	ledgerTx, err := wallet.Transfer(
+		tx,
+		wallet.TransactionTypeAdjustment,
+		externalID,
+		"Price correction for shipment "+s.TrackingNumber,
+		account.ID,
+		revenueAccountID,
+		amount,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return insert(tx, s, amount, models.PaymentMethodWallet, models.PaymentStatusPaid, externalID, nil, nil, &ledgerTx.ID, createdBy)
+}
+
+// issueInvoice bills the difference on a supplementary invoice, it does not hold the shipment back while unpaid.
+func issueInvoice(tx *sql.Tx, s models.Shipment, externalID string, amount int, createdBy uint) (*models.ShipmentAdjustment, error) {
+	createInvoiceReq := *invoice.NewCreateInvoiceRequest(externalID, float64(amount))
+	createInvoiceReq.SetDescription("Price correction for shipment " + s.TrackingNumber)
+	createInvoiceReq.SetInvoiceDuration(invoiceDuration)
+
+	inv, resp, xenditErr := xenditService.Client.InvoiceApi.CreateInvoice(context.Background()).CreateInvoiceRequest(
+		createInvoiceReq,
+	).Execute()
+	if xenditErr != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `InvoiceApi.CreateInvoice``: %v\n", xenditErr.Error())
+
+		b, _ := json.Marshal(xenditErr.FullError())
+		fmt.Fprintf(os.Stderr, "Full Error Struct: %v\n", string(b))
+
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", resp)
+		return nil, xenditErr
+	}
+
+	return insert(tx, s, amount, models.PaymentMethodInvoice, models.PaymentStatusPending, externalID, inv.Id, &inv.InvoiceUrl, nil, createdBy)
+}
+
+func insert(
+	tx *sql.Tx, s models.Shipment, amount int, method string, status models.PaymentStatus,
+	externalID string, invoiceID, invoiceURL *string, ledgerTransactionID *int, createdBy uint,
+) (*models.ShipmentAdjustment, error) {
+	sqlCreateAdjustment := `
+	INSERT INTO shipment_adjustments (shipment_id, amount, method, status, invoice_id, external_id, invoice_url, ledger_transaction_id, created_by, paid_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CASE WHEN $10::BOOLEAN THEN NOW() END)
+	RETURNING ` + Columns
+
+	var a models.ShipmentAdjustment
+	isPaid := status == models.PaymentStatusPaid
+	err := Scan(tx.QueryRow(sqlCreateAdjustment, s.ID, amount, method, status, invoiceID, externalID, invoiceURL, ledgerTransactionID, createdBy, isPaid), &a)
+	return &a, err
+}
@@ -0,0 +1,237 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	xenditService "github.com/masadamsahid/golang-gin-goldship-api/helpers/xendit-service"
+	"github.com/xendit/xendit-go/v7/invoice"
+)
+
+const ExternalIDPrefix = "BILL-"
+
+// Consolidated invoices give business customers two weeks to pay
+const invoiceDuration = 14 * 24 * 60 * 60
+
+var errNothingToBill = errors.New("nothing to bill")
+
+// MonthStart truncates t to the first day of its month (UTC).
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// StartScheduler periodically bills the previous month. Running it more than once per month is harmless
+// because each (user, period) pair can only be invoiced once.
+func StartScheduler() {
+	go func() {
+		for {
+			prevMonth := MonthStart(time.Now()).AddDate(0, -1, 0)
+			invoices, err := RunMonthlyBilling(prevMonth)
+			if err != nil {
+				log.Println("Monthly billing failed:", err)
+			} else if len(invoices) > 0 {
+				log.Printf("Monthly billing issued %d invoice(s) for %s\n", len(invoices), prevMonth.Format("2006-01"))
+			}
+
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// RunMonthlyBilling aggregates every credit account's unbilled POSTPAID shipments created in the month
+// starting at period into one consolidated invoice per account.
+func RunMonthlyBilling(period time.Time) ([]models.BillingInvoice, error) {
+	periodStart := MonthStart(period)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+
+	sqlGetBillableUsers := `
+	SELECT DISTINCT s.sender_id
+	FROM shipments s
+	JOIN payments p ON p.shipment_id = s.id
+	LEFT JOIN billing_invoice_items i ON i.shipment_id = s.id
+	WHERE p.method = $1
+		AND p.status = $2
+		AND i.id IS NULL
+		AND s.created_at >= $3
+		AND s.created_at < $4
+	`
+
+	rows, err := db.DB.Query(sqlGetBillableUsers, models.PaymentMethodPostpaid, models.PaymentStatusPending, periodStart, periodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	invoices := []models.BillingInvoice{}
+	for _, userID := range userIDs {
+		inv, err := billUser(userID, periodStart, periodEnd)
+		if errors.Is(err, errNothingToBill) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed billing user %d for %s: %v\n", userID, periodStart.Format("2006-01"), err)
+			continue
+		}
+		invoices = append(invoices, inv)
+	}
+
+	return invoices, nil
+}
+
+func billUser(userID int, periodStart, periodEnd time.Time) (models.BillingInvoice, error) {
+	var billingInvoice models.BillingInvoice
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return billingInvoice, err
+	}
+	defer tx.Rollback()
+
+	sqlCreateInvoice := `
+	INSERT INTO billing_invoices (user_id, period_start, period_end)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, period_start) DO NOTHING
+	RETURNING id
+	`
+	err = tx.QueryRow(sqlCreateInvoice, userID, periodStart, periodEnd.AddDate(0, 0, -1)).Scan(&billingInvoice.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return billingInvoice, errNothingToBill
+	}
+	if err != nil {
+		return billingInvoice, err
+	}
+
+	sqlCreateItems := `
+	INSERT INTO billing_invoice_items (billing_invoice_id, shipment_id, tracking_number, "desc", amount, shipped_at)
+	SELECT $1, s.id, s.tracking_number, s.item_name || ' to ' || s.recipient_name, s.total_price, s.created_at
+	FROM shipments s
+	JOIN payments p ON p.shipment_id = s.id
+	WHERE s.sender_id = $2
+		AND p.method = $3
+		AND p.status = $4
+		AND s.created_at >= $5
+		AND s.created_at < $6
+	ON CONFLICT (shipment_id) DO NOTHING
+	`
+	_, err = tx.Exec(sqlCreateItems, billingInvoice.ID, userID, models.PaymentMethodPostpaid, models.PaymentStatusPending, periodStart, periodEnd)
+	if err != nil {
+		return billingInvoice, err
+	}
+
+	sqlSumItems := `
+	UPDATE billing_invoices
+	SET amount = (SELECT COALESCE(SUM(amount), 0) FROM billing_invoice_items WHERE billing_invoice_id = $1)
+	WHERE id = $1
+	RETURNING amount
+	`
+	err = tx.QueryRow(sqlSumItems, billingInvoice.ID).Scan(&billingInvoice.Amount)
+	if err != nil {
+		return billingInvoice, err
+	}
+	if billingInvoice.Amount == 0 {
+		return billingInvoice, errNothingToBill
+	}
+
+	billingInvoice, err = IssueInvoice(tx, billingInvoice.ID)
+	if err != nil {
+		return billingInvoice, err
+	}
+
+	err = tx.Commit()
+	return billingInvoice, err
+}
+
+// IssueInvoice creates a Xendit invoice for the billing invoice's current amount and line items and stores
+// its payment details. It is also used to reissue an invoice whose previous payment link expired.
+func IssueInvoice(tx *sql.Tx, billingInvoiceID int) (models.BillingInvoice, error) {
+	var bi models.BillingInvoice
+
+	err := tx.QueryRow(`SELECT id, user_id, period_start, amount FROM billing_invoices WHERE id = $1 FOR UPDATE`, billingInvoiceID).Scan(
+		&bi.ID,
+		&bi.UserID,
+		&bi.PeriodStart,
+		&bi.Amount,
+	)
+	if err != nil {
+		return bi, err
+	}
+
+	rows, err := tx.Query(`SELECT tracking_number, amount FROM billing_invoice_items WHERE billing_invoice_id = $1 ORDER BY shipped_at ASC`, bi.ID)
+	if err != nil {
+		return bi, err
+	}
+
+	var items []invoice.InvoiceItem
+	for rows.Next() {
+		var trackingNumber string
+		var amount int
+		if err := rows.Scan(&trackingNumber, &amount); err != nil {
+			rows.Close()
+			return bi, err
+		}
+		items = append(items, *invoice.NewInvoiceItem("Shipment "+trackingNumber, float32(amount), 1))
+	}
+	rows.Close()
+
+	externalID := fmt.Sprintf("%s%d-%s-%d", ExternalIDPrefix, bi.UserID, bi.PeriodStart.Format("200601"), time.Now().Unix())
+	createInvoiceReq := *invoice.NewCreateInvoiceRequest(externalID, float64(bi.Amount))
+	createInvoiceReq.SetDescription("Goldship shipments for " + bi.PeriodStart.Format("January 2006"))
+	createInvoiceReq.SetInvoiceDuration(invoiceDuration)
+	createInvoiceReq.SetItems(items)
+
+	inv, resp, xenditErr := xenditService.Client.InvoiceApi.CreateInvoice(context.Background()).CreateInvoiceRequest(
+		createInvoiceReq,
+	).Execute()
+	if xenditErr != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `InvoiceApi.CreateInvoice``: %v\n", xenditErr.Error())
+
+		b, _ := json.Marshal(xenditErr.FullError())
+		fmt.Fprintf(os.Stderr, "Full Error Struct: %v\n", string(b))
+
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", resp)
+		return bi, xenditErr
+	}
+
+	sqlUpdateInvoice := `
+	UPDATE billing_invoices
+	SET invoice_id = $2, external_id = $3, invoice_url = $4, status = $5, expired_at = NULL, updated_at = NOW()
+	WHERE id = $1
+	RETURNING id, user_id, period_start, period_end, amount, invoice_id, external_id, invoice_url, status, paid_at, expired_at, created_at, updated_at
+	`
+	err = tx.QueryRow(sqlUpdateInvoice, bi.ID, inv.Id, inv.ExternalId, inv.InvoiceUrl, models.PaymentStatusPending).Scan(
+		&bi.ID,
+		&bi.UserID,
+		&bi.PeriodStart,
+		&bi.PeriodEnd,
+		&bi.Amount,
+		&bi.InvoiceID,
+		&bi.ExternalID,
+		&bi.InvoiceURL,
+		&bi.Status,
+		&bi.PaidAt,
+		&bi.ExpiredAt,
+		&bi.CreatedAt,
+		&bi.UpdatedAt,
+	)
+
+	return bi, err
+}
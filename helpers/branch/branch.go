@@ -0,0 +1,236 @@
+package branch
+
+import (
+	"database/sql"
+	"math"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/lib/pq"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+const Columns = `
+	id,
+	name,
+	phone,
+	address,
+	city,
+	region,
+	latitude,
+	longitude,
+	service_radius,
+	timezone,
+	pickup_slot_capacity,
+	created_at,
+	updated_at,
+	deleted_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func Scan(row scanner, b *models.Branch) error {
+	return row.Scan(
+		&b.ID,
+		&b.Name,
+		&b.Phone,
+		&b.Address,
+		&b.City,
+		&b.Region,
+		&b.Latitude,
+		&b.Longitude,
+		&b.ServiceRadius,
+		&b.Timezone,
+		&b.PickupSlotCapacity,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+		&b.DeletedAt,
+	)
+}
+
+// LoadSchedules fills the operating hours and the holidays from since onwards of every branch in one round trip per table.
+func LoadSchedules(q querier, branches []models.Branch, since time.Time) error {
+	if len(branches) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(branches))
+	byID := make(map[uint]*models.Branch, len(branches))
+	for i := range branches {
+		ids[i] = int64(branches[i].ID)
+		branches[i].OperatingHours = []models.BranchOperatingHour{}
+		branches[i].Holidays = []models.BranchHoliday{}
+		byID[branches[i].ID] = &branches[i]
+	}
+
+	sqlGetHours := `
+	SELECT branch_id, weekday, TO_CHAR(opens_at, 'HH24:MI'), TO_CHAR(closes_at, 'HH24:MI')
+	FROM branch_operating_hours
+	WHERE branch_id = ANY($1)
+	ORDER BY branch_id, weekday
+	`
+	rows, err := q.Query(sqlGetHours, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var branchID uint
+		var h models.BranchOperatingHour
+		if err := rows.Scan(&branchID, &h.Weekday, &h.OpensAt, &h.ClosesAt); err != nil {
+			rows.Close()
+			return err
+		}
+		byID[branchID].OperatingHours = append(byID[branchID].OperatingHours, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sqlGetHolidays := `
+	SELECT branch_id, TO_CHAR(date, 'YYYY-MM-DD'), reason
+	FROM branch_holidays
+	WHERE branch_id = ANY($1) AND date >= $2
+	ORDER BY branch_id, date
+	`
+	rows, err = q.Query(sqlGetHolidays, pq.Array(ids), since.Format(time.DateOnly))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var branchID uint
+		var h models.BranchHoliday
+		if err := rows.Scan(&branchID, &h.Date, &h.Reason); err != nil {
+			return err
+		}
+		byID[branchID].Holidays = append(byID[branchID].Holidays, h)
+	}
+
+	return rows.Err()
+}
+
+// ReplaceSchedule overwrites the branch's operating hours and holidays, a nil slice leaves that part untouched.
+func ReplaceSchedule(tx *sql.Tx, branchID uint, hours []models.BranchOperatingHour, holidays []models.BranchHoliday) error {
+	if hours != nil {
+		_, err := tx.Exec(`DELETE FROM branch_operating_hours WHERE branch_id = $1`, branchID)
+		if err != nil {
+			return err
+		}
+		for _, h := range hours {
+			_, err := tx.Exec(
+				`INSERT INTO branch_operating_hours (branch_id, weekday, opens_at, closes_at) VALUES ($1, $2, $3, $4)`,
+				branchID, h.Weekday, h.OpensAt, h.ClosesAt,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if holidays != nil {
+		_, err := tx.Exec(`DELETE FROM branch_holidays WHERE branch_id = $1`, branchID)
+		if err != nil {
+			return err
+		}
+		for _, h := range holidays {
+			_, err := tx.Exec(
+				`INSERT INTO branch_holidays (branch_id, date, reason) VALUES ($1, $2, $3)`,
+				branchID, h.Date, h.Reason,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Location is the branch's time zone, operating hours and holidays are in branch local time.
+func Location(b models.Branch) *time.Location {
+	loc, err := time.LoadLocation(b.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// SqlIsOpenNow is the SQL counterpart of IsOpenAt for filtering rows of the branches table.
+const SqlIsOpenNow = `
+	EXISTS (
+		SELECT 1 FROM branch_operating_hours h
+		WHERE h.branch_id = branches.id
+			AND h.weekday = EXTRACT(DOW FROM NOW() AT TIME ZONE branches.timezone)
+			AND (NOW() AT TIME ZONE branches.timezone)::TIME >= h.opens_at
+			AND (NOW() AT TIME ZONE branches.timezone)::TIME < h.closes_at
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM branch_holidays d
+		WHERE d.branch_id = branches.id AND d.date = (NOW() AT TIME ZONE branches.timezone)::DATE
+	)
+`
+
+// SqlDistance is the SQL counterpart of Distance, from the point given as the $lat and $lng placeholders.
+func SqlDistance(lat, lng string) string {
+	return `6371000 * 2 * ASIN(SQRT(
+		POWER(SIN(RADIANS(branches.latitude - ` + lat + `) / 2), 2)
+		+ COS(RADIANS(` + lat + `)) * COS(RADIANS(branches.latitude)) * POWER(SIN(RADIANS(branches.longitude - ` + lng + `) / 2), 2)
+	))`
+}
+
+// IsOpenAt needs the branch schedule loaded with LoadSchedules.
+func IsOpenAt(b models.Branch, t time.Time) bool {
+	local := t.In(Location(b))
+
+	today := local.Format(time.DateOnly)
+	for _, h := range b.Holidays {
+		if h.Date == today {
+			return false
+		}
+	}
+
+	now := local.Format("15:04")
+	for _, h := range b.OperatingHours {
+		if h.Weekday == int(local.Weekday()) && h.OpensAt <= now && now < h.ClosesAt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Distance is the great-circle distance in meters between two coordinates.
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371000
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// AllActive reports whether every one of the branches exists and has not been deleted
+func AllActive(q querier, ids ...int) (bool, error) {
+	distinct := map[int]bool{}
+	for _, id := range ids {
+		distinct[id] = true
+	}
+
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM branches WHERE id = ANY($1) AND deleted_at IS NULL`, pq.Array(ids)).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count == len(distinct), nil
+}
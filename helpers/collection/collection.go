@@ -0,0 +1,216 @@
+package collection
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/sla"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/sms"
+)
+
+var (
+	// ErrWrongCode is returned for a code that does not match, the attempt is counted
+	ErrWrongCode = errors.New("wrong collection code")
+	// ErrLocked is returned once the attempts are used up, the recipient has to request a new code
+	ErrLocked = errors.New("too many wrong collection codes, the recipient has to request a new code")
+	// ErrRejected is returned when the package is not waiting for collection, the branch is closed or no usable code was issued
+	ErrRejected = errors.New("collection code is not available")
+)
+
+const (
+	CodeDigits     = 6
+	MaxAttempts    = 5
+	ResendInterval = time.Minute // a new code can be requested this long after the previous one
+)
+
+// Ready is a package that just became ready for collection, the recipient is texted their code once the transaction commits.
+type Ready struct {
+	ShipmentID     int
+	HistoryID      int
+	TrackingNumber string
+	BranchName     string
+	phone          string
+	code           string
+}
+
+// Notify texts the recipient their code in the background so a slow gateway does not hold up the scan.
+// Failures are only logged as the recipient can request a new code.
+func (r *Ready) Notify() {
+	if r == nil {
+		return
+	}
+
+	message := fmt.Sprintf(
+		"%s: your package %s is ready for collection at %s. Show code %s at the counter, do not share it with anyone.",
+		helpers.Company.Name, r.TrackingNumber, r.BranchName, r.code,
+	)
+	go func(shipmentID int, phone string) {
+		if err := sms.Send(phone, message); err != nil {
+			log.Printf("Failed texting the collection code of shipment %d: %v\n", shipmentID, err)
+		}
+	}(r.ShipmentID, r.phone)
+}
+
+func hashCode(shipmentID int, code string) string {
+	sum := sha256.Sum256([]byte(strconv.Itoa(shipmentID) + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueCode replaces the shipment's code with a fresh one and resets its attempts.
+func issueCode(tx *sql.Tx, shipmentID int) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%0*d", CodeDigits, n)
+
+	sqlUpsertCode := `
+	INSERT INTO collection_codes (shipment_id, code_hash)
+	VALUES ($1, $2)
+	ON CONFLICT (shipment_id) DO UPDATE
+	SET code_hash = EXCLUDED.code_hash, attempts = 0, used_at = NULL, created_at = NOW()
+	`
+	_, err = tx.Exec(sqlUpsertCode, shipmentID, hashCode(shipmentID, code))
+	return code, err
+}
+
+// OpenOnArrival makes a self-collect package that reached its destination branch ready for collection and issues its code.
+// Nothing happens for any other package, in which case the returned Ready is nil.
+func OpenOnArrival(tx *sql.Tx, shipmentID, branchID int) (*Ready, error) {
+	var r Ready
+	var destinationBranchID *int
+	var isSelfCollect bool
+	var status string
+	err := tx.QueryRow(
+		`SELECT tracking_number, recipient_phone, destination_branch_id, is_self_collect, status FROM shipments WHERE id = $1`,
+		shipmentID,
+	).Scan(&r.TrackingNumber, &r.phone, &destinationBranchID, &isSelfCollect, &status)
+	if err != nil {
+		return nil, err
+	}
+	if !isSelfCollect || destinationBranchID == nil || *destinationBranchID != branchID {
+		return nil, nil
+	}
+	if status != models.StatusInTransit && status != models.StatusReceivedAtBranch {
+		return nil, nil
+	}
+
+	// Packages are not collected from a closed branch
+	err = tx.QueryRow(`SELECT name FROM branches WHERE id = $1 AND deleted_at IS NULL`, branchID).Scan(&r.BranchName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET status = $2, updated_at = NOW() WHERE id = $1`, shipmentID, models.StatusReadyForCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	// The promise is kept once the package waits at the counter, however long the recipient takes to come
+	err = sla.RecordDelivery(tx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.ShipmentID = shipmentID
+	r.code, err = issueCode(tx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := fmt.Sprintf("Package is ready for collection at branch %s, the recipient was sent a one-time code. Shipment currently is %s", r.BranchName, models.StatusReadyForCollection)
+	err = tx.QueryRow(
+		`INSERT INTO shipment_histories (shipment_id, status, "desc", branch_id) VALUES ($1, $2, $3, $4) RETURNING id`,
+		shipmentID, models.StatusReadyForCollection, desc, branchID,
+	).Scan(&r.HistoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Reissue replaces the code of a package waiting for collection, for a recipient who lost theirs or used up the attempts.
+// s must be locked by the caller.
+func Reissue(tx *sql.Tx, s models.Shipment) (*Ready, error) {
+	if s.Status != models.StatusReadyForCollection || s.DestinationBranchID == nil {
+		return nil, fmt.Errorf("%w: the package is not ready for collection yet", ErrRejected)
+	}
+
+	var issuedAt time.Time
+	err := tx.QueryRow(`SELECT created_at FROM collection_codes WHERE shipment_id = $1`, s.ID).Scan(&issuedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	// Timestamps are stored without a zone, in UTC like NOW()
+	if err == nil && time.Now().UTC().Sub(issuedAt) < ResendInterval {
+		return nil, fmt.Errorf("%w: a code was just sent, wait a minute before requesting another", ErrRejected)
+	}
+
+	r := Ready{ShipmentID: s.ID, TrackingNumber: s.TrackingNumber, phone: s.RecipientPhone}
+	err = tx.QueryRow(`SELECT name FROM branches WHERE id = $1 AND deleted_at IS NULL`, *s.DestinationBranchID).Scan(&r.BranchName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: the collection branch is closed", ErrRejected)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.code, err = issueCode(tx, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Verify checks the code the recipient shows at the counter and marks it used when it matches.
+// A wrong code counts towards MaxAttempts, the caller has to commit even when ErrWrongCode or ErrLocked is returned.
+func Verify(tx *sql.Tx, shipmentID int, code string) error {
+	var codeHash string
+	var attempts int
+	var usedAt *time.Time
+	err := tx.QueryRow(
+		`SELECT code_hash, attempts, used_at FROM collection_codes WHERE shipment_id = $1 FOR UPDATE`,
+		shipmentID,
+	).Scan(&codeHash, &attempts, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: no code was issued for this shipment", ErrRejected)
+	}
+	if err != nil {
+		return err
+	}
+	if usedAt != nil {
+		return fmt.Errorf("%w: the code was already used", ErrRejected)
+	}
+	if attempts >= MaxAttempts {
+		return ErrLocked
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashCode(shipmentID, code)), []byte(codeHash)) != 1 {
+		_, err = tx.Exec(`UPDATE collection_codes SET attempts = attempts + 1 WHERE shipment_id = $1`, shipmentID)
+		if err != nil {
+			return err
+		}
+		if attempts+1 >= MaxAttempts {
+			return ErrLocked
+		}
+		return fmt.Errorf("%w, %d attempt(s) left", ErrWrongCode, MaxAttempts-attempts-1)
+	}
+
+	_, err = tx.Exec(`UPDATE collection_codes SET used_at = NOW() WHERE shipment_id = $1`, shipmentID)
+	return err
+}
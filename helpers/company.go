@@ -0,0 +1,52 @@
+package helpers
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type TaxLine struct {
+	Name        string
+	RatePercent float64
+}
+
+// CompanyProfile is printed on receipts and tax invoices
+type CompanyProfile struct {
+	Name     string
+	Address  string
+	Phone    string
+	TaxID    string
+	TaxLines []TaxLine
+}
+
+var Company = CompanyProfile{
+	Name: "Goldship Logistic",
+}
+
+func InitCompany() {
+	if name := os.Getenv("COMPANY_NAME"); name != "" {
+		Company.Name = name
+	}
+	Company.Address = os.Getenv("COMPANY_ADDRESS")
+	Company.Phone = os.Getenv("COMPANY_PHONE")
+	Company.TaxID = os.Getenv("COMPANY_TAX_ID")
+
+	// e.g. "VAT:11" or "VAT:11,City Tax:1"
+	Company.TaxLines = nil
+	for _, strTaxLine := range strings.Split(os.Getenv("RECEIPT_TAX_LINES"), ",") {
+		if strings.TrimSpace(strTaxLine) == "" {
+			continue
+		}
+
+		name, strRate, ok := strings.Cut(strTaxLine, ":")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(strRate), 64)
+		if !ok || err != nil || rate < 0 {
+			log.Println("Invalid RECEIPT_TAX_LINES entry, skipping:", strTaxLine)
+			continue
+		}
+
+		Company.TaxLines = append(Company.TaxLines, TaxLine{Name: strings.TrimSpace(name), RatePercent: rate})
+	}
+}
@@ -0,0 +1,182 @@
+package dispatch
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+const TaskColumns = `
+	id,
+	shipment_id,
+	type,
+	branch_id,
+	courier_id,
+	status,
+	assigned_by,
+	assigned_at,
+	accepted_at,
+	completed_at,
+	created_at,
+	updated_at,
+	stop_sequence,
+	estimated_arrival_at
+`
+
+// Statuses of a task that still has to be carried out
+var OpenStatuses = []string{models.TaskStatusUnassigned, models.TaskStatusAssigned, models.TaskStatusAccepted}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func ScanTask(row scanner, t *models.CourierTask) error {
+	return row.Scan(
+		&t.ID,
+		&t.ShipmentID,
+		&t.Type,
+		&t.BranchID,
+		&t.CourierID,
+		&t.Status,
+		&t.AssignedBy,
+		&t.AssignedAt,
+		&t.AcceptedAt,
+		&t.CompletedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.StopSequence,
+		&t.EstimatedArrivalAt,
+	)
+}
+
+// CreateTask opens a task for the shipment and hands it to the least busy courier of the branch.
+// When the shipment already has an open task of that type, that one is returned instead.
+func CreateTask(tx *sql.Tx, shipmentID int, taskType string, branchID *int) (models.CourierTask, error) {
+	var task models.CourierTask
+	sqlCreateTask := `
+	INSERT INTO courier_tasks (shipment_id, type, branch_id)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (shipment_id, type) WHERE status IN ('UNASSIGNED', 'ASSIGNED', 'ACCEPTED') DO NOTHING
+	RETURNING ` + TaskColumns
+	err := ScanTask(tx.QueryRow(sqlCreateTask, shipmentID, taskType, branchID), &task)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlGetOpenTask := `
+		SELECT ` + TaskColumns + ` FROM courier_tasks
+		WHERE shipment_id = $1 AND type = $2 AND status IN ('UNASSIGNED', 'ASSIGNED', 'ACCEPTED')
+		`
+		err = ScanTask(tx.QueryRow(sqlGetOpenTask, shipmentID, taskType), &task)
+		return task, err
+	}
+	if err != nil {
+		return task, err
+	}
+
+	err = AutoAssign(tx, &task)
+	return task, err
+}
+
+// CreatePickupTask opens the pickup task of a shipment that just became ready to pick up, drop-offs don't need one.
+func CreatePickupTask(tx *sql.Tx, shipmentID int) error {
+	var pickupMethod string
+	var originBranchID *int
+	err := tx.QueryRow(`SELECT pickup_method, origin_branch_id FROM shipments WHERE id = $1`, shipmentID).Scan(&pickupMethod, &originBranchID)
+	if err != nil || pickupMethod == models.PickupMethodDropOff {
+		return err
+	}
+
+	_, err = CreateTask(tx, shipmentID, models.TaskTypePickup, originBranchID)
+	return err
+}
+
+// CreateDeliveryTaskOnArrival opens the delivery task once the shipment reaches its destination branch.
+// Packages the recipient collects themselves wait at the branch instead, held or written off ones get no task.
+func CreateDeliveryTaskOnArrival(tx *sql.Tx, shipmentID, branchID int) error {
+	var destinationBranchID *int
+	var isSelfCollect bool
+	var status string
+	err := tx.QueryRow(`SELECT destination_branch_id, is_self_collect, status FROM shipments WHERE id = $1`, shipmentID).Scan(&destinationBranchID, &isSelfCollect, &status)
+	if err != nil || destinationBranchID == nil || *destinationBranchID != branchID || isSelfCollect {
+		return err
+	}
+	if status != models.StatusPickedUp && status != models.StatusReceivedAtBranch && status != models.StatusInTransit {
+		return nil
+	}
+
+	_, err = CreateTask(tx, shipmentID, models.TaskTypeDelivery, &branchID)
+	return err
+}
+
+// AutoAssign gives the task to the courier of its branch with the fewest open tasks, skipping couriers who declined it.
+// The task stays unassigned when the branch has no courier available.
+func AutoAssign(tx *sql.Tx, task *models.CourierTask) error {
+	if task.BranchID == nil {
+		return nil
+	}
+
+	sqlPickCourier := `
+	SELECT u.id
+	FROM users u
+	JOIN branch_staff bs ON bs.user_id = u.id
+	WHERE u.role = 'COURIER'
+		AND bs.branch_id = $1
+		AND NOT EXISTS (SELECT 1 FROM courier_task_declines d WHERE d.task_id = $2 AND d.courier_id = u.id)
+	ORDER BY (
+		SELECT COUNT(*) FROM courier_tasks t
+		WHERE t.courier_id = u.id AND t.status IN ('ASSIGNED', 'ACCEPTED')
+	) ASC, u.id ASC
+	LIMIT 1
+	`
+	var courierID int
+	err := tx.QueryRow(sqlPickCourier, *task.BranchID, task.ID).Scan(&courierID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return Assign(tx, task, courierID, nil)
+}
+
+// Assign hands the task to a courier, assignedBy is empty for automatic assignments.
+func Assign(tx *sql.Tx, task *models.CourierTask, courierID int, assignedBy *int) error {
+	sqlAssign := `
+	UPDATE courier_tasks
+	SET courier_id = $2, status = $3, assigned_by = $4, assigned_at = NOW(), accepted_at = NULL,
+		stop_sequence = NULL, estimated_arrival_at = NULL, updated_at = NOW()
+	WHERE id = $1
+	RETURNING ` + TaskColumns
+	return ScanTask(tx.QueryRow(sqlAssign, task.ID, courierID, models.TaskStatusAssigned, assignedBy), task)
+}
+
+// IsAssignedTo reports whether the shipment's open task of that type belongs to the courier.
+func IsAssignedTo(tx *sql.Tx, shipmentID int, taskType string, courierID int) (bool, error) {
+	var assigned bool
+	sqlIsAssigned := `
+	SELECT EXISTS (
+		SELECT 1 FROM courier_tasks
+		WHERE shipment_id = $1 AND type = $2 AND courier_id = $3 AND status IN ('ASSIGNED', 'ACCEPTED')
+	)
+	`
+	err := tx.QueryRow(sqlIsAssigned, shipmentID, taskType, courierID).Scan(&assigned)
+	return assigned, err
+}
+
+// CompleteTask closes the shipment's open task of that type, if any.
+func CompleteTask(tx *sql.Tx, shipmentID int, taskType string) error {
+	_, err := tx.Exec(
+		`UPDATE courier_tasks SET status = $3, completed_at = NOW(), updated_at = NOW() WHERE shipment_id = $1 AND type = $2 AND status IN ('UNASSIGNED', 'ASSIGNED', 'ACCEPTED')`,
+		shipmentID, taskType, models.TaskStatusCompleted,
+	)
+	return err
+}
+
+// CancelTask drops the shipment's open task of that type, if any.
+func CancelTask(tx *sql.Tx, shipmentID int, taskType string) error {
+	_, err := tx.Exec(
+		`UPDATE courier_tasks SET status = $3, updated_at = NOW() WHERE shipment_id = $1 AND type = $2 AND status IN ('UNASSIGNED', 'ASSIGNED', 'ACCEPTED')`,
+		shipmentID, taskType, models.TaskStatusCancelled,
+	)
+	return err
+}
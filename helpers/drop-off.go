@@ -0,0 +1,21 @@
+package helpers
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Taken off the base price of shipments the customer brings to a branch themselves
+var DropOffDiscount = 2000
+
+func InitDropOff() {
+	if strDiscount := os.Getenv("DROP_OFF_DISCOUNT"); strDiscount != "" {
+		discount, err := strconv.Atoi(strDiscount)
+		if err != nil || discount < 0 {
+			log.Println("Invalid DROP_OFF_DISCOUNT, using default:", DropOffDiscount)
+		} else {
+			DropOffDiscount = discount
+		}
+	}
+}
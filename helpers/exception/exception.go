@@ -0,0 +1,252 @@
+package exception
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/dispatch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/inventory"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/network"
+)
+
+// ErrRejected is returned for holds at a branch that does not have the package and for resolutions that no longer apply.
+var ErrRejected = errors.New("exception cannot be applied")
+
+const Columns = `
+	id,
+	shipment_id,
+	type,
+	"desc",
+	branch_id,
+	raised_by,
+	previous_status,
+	status,
+	resolution,
+	resolution_note,
+	resolved_by,
+	resolved_at,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func Scan(row scanner, e *models.ShipmentException) error {
+	return row.Scan(
+		&e.ID,
+		&e.ShipmentID,
+		&e.Type,
+		&e.Desc,
+		&e.BranchID,
+		&e.RaisedBy,
+		&e.PreviousStatus,
+		&e.Status,
+		&e.Resolution,
+		&e.ResolutionNote,
+		&e.ResolvedBy,
+		&e.ResolvedAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+}
+
+// Holdable reports whether the package is inside the network, where staff can hold it
+func Holdable(status string) bool {
+	switch status {
+	case models.StatusPickedUp, models.StatusReceivedAtBranch, models.StatusInTransit, models.StatusReadyForCollection:
+		return true
+	}
+	return false
+}
+
+// Raise puts the shipment ON_HOLD at branchID, where the package sits or its line-haul trip arrives, until the exception is resolved.
+// s must be locked by the caller.
+func Raise(tx *sql.Tx, s models.Shipment, branchID, raisedBy int, exceptionType, desc string) (models.ShipmentException, error) {
+	var e models.ShipmentException
+	if s.Status == models.StatusOnHold {
+		return e, fmt.Errorf("%w: the shipment is already on hold", ErrRejected)
+	}
+	if !Holdable(s.Status) {
+		return e, fmt.Errorf("%w: the shipment is %s, only packages in the network can be held", ErrRejected, s.Status)
+	}
+	if err := checkHeldAt(tx, s, branchID); err != nil {
+		return e, err
+	}
+
+	sqlCreateException := `
+	INSERT INTO shipment_exceptions (shipment_id, type, "desc", branch_id, raised_by, previous_status)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + Columns
+	err := Scan(tx.QueryRow(sqlCreateException, s.ID, exceptionType, desc, branchID, raisedBy, s.Status), &e)
+	if err != nil {
+		return e, err
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET status = $2, updated_at = NOW() WHERE id = $1`, s.ID, models.StatusOnHold)
+	if err != nil {
+		return e, err
+	}
+
+	// Couriers drop the package's open tasks, resuming opens them again
+	for _, taskType := range []string{models.TaskTypePickup, models.TaskTypeDelivery} {
+		if err := dispatch.CancelTask(tx, s.ID, taskType); err != nil {
+			return e, err
+		}
+	}
+
+	return e, inventory.MoveToBranch(tx, s.ID, branchID)
+}
+
+// checkHeldAt makes sure the package is at branchID, or on a dispatched line-haul trip to it.
+// Packages with a courier are held once the courier brings them in.
+func checkHeldAt(tx *sql.Tx, s models.Shipment, branchID int) error {
+	if s.CurrentCourierID != nil {
+		return fmt.Errorf("%w: the package is with a courier, hold it once it is brought to a branch", ErrRejected)
+	}
+	if s.CurrentBranchID != nil {
+		if *s.CurrentBranchID != branchID {
+			return fmt.Errorf("%w: the package is at another branch", ErrRejected)
+		}
+		return nil
+	}
+
+	var destinationBranchID int
+	sqlGetTrip := `
+	SELECT m.destination_branch_id
+	FROM bag_items bi
+	JOIN manifest_bags mb ON mb.bag_id = bi.bag_id
+	JOIN manifests m ON m.id = mb.manifest_id
+	WHERE bi.shipment_id = $1 AND m.status = $2
+	LIMIT 1
+	`
+	err := tx.QueryRow(sqlGetTrip, s.ID, models.ManifestStatusDispatched).Scan(&destinationBranchID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: the package is not at a branch", ErrRejected)
+	}
+	if err != nil {
+		return err
+	}
+	if destinationBranchID != branchID {
+		return fmt.Errorf("%w: the package is on its way to another branch", ErrRejected)
+	}
+	return nil
+}
+
+// Resolve closes the open exception e with action and applies it to the shipment.
+// The returned status is the one the shipment ends up with, s must be locked by the caller.
+func Resolve(tx *sql.Tx, e *models.ShipmentException, s models.Shipment, action string, note *string, resolvedBy int) (string, error) {
+	if e.Status != models.ExceptionStatusOpen {
+		return "", fmt.Errorf("%w: the exception is already resolved", ErrRejected)
+	}
+
+	var status string
+	var err error
+	switch action {
+	case models.ExceptionResolutionResume:
+		status, err = resume(tx, *e, s)
+	case models.ExceptionResolutionReturn:
+		status, err = returnToSender(tx, s)
+	case models.ExceptionResolutionWriteOff:
+		status, err = writeOff(tx, s)
+	default:
+		return "", fmt.Errorf("%w: unknown resolution %s", ErrRejected, action)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sqlResolveException := `
+	UPDATE shipment_exceptions
+	SET status = $2, resolution = $3, resolution_note = $4, resolved_by = $5, resolved_at = NOW(), updated_at = NOW()
+	WHERE id = $1
+	RETURNING ` + Columns
+	err = Scan(tx.QueryRow(sqlResolveException, e.ID, models.ExceptionStatusResolved, action, note, resolvedBy), e)
+	return status, err
+}
+
+// resume puts the shipment back to the status it was held in, with a delivery task again when it waits at its destination.
+func resume(tx *sql.Tx, e models.ShipmentException, s models.Shipment) (string, error) {
+	_, err := tx.Exec(`UPDATE shipments SET status = $2, updated_at = NOW() WHERE id = $1`, s.ID, e.PreviousStatus)
+	if err != nil {
+		return "", err
+	}
+
+	return e.PreviousStatus, dispatch.CreateDeliveryTaskOnArrival(tx, s.ID, e.BranchID)
+}
+
+// returnToSender turns the package around: the sender becomes the recipient and the origin branch its destination.
+func returnToSender(tx *sql.Tx, s models.Shipment) (string, error) {
+	if s.OriginBranchID == nil {
+		return "", fmt.Errorf("%w: the shipment has no origin branch to return to", ErrRejected)
+	}
+	if s.IsReturnedToSender {
+		return "", fmt.Errorf("%w: the package is already on its way back to the sender", ErrRejected)
+	}
+
+	// Every right-hand side reads the row as it was before the update
+	sqlReturnShipment := `
+	UPDATE shipments
+	SET recipient_name = sender_name,
+		recipient_phone = sender_phone,
+		recipient_address = sender_address,
+		recipient_latitude = NULL,
+		recipient_longitude = NULL,
+		destination_branch_id = origin_branch_id,
+		is_self_collect = FALSE,
+		delivery_window_start = NULL,
+		delivery_window_end = NULL,
+		delivery_instructions = NULL,
+		is_returned_to_sender = TRUE,
+		status = $2,
+		updated_at = NOW()
+	WHERE id = $1
+	`
+	_, err := tx.Exec(sqlReturnShipment, s.ID, models.StatusInTransit)
+	if err != nil {
+		return "", err
+	}
+
+	err = dispatch.CancelTask(tx, s.ID, models.TaskTypeDelivery)
+	if err != nil {
+		return "", err
+	}
+
+	if s.CurrentBranchID == nil {
+		return models.StatusInTransit, nil
+	}
+
+	// The package goes back even when no line-haul connects the branches yet
+	_, err = network.PlanShipmentRoute(tx, s.ID, *s.CurrentBranchID, *s.OriginBranchID)
+	if errors.Is(err, network.ErrNoRoute) {
+		log.Printf("No route from branch %d to branch %d for shipment %d\n", *s.CurrentBranchID, *s.OriginBranchID, s.ID)
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Held at the origin already, the courier can take it back to the sender straight away
+	err = dispatch.CreateDeliveryTaskOnArrival(tx, s.ID, *s.CurrentBranchID)
+	return models.StatusInTransit, err
+}
+
+// writeOff takes the package out of the network for good.
+func writeOff(tx *sql.Tx, s models.Shipment) (string, error) {
+	_, err := tx.Exec(`UPDATE shipments SET status = $2, updated_at = NOW() WHERE id = $1`, s.ID, models.StatusWrittenOff)
+	if err != nil {
+		return "", err
+	}
+
+	for _, taskType := range []string{models.TaskTypePickup, models.TaskTypeDelivery} {
+		if err := dispatch.CancelTask(tx, s.ID, taskType); err != nil {
+			return "", err
+		}
+	}
+
+	return models.StatusWrittenOff, inventory.ClearLocation(tx, s.ID)
+}
@@ -2,7 +2,10 @@ package googlemap
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"googlemaps.github.io/maps"
 )
@@ -55,3 +58,68 @@ func CalculateDistance(distanceMatrixRequest *maps.DistanceMatrixRequest) (*maps
 	return &resp.Rows[0].Elements[0].Distance, nil
 
 }
+
+// Geocode resolves a free-text address to its coordinates.
+func Geocode(address string) (*maps.LatLng, error) {
+	mapAPIClient, err := maps.NewClient(maps.WithAPIKey(GOOGLE_MAP_API_KEY))
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := mapAPIClient.Geocode(context.Background(), &maps.GeocodingRequest{Address: address})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.New("address not found")
+	}
+
+	return &results[0].Geometry.Location, nil
+}
+
+// TravelMatrix returns the driving distance in meters and duration between every pair of points, [from][to].
+// Requests are split to stay under the provider's 25 destinations per request limit.
+func TravelMatrix(points []maps.LatLng) ([][]int, [][]time.Duration, error) {
+	mapAPIClient, err := maps.NewClient(maps.WithAPIKey(GOOGLE_MAP_API_KEY))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	const maxDestinations = 25
+	distances := make([][]int, len(points))
+	durations := make([][]time.Duration, len(points))
+	for i, origin := range points {
+		distances[i] = make([]int, len(points))
+		durations[i] = make([]time.Duration, len(points))
+
+		for start := 0; start < len(points); start += maxDestinations {
+			end := min(start+maxDestinations, len(points))
+			var destinations []string
+			for _, p := range points[start:end] {
+				destinations = append(destinations, p.String())
+			}
+
+			resp, err := mapAPIClient.DistanceMatrix(context.Background(), &maps.DistanceMatrixRequest{
+				Origins:      []string{origin.String()},
+				Destinations: destinations,
+				Mode:         maps.TravelModeDriving,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(resp.Rows) != 1 || len(resp.Rows[0].Elements) != end-start {
+				return nil, nil, errors.New("unexpected distance matrix response")
+			}
+
+			for j, element := range resp.Rows[0].Elements {
+				if element.Status != "OK" {
+					return nil, nil, fmt.Errorf("no route between %s and %s: %s", origin.String(), destinations[j], element.Status)
+				}
+				distances[i][start+j] = element.Distance.Meters
+				durations[i][start+j] = element.Duration
+			}
+		}
+	}
+
+	return distances, durations, nil
+}
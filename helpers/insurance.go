@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Defaults used when INSURANCE_RATE_PERCENT / INSURANCE_MIN_PREMIUM are not set
+var insuranceRatePercent = 0.2
+var insuranceMinPremium = 5000
+
+func InitInsurance() {
+	if strRate := os.Getenv("INSURANCE_RATE_PERCENT"); strRate != "" {
+		rate, err := strconv.ParseFloat(strRate, 64)
+		if err != nil || rate < 0 {
+			log.Println("Invalid INSURANCE_RATE_PERCENT, using default:", insuranceRatePercent)
+		} else {
+			insuranceRatePercent = rate
+		}
+	}
+
+	if strMinPremium := os.Getenv("INSURANCE_MIN_PREMIUM"); strMinPremium != "" {
+		minPremium, err := strconv.Atoi(strMinPremium)
+		if err != nil || minPremium < 0 {
+			log.Println("Invalid INSURANCE_MIN_PREMIUM, using default:", insuranceMinPremium)
+		} else {
+			insuranceMinPremium = minPremium
+		}
+	}
+}
+
+// CalculateInsurancePremium charges a percentage of the declared value, but never less than the minimum premium.
+func CalculateInsurancePremium(declaredValue int) int {
+	premium := int(math.Ceil(float64(declaredValue) * insuranceRatePercent / 100))
+	if premium < insuranceMinPremium {
+		premium = insuranceMinPremium
+	}
+	return premium
+}
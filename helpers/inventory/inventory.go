@@ -0,0 +1,48 @@
+package inventory
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+// MoveToBranch records that the package is now sitting at branchID
+func MoveToBranch(tx *sql.Tx, shipmentID, branchID int) error {
+	return setLocation(tx, shipmentID, &branchID, nil)
+}
+
+// MoveToCourier records that the package is now carried by courierID
+func MoveToCourier(tx *sql.Tx, shipmentID, courierID int) error {
+	return setLocation(tx, shipmentID, nil, &courierID)
+}
+
+// ClearLocation is used once the package leaves a branch on a line-haul vehicle or leaves the network for good
+func ClearLocation(tx *sql.Tx, shipmentID int) error {
+	return setLocation(tx, shipmentID, nil, nil)
+}
+
+func setLocation(tx *sql.Tx, shipmentID int, branchID, courierID *int) error {
+	_, err := tx.Exec(
+		`UPDATE shipments SET current_branch_id = $2, current_courier_id = $3, located_at = NOW() WHERE id = $1`,
+		shipmentID, branchID, courierID,
+	)
+	return err
+}
+
+// SealedBag returns the barcode of the sealed or dispatched bag carrying the package, or "" when it is not locked in a bag.
+// Such packages only move with their bag, through the line-haul endpoints.
+func SealedBag(tx *sql.Tx, shipmentID int) (string, error) {
+	var barcode string
+	err := tx.QueryRow(`
+	SELECT b.barcode
+	FROM bag_items bi
+	JOIN bags b ON b.id = bi.bag_id
+	WHERE bi.shipment_id = $1 AND b.status IN ($2, $3)
+	LIMIT 1
+	`, shipmentID, models.BagStatusSealed, models.BagStatusInTransit).Scan(&barcode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return barcode, err
+}
@@ -85,3 +85,45 @@ func ParseJWTUserFromCtx(ctx *gin.Context, userContainer *AuthPayload) error {
 
 	return nil
 }
+
+// Recipient tokens are signed with a key of their own so they can never pass as an auth token
+func recipientTokenKey() []byte {
+	return append([]byte("recipient:"), jwtSecretArrOfByte...)
+}
+
+// CreateRecipientToken signs the recipient access link of a shipment, phoneDigest ties it to the recipient's phone number.
+func CreateRecipientToken(trackingNumber, phoneDigest string, ttl time.Duration) (string, error) {
+	tokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"tn":  trackingNumber,
+		"ph":  phoneDigest,
+		"exp": time.Now().Add(ttl).Unix(),
+	})
+
+	return tokenClaims.SignedString(recipientTokenKey())
+}
+
+// VerifyRecipientToken returns the tracking number and phone digest of a valid, unexpired recipient token.
+func VerifyRecipientToken(strToken string) (string, string, error) {
+	token, err := jwt.Parse(strToken, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+
+		return recipientTokenKey(), nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", errors.New("invalid recipient token claims")
+	}
+	trackingNumber, _ := claims["tn"].(string)
+	phoneDigest, _ := claims["ph"].(string)
+	if trackingNumber == "" || phoneDigest == "" {
+		return "", "", errors.New("invalid recipient token claims")
+	}
+
+	return trackingNumber, phoneDigest, nil
+}
@@ -0,0 +1,257 @@
+package location
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/routing"
+	"googlemaps.github.io/maps"
+)
+
+// Days of courier pings kept before their partition is dropped
+var RetentionDays = 7
+
+// Partitions are created this many days ahead so pings never arrive before their partition exists
+const daysAhead = 2
+
+// Devices may be slightly ahead of the server clock
+const MaxClockSkew = 5 * time.Minute
+
+const partitionPrefix = "courier_locations_"
+
+func InitRetention() {
+	if strDays := os.Getenv("COURIER_LOCATION_RETENTION_DAYS"); strDays != "" {
+		days, err := strconv.Atoi(strDays)
+		if err != nil || days < 1 {
+			log.Println("Invalid COURIER_LOCATION_RETENTION_DAYS, using default:", RetentionDays)
+		} else {
+			RetentionDays = days
+		}
+	}
+}
+
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Accepts reports whether a ping taken at recordedAt can still be stored, older pings would land in a dropped partition
+func Accepts(recordedAt, now time.Time) bool {
+	oldest := dayStart(now).AddDate(0, 0, -RetentionDays)
+	return !recordedAt.Before(oldest) && !recordedAt.After(now.Add(MaxClockSkew))
+}
+
+// Record stores the courier's pings and moves their last-known position forward to the newest of them.
+func Record(tx *sql.Tx, courierID int, pings []models.CourierLocation) error {
+	if len(pings) == 0 {
+		return nil
+	}
+
+	newest := pings[0]
+	for _, p := range pings {
+		_, err := tx.Exec(
+			`INSERT INTO courier_locations (courier_id, latitude, longitude, accuracy, recorded_at) VALUES ($1, $2, $3, $4, $5)`,
+			courierID, p.Latitude, p.Longitude, p.Accuracy, p.RecordedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if p.RecordedAt.After(newest.RecordedAt) {
+			newest = p
+		}
+	}
+
+	// Batches can arrive out of order when the device was offline, so an older batch never moves the position back
+	sqlUpsertLast := `
+	INSERT INTO courier_last_locations (courier_id, latitude, longitude, accuracy, recorded_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (courier_id) DO UPDATE
+	SET latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude, accuracy = EXCLUDED.accuracy, recorded_at = EXCLUDED.recorded_at
+	WHERE courier_last_locations.recorded_at < EXCLUDED.recorded_at
+	`
+	_, err := tx.Exec(sqlUpsertLast, courierID, newest.Latitude, newest.Longitude, newest.Accuracy, newest.RecordedAt)
+	return err
+}
+
+// LastKnown returns the courier's newest ping, sql.ErrNoRows when the courier never sent one
+func LastKnown(q querier, courierID int) (models.CourierLocation, error) {
+	var l models.CourierLocation
+	err := q.QueryRow(
+		`SELECT courier_id, latitude, longitude, accuracy, recorded_at FROM courier_last_locations WHERE courier_id = $1`,
+		courierID,
+	).Scan(&l.CourierID, &l.Latitude, &l.Longitude, &l.Accuracy, &l.RecordedAt)
+	return l, err
+}
+
+// Shipments count as out for delivery once a courier has accepted their delivery task
+const sqlOutForDelivery = `
+SELECT t.courier_id, s.tracking_number, s.recipient_latitude, s.recipient_longitude, t.estimated_arrival_at
+FROM courier_tasks t
+JOIN shipments s ON s.id = t.shipment_id
+WHERE t.type = 'DELIVERY' AND t.status = 'ACCEPTED' AND t.courier_id IS NOT NULL AND s.status = 'IN_TRANSIT'
+`
+
+type delivery struct {
+	courierID      int
+	trackingNumber string
+	recipientLat   *float64
+	recipientLng   *float64
+	plannedArrival *time.Time
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDelivery(row scanner, d *delivery) error {
+	return row.Scan(&d.courierID, &d.trackingNumber, &d.recipientLat, &d.recipientLng, &d.plannedArrival)
+}
+
+// DeliveryPosition returns where the courier carrying out the shipment's delivery was last seen,
+// or nothing when the shipment is not out for delivery or the courier has not sent a ping yet.
+func DeliveryPosition(shipmentID int) (*models.CourierPosition, error) {
+	var d delivery
+	err := scanDelivery(db.DB.QueryRow(sqlOutForDelivery+` AND s.id = $1`, shipmentID), &d)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := LastKnown(db.DB, d.courierID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	position := positionFor(last, d)
+	return &position, nil
+}
+
+// DeliveryPositions gives the position to show on every shipment the courier is out delivering, by tracking number
+func DeliveryPositions(courierID int, last models.CourierLocation) (map[string]models.CourierPosition, error) {
+	rows, err := db.DB.Query(sqlOutForDelivery+` AND t.courier_id = $1`, courierID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	positions := map[string]models.CourierPosition{}
+	for rows.Next() {
+		var d delivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		positions[d.trackingNumber] = positionFor(last, d)
+	}
+	return positions, nil
+}
+
+// positionFor estimates the arrival from the straight-line distance left, falling back to the optimized run's estimate
+// when the recipient address is not geocoded
+func positionFor(last models.CourierLocation, d delivery) models.CourierPosition {
+	position := models.CourierPosition{
+		Latitude:           last.Latitude,
+		Longitude:          last.Longitude,
+		Accuracy:           last.Accuracy,
+		RecordedAt:         last.RecordedAt,
+		EstimatedArrivalAt: d.plannedArrival,
+	}
+	if d.recipientLat != nil && d.recipientLng != nil {
+		distance, duration := routing.Estimate(
+			maps.LatLng{Lat: last.Latitude, Lng: last.Longitude},
+			maps.LatLng{Lat: *d.recipientLat, Lng: *d.recipientLng},
+		)
+		arrival := time.Now().Add(duration)
+		position.DistanceRemaining = &distance
+		position.EstimatedArrivalAt = &arrival
+	}
+	return position
+}
+
+// StartMaintenance creates the partitions needed right away and then keeps creating upcoming ones
+// and dropping expired ones every hour.
+func StartMaintenance() {
+	if err := MaintainPartitions(time.Now()); err != nil {
+		log.Println("Courier location partition maintenance failed:", err)
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Hour)
+			if err := MaintainPartitions(time.Now()); err != nil {
+				log.Println("Courier location partition maintenance failed:", err)
+			}
+		}
+	}()
+}
+
+// MaintainPartitions makes sure there is a daily partition for every day a ping may be recorded on and drops the older ones.
+func MaintainPartitions(now time.Time) error {
+	today := dayStart(now)
+	oldest := today.AddDate(0, 0, -RetentionDays)
+
+	for day := oldest; !day.After(today.AddDate(0, 0, daysAhead)); day = day.AddDate(0, 0, 1) {
+		sqlCreatePartition := fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s PARTITION OF courier_locations FOR VALUES FROM ('%s') TO ('%s')`,
+			partitionPrefix+day.Format("20060102"), day.Format(time.DateOnly), day.AddDate(0, 0, 1).Format(time.DateOnly),
+		)
+		if _, err := db.DB.Exec(sqlCreatePartition); err != nil {
+			return err
+		}
+	}
+
+	sqlGetPartitions := `
+	SELECT c.relname
+	FROM pg_inherits i
+	JOIN pg_class c ON c.oid = i.inhrelid
+	JOIN pg_class p ON p.oid = i.inhparent
+	WHERE p.relname = 'courier_locations'
+	`
+	rows, err := db.DB.Query(sqlGetPartitions)
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		day, err := time.Parse("20060102", strings.TrimPrefix(name, partitionPrefix))
+		if err != nil {
+			continue // not one of ours
+		}
+		if day.Before(oldest) {
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+
+	for _, name := range expired {
+		if _, err := db.DB.Exec(`DROP TABLE IF EXISTS ` + name); err != nil {
+			return err
+		}
+		log.Println("Dropped expired courier location partition", name)
+	}
+
+	return nil
+}
+
+// Partitions are cut at UTC midnight, matching how timestamps are stored
+func dayStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
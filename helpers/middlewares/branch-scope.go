@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+// BranchScopeMiddleware loads the branches the authenticated user is assigned to, it must run after JwtAuthMiddleware.
+func BranchScopeMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var user helpers.AuthPayload
+		err := helpers.ParseJWTUserFromCtx(ctx, &user)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "Unauthorized",
+			})
+			return
+		}
+
+		rows, err := db.DB.Query(`SELECT branch_id FROM branch_staff WHERE user_id = $1 ORDER BY branch_id`, user.ID)
+		if err != nil {
+			log.Println("Failed loading branch assignments", err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		defer rows.Close()
+
+		branchIDs := []int{}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				log.Println("Failed loading branch assignments", err)
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"message": "Internal server error",
+				})
+				return
+			}
+			branchIDs = append(branchIDs, id)
+		}
+
+		ctx.Set("branch_ids", branchIDs)
+		ctx.Set("branch_scoped", user.Role == roles.RoleBranchAdmin)
+
+		ctx.Next()
+	}
+}
+
+// BranchScope returns the caller's assigned branches and whether the caller is limited to them.
+func BranchScope(ctx *gin.Context) ([]int, bool) {
+	branchIDs, _ := ctx.Get("branch_ids")
+	ids, _ := branchIDs.([]int)
+	return ids, ctx.GetBool("branch_scoped")
+}
+
+// CanAccessBranch is true for unscoped callers and for scoped callers assigned to the branch.
+func CanAccessBranch(ctx *gin.Context, branchID int) bool {
+	ids, scoped := BranchScope(ctx)
+	return !scoped || slices.Contains(ids, branchID)
+}
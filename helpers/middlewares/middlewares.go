@@ -72,3 +72,18 @@ func JwtAuthMiddleware(allowedRoles ...string) gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// RecipientOrJwtAuthMiddleware lets recipients in with the token of their access link in the X-Recipient-Token header,
+// everyone else needs an auth token. Handlers must check the recipient token belongs to the shipment they act on.
+func RecipientOrJwtAuthMiddleware(allowedRoles ...string) gin.HandlerFunc {
+	jwtAuth := JwtAuthMiddleware(allowedRoles...)
+	return func(ctx *gin.Context) {
+		if recipientToken := ctx.GetHeader("X-Recipient-Token"); recipientToken != "" {
+			ctx.Set("recipient_token", recipientToken)
+			ctx.Next()
+			return
+		}
+
+		jwtAuth(ctx)
+	}
+}
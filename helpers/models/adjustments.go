@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// ShipmentAdjustment settles the price difference of a corrected shipment
+type ShipmentAdjustment struct {
+	ID                  int           `json:"id"`
+	ShipmentID          int           `json:"shipment_id"`
+	Amount              int           `json:"amount"` // positive is charged to the sender, negative is credited back
+	Method              string        `json:"method"`
+	Status              PaymentStatus `json:"status"`
+	InvoiceID           *string       `json:"invoice_id"`
+	ExternalID          string        `json:"external_id"`
+	InvoiceURL          *string       `json:"invoice_url"` // supplementary invoice, NULL for wallet settlements
+	LedgerTransactionID *int          `json:"ledger_transaction_id"`
+	CreatedBy           int           `json:"created_by"`
+	PaidAt              *time.Time    `json:"paid_at"`
+	ExpiredAt           *time.Time    `json:"expired_at"`
+	CreatedAt           time.Time     `json:"created_at"`
+	UpdatedAt           *time.Time    `json:"updated_at"`
+}
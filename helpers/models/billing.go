@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+type BillingInvoice struct {
+	ID          int           `json:"id"`
+	UserID      int           `json:"user_id"`
+	PeriodStart time.Time     `json:"period_start"`
+	PeriodEnd   time.Time     `json:"period_end"`
+	Amount      int           `json:"amount"`
+	InvoiceID   *string       `json:"invoice_id"`
+	ExternalID  *string       `json:"external_id"`
+	InvoiceURL  *string       `json:"invoice_url"`
+	Status      PaymentStatus `json:"status"`
+	PaidAt      *time.Time    `json:"paid_at"`
+	ExpiredAt   *time.Time    `json:"expired_at"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   *time.Time    `json:"updated_at"`
+
+	User  *User                `json:"user,omitempty"`
+	Items []BillingInvoiceItem `json:"items,omitempty"`
+}
+
+type BillingInvoiceItem struct {
+	ID               int       `json:"id"`
+	BillingInvoiceID int       `json:"billing_invoice_id"`
+	ShipmentID       int       `json:"shipment_id"`
+	TrackingNumber   string    `json:"tracking_number"`
+	Desc             string    `json:"desc"`
+	Amount           int       `json:"amount"`
+	ShippedAt        time.Time `json:"shipped_at"`
+}
@@ -1,10 +1,35 @@
 package models
 
-import common "github.com/masadamsahid/golang-gin-goldship-api/helpers/commons"
+import (
+	"time"
+
+	common "github.com/masadamsahid/golang-gin-goldship-api/helpers/commons"
+)
 
 type Branch struct {
-	Name    string `json:"name"`
-	Phone   string `json:"phone"`
-	Address string `json:"address"`
+	Name               string                `json:"name"`
+	Phone              string                `json:"phone"`
+	Address            string                `json:"address"`
+	City               *string               `json:"city"`
+	Region             *string               `json:"region"`
+	Latitude           *float64              `json:"latitude"`
+	Longitude          *float64              `json:"longitude"`
+	ServiceRadius      *int                  `json:"service_radius"`
+	Timezone           string                `json:"timezone"`
+	PickupSlotCapacity int                   `json:"pickup_slot_capacity"` // courier pickups the branch can take per time window
+	OperatingHours     []BranchOperatingHour `json:"operating_hours,omitempty"`
+	Holidays           []BranchHoliday       `json:"holidays,omitempty"`
+	DeletedAt          *time.Time            `json:"deleted_at,omitempty"`
 	common.BaseEntity
 }
+
+type BranchOperatingHour struct {
+	Weekday  int    `json:"weekday"`
+	OpensAt  string `json:"opens_at"`
+	ClosesAt string `json:"closes_at"`
+}
+
+type BranchHoliday struct {
+	Date   string  `json:"date"`
+	Reason *string `json:"reason"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+const (
+	ClaimTypeLost    = "LOST"
+	ClaimTypeDamaged = "DAMAGED"
+)
+
+const (
+	ClaimStatusOpen     = "OPEN"
+	ClaimStatusApproved = "APPROVED"
+	ClaimStatusRejected = "REJECTED"
+	ClaimStatusPaid     = "PAID"
+)
+
+const (
+	ClaimPayoutWallet       = "WALLET"
+	ClaimPayoutBankTransfer = "BANK_TRANSFER"
+)
+
+type Claim struct {
+	ID             int        `json:"id"`
+	ShipmentID     int        `json:"shipment_id"`
+	ClaimantID     int        `json:"claimant_id"`
+	Type           string     `json:"type"`
+	Desc           string     `json:"desc"`
+	ClaimedAmount  int        `json:"claimed_amount"`
+	ApprovedAmount *int       `json:"approved_amount"`
+	Status         string     `json:"status"`
+	ResolutionNote *string    `json:"resolution_note"`
+	ReviewedBy     *int       `json:"reviewed_by"`
+	ReviewedAt     *time.Time `json:"reviewed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+
+	Shipment  *Shipment       `json:"shipment,omitempty"`
+	Evidences []ClaimEvidence `json:"evidences,omitempty"`
+	Payout    *ClaimPayout    `json:"payout,omitempty"`
+}
+
+type ClaimEvidence struct {
+	ID         int       `json:"id"`
+	ClaimID    int       `json:"claim_id"`
+	URL        string    `json:"url"`
+	Desc       *string   `json:"desc"`
+	UploadedBy int       `json:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ClaimPayout struct {
+	ID                  int       `json:"id"`
+	ClaimID             int       `json:"claim_id"`
+	Amount              int       `json:"amount"`
+	Method              string    `json:"method"`
+	Reference           string    `json:"reference"`
+	LedgerTransactionID *int      `json:"ledger_transaction_id"`
+	PaidBy              int       `json:"paid_by"`
+	PaidAt              time.Time `json:"paid_at"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import "time"
+
+const (
+	TaskTypePickup   = "PICKUP"
+	TaskTypeDelivery = "DELIVERY"
+
+	TaskStatusUnassigned = "UNASSIGNED"
+	TaskStatusAssigned   = "ASSIGNED"
+	TaskStatusAccepted   = "ACCEPTED"
+	TaskStatusCompleted  = "COMPLETED"
+	TaskStatusCancelled  = "CANCELLED"
+)
+
+type CourierTask struct {
+	ID          int        `json:"id"`
+	ShipmentID  int        `json:"shipment_id"`
+	Type        string     `json:"type"`
+	BranchID    *int       `json:"branch_id"`
+	CourierID   *int       `json:"courier_id"`
+	Status      string     `json:"status"`
+	AssignedBy  *int       `json:"assigned_by"` // empty when assigned automatically
+	AssignedAt  *time.Time `json:"assigned_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+
+	StopSequence       *int       `json:"stop_sequence"` // position in the courier's optimized run
+	EstimatedArrivalAt *time.Time `json:"estimated_arrival_at"`
+
+	Shipment *CourierTaskShipment `json:"shipment,omitempty"`
+}
+
+// CourierTaskShipment is what the courier needs to know to carry out the task,
+// the contact and address are the sender's for pickups and the recipient's for deliveries
+type CourierTaskShipment struct {
+	TrackingNumber string  `json:"tracking_number"`
+	Status         string  `json:"status"`
+	ServiceType    string  `json:"service_type"`
+	ContactName    string  `json:"contact_name"`
+	ContactPhone   string  `json:"contact_phone"`
+	Address        string  `json:"address"`
+	ItemName       string  `json:"item_name"`
+	ItemWeight     float64 `json:"item_weight"`
+	// PickupWindowStart and PickupWindowEnd are the sender's booked pickup window, only set for pickups
+	PickupWindowStart *string `json:"pickup_window_start,omitempty"`
+	PickupWindowEnd   *string `json:"pickup_window_end,omitempty"`
+	// DeliveryWindowStart, DeliveryWindowEnd and Instructions are what the recipient asked for, only set for deliveries
+	DeliveryWindowStart *string `json:"delivery_window_start,omitempty"`
+	DeliveryWindowEnd   *string `json:"delivery_window_end,omitempty"`
+	Instructions        *string `json:"instructions,omitempty"`
+}
+
+// Where an optimized run starts from
+const (
+	RouteStartBranch   = "BRANCH"
+	RouteStartPosition = "POSITION"  // the courier's latest GPS ping
+	RouteStartLastStop = "LAST_STOP" // the last delivery the courier completed today
+)
+
+type RoutePlan struct {
+	StartBranchID  int         `json:"start_branch_id"` // also sets which day the run is for
+	Start          string      `json:"start"`
+	StartLatitude  float64     `json:"start_latitude"`
+	StartLongitude float64     `json:"start_longitude"`
+	StartedAt      time.Time   `json:"started_at"`
+	Source         string      `json:"source"`         // "google" or "haversine" when the distance provider was unavailable
+	TotalDistance  int         `json:"total_distance"` // in meters
+	Stops          []RouteStop `json:"stops"`
+	Unlocated      []int       `json:"unlocated_task_ids"` // deliveries whose address could not be geocoded, left out of the run
+	Deferred       []int       `json:"deferred_task_ids"`  // deliveries the recipient asked for on a later day, left out of the run
+}
+
+type RouteStop struct {
+	Sequence             int       `json:"sequence"`
+	TaskID               int       `json:"task_id"`
+	ShipmentID           int       `json:"shipment_id"`
+	TrackingNumber       string    `json:"tracking_number"`
+	RecipientName        string    `json:"recipient_name"`
+	RecipientPhone       string    `json:"recipient_phone"`
+	Address              string    `json:"address"`
+	Latitude             float64   `json:"latitude"`
+	Longitude            float64   `json:"longitude"`
+	DistanceFromPrevious int       `json:"distance_from_previous"` // in meters
+	EstimatedArrivalAt   time.Time `json:"estimated_arrival_at"`
+}
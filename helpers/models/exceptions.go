@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	ExceptionTypeDamaged           = "DAMAGED"
+	ExceptionTypeHeldForInspection = "HELD_FOR_INSPECTION"
+	ExceptionTypeAddressProblem    = "ADDRESS_PROBLEM"
+	ExceptionTypeLost              = "LOST"
+
+	ExceptionStatusOpen     = "OPEN"
+	ExceptionStatusResolved = "RESOLVED"
+
+	ExceptionResolutionResume   = "RESUME"    // carry on from where the shipment was held
+	ExceptionResolutionReturn   = "RETURN"    // bring the package back to the sender
+	ExceptionResolutionWriteOff = "WRITE_OFF" // the package is lost or destroyed
+)
+
+// ShipmentException puts a shipment ON_HOLD until an admin resolves it
+type ShipmentException struct {
+	ID             int        `json:"id"`
+	ShipmentID     int        `json:"shipment_id"`
+	Type           string     `json:"type"`
+	Desc           string     `json:"desc"`
+	BranchID       int        `json:"branch_id"`
+	RaisedBy       int        `json:"raised_by"`
+	PreviousStatus string     `json:"previous_status"` // status the shipment had when it was held
+	Status         string     `json:"status"`
+	Resolution     *string    `json:"resolution"`
+	ResolutionNote *string    `json:"resolution_note"`
+	ResolvedBy     *int       `json:"resolved_by"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+
+	Shipment *Shipment `json:"shipment,omitempty"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+const (
+	StockTakeFound      = "FOUND"
+	StockTakeMissing    = "MISSING"
+	StockTakeUnexpected = "UNEXPECTED"
+)
+
+type InventoryItem struct {
+	ShipmentID          int       `json:"shipment_id"`
+	TrackingNumber      string    `json:"tracking_number"`
+	Status              string    `json:"status"`
+	RecipientName       string    `json:"recipient_name"`
+	RecipientAddress    string    `json:"recipient_address"`
+	OriginBranchID      *int      `json:"origin_branch_id"`
+	DestinationBranchID *int      `json:"destination_branch_id"`
+	LocatedAt           time.Time `json:"located_at"`  // when the package arrived at the branch
+	AgeMinutes          int       `json:"age_minutes"` // time since arrival
+}
+
+type StockTake struct {
+	ID              int       `json:"id"`
+	BranchID        int       `json:"branch_id"`
+	TakenBy         int       `json:"taken_by"`
+	ExpectedCount   int       `json:"expected_count"`
+	ScannedCount    int       `json:"scanned_count"`
+	MissingCount    int       `json:"missing_count"`
+	UnexpectedCount int       `json:"unexpected_count"`
+	CreatedAt       time.Time `json:"created_at"`
+
+	Items []StockTakeItem `json:"items,omitempty"`
+}
+
+type StockTakeItem struct {
+	TrackingNumber  string `json:"tracking_number"`
+	ShipmentID      *int   `json:"shipment_id"` // empty when the tracking number does not exist
+	Result          string `json:"result"`
+	CurrentBranchID *int   `json:"current_branch_id,omitempty"` // where an unexpected package is supposed to be
+}
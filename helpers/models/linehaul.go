@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+const (
+	BagStatusOpen      = "OPEN"
+	BagStatusSealed    = "SEALED"
+	BagStatusInTransit = "IN_TRANSIT"
+	BagStatusArrived   = "ARRIVED"
+
+	ManifestStatusDraft      = "DRAFT"
+	ManifestStatusDispatched = "DISPATCHED"
+	ManifestStatusArrived    = "ARRIVED"
+)
+
+type Bag struct {
+	ID                  int        `json:"id"`
+	Barcode             string     `json:"barcode"`
+	OriginBranchID      int        `json:"origin_branch_id"`
+	DestinationBranchID int        `json:"destination_branch_id"`
+	Status              string     `json:"status"`
+	CreatedBy           int        `json:"created_by"`
+	SealedAt            *time.Time `json:"sealed_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           *time.Time `json:"updated_at"`
+
+	Shipments []BagShipment `json:"shipments,omitempty"`
+}
+
+type BagShipment struct {
+	ShipmentID     int       `json:"shipment_id"`
+	TrackingNumber string    `json:"tracking_number"`
+	Status         string    `json:"status"`
+	AddedAt        time.Time `json:"added_at"`
+}
+
+type Manifest struct {
+	ID                  int        `json:"id"`
+	ManifestNumber      string     `json:"manifest_number"`
+	OriginBranchID      int        `json:"origin_branch_id"`
+	DestinationBranchID int        `json:"destination_branch_id"`
+	VehiclePlate        string     `json:"vehicle_plate"`
+	DriverName          string     `json:"driver_name"`
+	Status              string     `json:"status"`
+	CreatedBy           int        `json:"created_by"`
+	DispatchedAt        *time.Time `json:"dispatched_at"`
+	ArrivedAt           *time.Time `json:"arrived_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           *time.Time `json:"updated_at"`
+
+	Bags []Bag `json:"bags,omitempty"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type CourierLocation struct {
+	CourierID  int       `json:"courier_id"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	Accuracy   *float64  `json:"accuracy"` // in meters
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// CourierPosition is shown on tracking while the shipment is out for delivery
+type CourierPosition struct {
+	Latitude           float64    `json:"latitude"`
+	Longitude          float64    `json:"longitude"`
+	Accuracy           *float64   `json:"accuracy"`
+	RecordedAt         time.Time  `json:"recorded_at"`
+	DistanceRemaining  *int       `json:"distance_remaining"` // straight-line meters to the recipient, empty when the address is not geocoded yet
+	EstimatedArrivalAt *time.Time `json:"estimated_arrival_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type BranchLink struct {
+	ID             int        `json:"id"`
+	FromBranchID   int        `json:"from_branch_id"`
+	ToBranchID     int        `json:"to_branch_id"`
+	TransitMinutes int        `json:"transit_minutes"`
+	Cost           int        `json:"cost"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at"`
+}
+
+type ShipmentRouteLeg struct {
+	ID             int        `json:"id"`
+	ShipmentID     int        `json:"shipment_id"`
+	Sequence       int        `json:"sequence"`
+	FromBranchID   int        `json:"from_branch_id"`
+	ToBranchID     int        `json:"to_branch_id"`
+	TransitMinutes int        `json:"transit_minutes"`
+	Cost           int        `json:"cost"`
+	ArrivedAt      *time.Time `json:"arrived_at"`
+}
@@ -32,6 +32,12 @@ const (
 	PaymentStatusCancelled PaymentStatus = "CANCELLED"
 )
 
+const (
+	PaymentMethodInvoice  = "INVOICE"
+	PaymentMethodWallet   = "WALLET"
+	PaymentMethodPostpaid = "POSTPAID"
+)
+
 type Payment struct {
 	ID         int           `json:"id"`
 	ShipmentID int           `json:"shipment_id"`
@@ -40,7 +46,8 @@ type Payment struct {
 	ExpiredAt  *time.Time    `json:"expired_at"`
 	InvoiceID  string        `json:"invoice_id"`
 	ExternalID string        `json:"external_id"`
-	InvoiceURL string        `json:"invoice_url"`
+	InvoiceURL *string       `json:"invoice_url"` // NULL for wallet payments
+	Method     string        `json:"method"`
 	Status     PaymentStatus `json:"status"`
 	CreatedAt  time.Time     `json:"created_at"`
 	UpdatedAt  *time.Time    `json:"updated_at"`
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+const (
+	DiscountTypePercentage = "PERCENTAGE"
+	DiscountTypeFixed      = "FIXED"
+)
+
+type Promotion struct {
+	ID                 int        `json:"id"`
+	Code               string     `json:"code"`
+	Desc               string     `json:"desc"`
+	DiscountType       string     `json:"discount_type"`
+	DiscountValue      int        `json:"discount_value"`
+	MaxDiscount        *int       `json:"max_discount"`
+	MinSpend           int        `json:"min_spend"`
+	UsageLimit         *int       `json:"usage_limit"`
+	PerUserLimit       *int       `json:"per_user_limit"`
+	UsedCount          int        `json:"used_count"`
+	MinDistance        *int       `json:"min_distance"` // in meters
+	MaxDistance        *int       `json:"max_distance"` // in meters
+	MinWeight          *float64   `json:"min_weight"`   // in KG
+	MaxWeight          *float64   `json:"max_weight"`   // in KG
+	OriginKeyword      *string    `json:"origin_keyword"`
+	DestinationKeyword *string    `json:"destination_keyword"`
+	ValidFrom          time.Time  `json:"valid_from"`
+	ValidUntil         time.Time  `json:"valid_until"`
+	IsActive           bool       `json:"is_active"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          *time.Time `json:"updated_at"`
+}
+
+type PromotionRedemption struct {
+	ID          int       `json:"id"`
+	PromotionID int       `json:"promotion_id"`
+	UserID      int       `json:"user_id"`
+	ShipmentID  int       `json:"shipment_id"`
+	Discount    int       `json:"discount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
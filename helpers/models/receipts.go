@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+type Receipt struct {
+	ID            int       `json:"id"`
+	ShipmentID    int       `json:"shipment_id"`
+	ReceiptNumber string    `json:"receipt_number"`
+	IssuedAt      time.Time `json:"issued_at"`
+}
@@ -3,38 +3,91 @@ package models
 import "time"
 
 const (
-	StatusPendingPayment = "PENDING_PAYMENT"
-	StatusReadyToPickup  = "READY_TO_PICKUP"
-	StatusPickedUp       = "PICKED_UP"
-	StatusInTransit      = "IN_TRANSIT"
-	StatusDelivered      = "DELIVERED"
-	StatusCancelled      = "CANCELLED"
+	StatusPendingPayment     = "PENDING_PAYMENT"
+	StatusReadyToPickup      = "READY_TO_PICKUP"
+	StatusPickedUp           = "PICKED_UP"
+	StatusReceivedAtBranch   = "RECEIVED_AT_BRANCH"
+	StatusInTransit          = "IN_TRANSIT"
+	StatusReadyForCollection = "READY_FOR_COLLECTION" // waiting at the destination branch for the recipient to collect
+	StatusOnHold             = "ON_HOLD"              // frozen until its exception is resolved
+	StatusDelivered          = "DELIVERED"
+	StatusCancelled          = "CANCELLED"
+	StatusWrittenOff         = "WRITTEN_OFF" // lost or destroyed in the network
+
+	PickupMethodCourier = "PICKUP"
+	PickupMethodDropOff = "DROP_OFF"
+
+	ServiceEconomy = "ECONOMY"
+	ServiceRegular = "REGULAR"
+	ServiceExpress = "EXPRESS"
+	ServiceSameDay = "SAME_DAY"
+
+	ReturnPayerMerchant  = "MERCHANT" // the sender of the delivered shipment, who receives the return
+	ReturnPayerRecipient = "RECIPIENT"
 )
 
 type Shipment struct {
-	ID               int     `json:"id"`
-	TrackingNumber   string  `json:"tracking_number"`
-	SenderID         int     `json:"sender_id"`
-	SenderName       string  `json:"sender_name"`
-	SenderPhone      string  `json:"sender_phone"`
-	SenderAddress    string  `json:"sender_address"`
-	RecipientName    string  `json:"recipient_name"`
-	RecipientAddress string  `json:"recipient_address"`
-	RecipientPhone   string  `json:"recipient_phone"`
-	ItemName         string  `json:"item_name"`
-	ItemWeight       float64 `json:"item_weight"`
-	Distance         float64 `json:"distance"`
-	BasePrice        int     `json:"base_price"`
-	DistancePrice    int     `json:"distance_price"`
-	WeightPrice      int     `json:"weight_price"`
-	TotalPrice       int     `json:"total_price"`
-	Status           string  `json:"status"`
-	CreatedAt        string  `json:"created_at"`
-	UpdatedAt        *string `json:"updated_at"` // Use pointer for nullable timestamp
-
-	Sender    *User             `json:"sender,omitempty"`
-	Payment   *Payment          `json:"payment"`
-	Histories []ShipmentHistory `json:"histories"`
+	ID                int     `json:"id"`
+	TrackingNumber    string  `json:"tracking_number"`
+	SenderID          int     `json:"sender_id"`
+	SenderName        string  `json:"sender_name"`
+	SenderPhone       string  `json:"sender_phone"`
+	SenderAddress     string  `json:"sender_address"`
+	RecipientName     string  `json:"recipient_name"`
+	RecipientAddress  string  `json:"recipient_address"`
+	RecipientPhone    string  `json:"recipient_phone"`
+	ItemName          string  `json:"item_name"`
+	ItemWeight        float64 `json:"item_weight"`
+	DeclaredValue     int     `json:"declared_value"`
+	IsInsured         bool    `json:"is_insured"`
+	Distance          float64 `json:"distance"`
+	BasePrice         int     `json:"base_price"`
+	DistancePrice     int     `json:"distance_price"`
+	WeightPrice       int     `json:"weight_price"`
+	DiscountPrice     int     `json:"discount_price"`
+	InsurancePrice    int     `json:"insurance_price"`
+	ServicePrice      int     `json:"service_price"` // service level surcharge, negative for economy
+	TotalPrice        int     `json:"total_price"`
+	PromoCode         *string `json:"promo_code"`
+	PickupMethod      string  `json:"pickup_method"`
+	ServiceType       string  `json:"service_type"`
+	PickupWindowStart *string `json:"pickup_window_start"` // chosen courier pickup window, empty when any time is fine
+	PickupWindowEnd   *string `json:"pickup_window_end"`
+	// Preferences the recipient left through their access link
+	DeliveryWindowStart  *string `json:"delivery_window_start"`
+	DeliveryWindowEnd    *string `json:"delivery_window_end"`
+	DeliveryInstructions *string `json:"delivery_instructions"`
+	IsSelfCollect        bool    `json:"is_self_collect"`       // the recipient collects the package at the destination branch
+	IsReturnedToSender   bool    `json:"is_returned_to_sender"` // on its way back, the recipient details are the sender's
+	ReturnOfShipmentID   *int    `json:"return_of_shipment_id"` // delivered shipment this return sends back
+	ReturnPayer          *string `json:"return_payer"`          // who pays for a return, MERCHANT or RECIPIENT
+	OriginBranchID       *int    `json:"origin_branch_id"`      // first branch the package enters the network at
+	DestinationBranchID  *int    `json:"destination_branch_id"` // branch closest to the recipient
+	CurrentBranchID      *int    `json:"current_branch_id"`     // branch the package is sitting at right now
+	CurrentCourierID     *int    `json:"current_courier_id"`    // courier carrying the package right now
+	LocatedAt            *string `json:"located_at"`
+	EstimatedDeliveryAt  *string `json:"estimated_delivery_at"` // promised delivery deadline
+	SlaBreachedAt        *string `json:"sla_breached_at"`
+	Status               string  `json:"status"`
+	CreatedAt            string  `json:"created_at"`
+	UpdatedAt            *string `json:"updated_at"` // Use pointer for nullable timestamp
+
+	Sender      *User                `json:"sender,omitempty"`
+	Payment     *Payment             `json:"payment"`
+	Adjustments []ShipmentAdjustment `json:"adjustments,omitempty"` // price differences settled after corrections
+	Histories   []ShipmentHistory    `json:"histories"`
+	RouteLegs   []ShipmentRouteLeg   `json:"route_legs,omitempty"`
+
+	ReturnOf *LinkedShipment  `json:"return_of,omitempty"` // the delivered shipment, on a return
+	Returns  []LinkedShipment `json:"returns,omitempty"`   // returns requested for a delivered shipment
+}
+
+// LinkedShipment points at the other side of a return, enough to track it
+type LinkedShipment struct {
+	ID             int    `json:"id"`
+	TrackingNumber string `json:"tracking_number"`
+	Status         string `json:"status"`
+	CreatedAt      string `json:"created_at"`
 }
 
 type ShipmentHistory struct {
@@ -44,6 +97,7 @@ type ShipmentHistory struct {
 	Desc       string `json:"desc"`
 	CourierID  *int   `json:"courier_id"` // Use pointer for nullable foreign key
 	BranchID   *int   `json:"branch_id"`  // Use pointer for nullable foreign key
+	IsOffRoute bool   `json:"is_off_route"`
 	Timestamp  string `json:"timestamp"`
 
 	Shipment *Shipment `json:"shipment,omitempty"`
@@ -69,3 +123,57 @@ type ShCourier struct {
 	CreatedAt *time.Time `json:"created_at,omitempty"`
 	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
+
+// ShipmentQuote is the price and promised delivery date of one service level for a prospective shipment
+type ShipmentQuote struct {
+	ServiceType         string     `json:"service_type"`
+	ServiceName         string     `json:"service_name"`
+	Eligible            bool       `json:"eligible"`
+	Reason              *string    `json:"reason"` // why the service is not available
+	BasePrice           int        `json:"base_price"`
+	DistancePrice       int        `json:"distance_price"`
+	WeightPrice         int        `json:"weight_price"`
+	ServicePrice        int        `json:"service_price"`
+	TotalPrice          int        `json:"total_price"` // before promotions and insurance
+	EstimatedDeliveryAt *time.Time `json:"estimated_delivery_at"`
+}
+
+type PickupSlot struct {
+	BranchID  int       `json:"branch_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Capacity  int       `json:"capacity"`
+	Booked    int       `json:"booked"`
+	Available int       `json:"available"`
+}
+
+// RecipientLink lets the recipient manage the delivery without an account
+type RecipientLink struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CollectionPoint is a branch the recipient can redirect the package to and collect it from
+type CollectionPoint struct {
+	Branch
+	Distance int `json:"distance"` // from the recipient address, in meters
+}
+
+// RecipientShipment is what the recipient sees through their access link
+type RecipientShipment struct {
+	ShipmentID           int               `json:"shipment_id"` // to request a return with POST /api/shipments/{id}/return
+	TrackingNumber       string            `json:"tracking_number"`
+	RecipientName        string            `json:"recipient_name"`
+	RecipientAddress     string            `json:"recipient_address"`
+	Status               string            `json:"status"`
+	ServiceType          string            `json:"service_type"`
+	EstimatedDeliveryAt  *string           `json:"estimated_delivery_at"`
+	DeliveryWindowStart  *string           `json:"delivery_window_start"`
+	DeliveryWindowEnd    *string           `json:"delivery_window_end"`
+	DeliveryInstructions *string           `json:"delivery_instructions"`
+	IsSelfCollect        bool              `json:"is_self_collect"`
+	CollectionBranch     *ShBranch         `json:"collection_branch"` // where to collect the package, only for self-collection
+	CollectionPoints     []CollectionPoint `json:"collection_points"` // branches the package can be redirected to
+	Returns              []LinkedShipment  `json:"returns,omitempty"` // return shipments sending the package back
+}
@@ -9,6 +9,10 @@ type User struct {
 	Email    string `json:"email"`
 	Password string `json:"password,omitempty"`
 	Role     string `json:"string"`
+
+	IsCreditAccount  bool `json:"is_credit_account"`
+	CreditLimit      int  `json:"credit_limit"`
+	ReturnWindowDays *int `json:"return_window_days"` // days after delivery the merchant pays for returns requested by recipients
 	common.BaseEntity
 }
 
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+const (
+	LedgerAccountUserWallet      = "USER_WALLET"
+	LedgerAccountPaymentGateway  = "PAYMENT_GATEWAY"
+	LedgerAccountShipmentRevenue = "SHIPMENT_REVENUE"
+	LedgerAccountInsuranceClaims = "INSURANCE_CLAIMS"
+)
+
+const (
+	LedgerDebit  = "DEBIT"
+	LedgerCredit = "CREDIT"
+)
+
+type LedgerAccount struct {
+	ID        int        `json:"id"`
+	Code      string     `json:"code"`
+	UserID    *int       `json:"user_id"`
+	Type      string     `json:"type"`
+	Balance   int        `json:"balance"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type LedgerTransaction struct {
+	ID        int           `json:"id"`
+	Type      string        `json:"type"`
+	Reference string        `json:"reference"`
+	Desc      string        `json:"desc"`
+	CreatedAt time.Time     `json:"created_at"`
+	Entries   []LedgerEntry `json:"entries,omitempty"`
+}
+
+type LedgerEntry struct {
+	ID            int       `json:"id"`
+	TransactionID int       `json:"transaction_id"`
+	AccountID     int       `json:"account_id"`
+	Direction     string    `json:"direction"`
+	Amount        int       `json:"amount"`
+	BalanceAfter  int       `json:"balance_after"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	Transaction *LedgerTransaction `json:"transaction,omitempty"`
+}
+
+type WalletTopUp struct {
+	ID                  int           `json:"id"`
+	UserID              int           `json:"user_id"`
+	AccountID           int           `json:"account_id"`
+	Amount              int           `json:"amount"`
+	InvoiceID           string        `json:"invoice_id"`
+	ExternalID          string        `json:"external_id"`
+	InvoiceURL          string        `json:"invoice_url"`
+	Status              PaymentStatus `json:"status"`
+	LedgerTransactionID *int          `json:"ledger_transaction_id"`
+	PaidAt              *time.Time    `json:"paid_at"`
+	ExpiredAt           *time.Time    `json:"expired_at"`
+	CreatedAt           time.Time     `json:"created_at"`
+	UpdatedAt           *time.Time    `json:"updated_at"`
+}
@@ -0,0 +1,247 @@
+package network
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/googlemap"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+var ErrNoRoute = errors.New("no route between branches")
+
+const LinkColumns = `
+	id,
+	from_branch_id,
+	to_branch_id,
+	transit_minutes,
+	cost,
+	is_active,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func ScanLink(row scanner, l *models.BranchLink) error {
+	return row.Scan(
+		&l.ID,
+		&l.FromBranchID,
+		&l.ToBranchID,
+		&l.TransitMinutes,
+		&l.Cost,
+		&l.IsActive,
+		&l.CreatedAt,
+		&l.UpdatedAt,
+	)
+}
+
+// PlanRoute finds the fastest chain of active links between two branches, ties are broken by the cheaper route.
+func PlanRoute(q querier, fromBranchID, toBranchID int) ([]models.ShipmentRouteLeg, error) {
+	legs := []models.ShipmentRouteLeg{}
+	if fromBranchID == toBranchID {
+		return legs, nil
+	}
+
+	sqlGetLinks := `
+	SELECT ` + LinkColumns + `
+	FROM branch_links
+	WHERE is_active = TRUE
+		AND from_branch_id IN (SELECT id FROM branches WHERE deleted_at IS NULL)
+		AND to_branch_id IN (SELECT id FROM branches WHERE deleted_at IS NULL)
+	ORDER BY id
+	`
+	rows, err := q.Query(sqlGetLinks)
+	if err != nil {
+		return nil, err
+	}
+	adjacent := map[int][]models.BranchLink{}
+	for rows.Next() {
+		var l models.BranchLink
+		if err := ScanLink(rows, &l); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		adjacent[l.FromBranchID] = append(adjacent[l.FromBranchID], l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	type distance struct{ minutes, cost int }
+	less := func(a, b distance) bool {
+		return a.minutes < b.minutes || (a.minutes == b.minutes && a.cost < b.cost)
+	}
+
+	// Dijkstra, the network is a few dozen branches so a linear scan for the closest branch is enough
+	dist := map[int]distance{fromBranchID: {}}
+	via := map[int]models.BranchLink{}
+	visited := map[int]bool{}
+	for {
+		current := -1
+		for id, d := range dist {
+			if visited[id] {
+				continue
+			}
+			if current == -1 || less(d, dist[current]) || (d == dist[current] && id < current) {
+				current = id
+			}
+		}
+		if current == -1 {
+			return nil, ErrNoRoute
+		}
+		if current == toBranchID {
+			break
+		}
+		visited[current] = true
+
+		for _, l := range adjacent[current] {
+			next := distance{dist[current].minutes + l.TransitMinutes, dist[current].cost + l.Cost}
+			if known, ok := dist[l.ToBranchID]; !ok || less(next, known) {
+				dist[l.ToBranchID] = next
+				via[l.ToBranchID] = l
+			}
+		}
+	}
+
+	for id := toBranchID; id != fromBranchID; id = via[id].FromBranchID {
+		l := via[id]
+		legs = append([]models.ShipmentRouteLeg{{
+			FromBranchID:   l.FromBranchID,
+			ToBranchID:     l.ToBranchID,
+			TransitMinutes: l.TransitMinutes,
+			Cost:           l.Cost,
+		}}, legs...)
+	}
+	for i := range legs {
+		legs[i].Sequence = i + 1
+	}
+
+	return legs, nil
+}
+
+// PlanShipmentRoute replaces the shipment's planned legs with the current best route.
+func PlanShipmentRoute(tx *sql.Tx, shipmentID, fromBranchID, toBranchID int) ([]models.ShipmentRouteLeg, error) {
+	_, err := tx.Exec(`DELETE FROM shipment_route_legs WHERE shipment_id = $1`, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	legs, err := PlanRoute(tx, fromBranchID, toBranchID)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlInsertLeg := `
+	INSERT INTO shipment_route_legs (shipment_id, sequence, from_branch_id, to_branch_id, transit_minutes, cost)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id
+	`
+	for i := range legs {
+		legs[i].ShipmentID = shipmentID
+		err := tx.QueryRow(sqlInsertLeg, shipmentID, legs[i].Sequence, legs[i].FromBranchID, legs[i].ToBranchID, legs[i].TransitMinutes, legs[i].Cost).Scan(&legs[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return legs, nil
+}
+
+func GetShipmentRoute(q querier, shipmentID int) ([]models.ShipmentRouteLeg, error) {
+	sqlGetLegs := `
+	SELECT id, shipment_id, sequence, from_branch_id, to_branch_id, transit_minutes, cost, arrived_at
+	FROM shipment_route_legs
+	WHERE shipment_id = $1
+	ORDER BY sequence
+	`
+	rows, err := q.Query(sqlGetLegs, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	legs := []models.ShipmentRouteLeg{}
+	for rows.Next() {
+		var l models.ShipmentRouteLeg
+		err := rows.Scan(&l.ID, &l.ShipmentID, &l.Sequence, &l.FromBranchID, &l.ToBranchID, &l.TransitMinutes, &l.Cost, &l.ArrivedAt)
+		if err != nil {
+			return nil, err
+		}
+		legs = append(legs, l)
+	}
+
+	return legs, rows.Err()
+}
+
+// NearestBranchID geocodes an address and returns the closest branch that serves it, nil when none does.
+func NearestBranchID(q querier, address string) (*int, error) {
+	location, err := googlemap.Geocode(address)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`SELECT ` + branch.Columns + ` FROM branches WHERE latitude IS NOT NULL AND longitude IS NOT NULL AND deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nearestID *int
+	nearestDistance := 0.0
+	for rows.Next() {
+		var b models.Branch
+		if err := branch.Scan(rows, &b); err != nil {
+			return nil, err
+		}
+
+		d := branch.Distance(location.Lat, location.Lng, *b.Latitude, *b.Longitude)
+		if b.ServiceRadius != nil && d > float64(*b.ServiceRadius) {
+			continue
+		}
+		if nearestID == nil || d < nearestDistance {
+			id := int(b.ID)
+			nearestID, nearestDistance = &id, d
+		}
+	}
+
+	return nearestID, rows.Err()
+}
+
+// RecordArrival marks the planned leg into branchID as arrived and reports whether the branch is on the planned route.
+// Shipments without a planned route are never considered off route.
+func RecordArrival(tx *sql.Tx, shipmentID, branchID int) (bool, error) {
+	_, err := tx.Exec(
+		`UPDATE shipment_route_legs SET arrived_at = NOW() WHERE shipment_id = $1 AND to_branch_id = $2 AND arrived_at IS NULL`,
+		shipmentID, branchID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	sqlIsOnRoute := `
+	SELECT
+		s.destination_branch_id IS NULL
+		OR s.origin_branch_id = $2
+		OR s.destination_branch_id = $2
+		OR EXISTS (
+			SELECT 1 FROM shipment_route_legs l
+			WHERE l.shipment_id = s.id AND (l.from_branch_id = $2 OR l.to_branch_id = $2)
+		)
+	FROM shipments s
+	WHERE s.id = $1
+	`
+	var onRoute bool
+	err = tx.QueryRow(sqlIsOnRoute, shipmentID, branchID).Scan(&onRoute)
+
+	return onRoute, err
+}
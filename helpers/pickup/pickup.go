@@ -0,0 +1,171 @@
+package pickup
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+// ErrUnavailable is returned for windows the branch does not offer or has no capacity left in.
+var ErrUnavailable = errors.New("pickup window is not available")
+
+const (
+	WindowLength = 3 * time.Hour
+	MinLeadTime  = time.Hour // couriers need some notice before the window starts
+	BookingDays  = 7         // how far ahead windows can be booked
+)
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// windows cuts the branch's operating hours of the coming days into pickup windows, skipping holidays.
+// The branch schedule must be loaded with branch.LoadSchedules.
+func windows(b models.Branch, now time.Time, days int) []models.PickupSlot {
+	loc := branch.Location(b)
+	today := now.In(loc)
+
+	holidays := map[string]bool{}
+	for _, h := range b.Holidays {
+		holidays[h.Date] = true
+	}
+
+	slots := []models.PickupSlot{}
+	for d := 0; d < days; d++ {
+		day := time.Date(today.Year(), today.Month(), today.Day()+d, 0, 0, 0, 0, loc)
+		if holidays[day.Format(time.DateOnly)] {
+			continue
+		}
+
+		for _, h := range b.OperatingHours {
+			if h.Weekday != int(day.Weekday()) {
+				continue
+			}
+			opens, err1 := time.ParseInLocation("2006-01-02 15:04", day.Format(time.DateOnly)+" "+h.OpensAt, loc)
+			closes, err2 := time.ParseInLocation("2006-01-02 15:04", day.Format(time.DateOnly)+" "+h.ClosesAt, loc)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+
+			for start := opens; !start.Add(WindowLength).After(closes); start = start.Add(WindowLength) {
+				if start.Before(now.Add(MinLeadTime)) {
+					continue
+				}
+				slots = append(slots, models.PickupSlot{
+					BranchID: int(b.ID),
+					Start:    start.UTC(),
+					End:      start.Add(WindowLength).UTC(),
+					Capacity: b.PickupSlotCapacity,
+				})
+			}
+		}
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Start.Before(slots[j].Start) })
+	return slots
+}
+
+// Slots lists the branch's pickup windows of the coming days with how many pickups each can still take.
+// The branch schedule must be loaded with branch.LoadSchedules.
+func Slots(q querier, b models.Branch, now time.Time, days int) ([]models.PickupSlot, error) {
+	slots := windows(b, now, days)
+	if len(slots) == 0 {
+		return slots, nil
+	}
+
+	sqlCountBooked := `
+	SELECT pickup_window_start, COUNT(*)
+	FROM shipments
+	WHERE origin_branch_id = $1 AND pickup_window_start >= $2 AND pickup_window_start <= $3 AND status != $4
+	GROUP BY pickup_window_start
+	`
+	rows, err := q.Query(sqlCountBooked, b.ID, slots[0].Start, slots[len(slots)-1].Start, models.StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	booked := map[int64]int{}
+	for rows.Next() {
+		var start time.Time
+		var count int
+		if err := rows.Scan(&start, &count); err != nil {
+			return nil, err
+		}
+		booked[start.Unix()] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range slots {
+		slots[i].Booked = booked[slots[i].Start.Unix()]
+		slots[i].Available = max(slots[i].Capacity-slots[i].Booked, 0)
+	}
+	return slots, nil
+}
+
+// Book reserves the pickup window starting at start at the shipment's origin branch.
+// The window stays locked until commit so concurrent bookings cannot overrun its capacity,
+// bookings of other windows and other writes to the branch are not held up.
+func Book(tx *sql.Tx, shipmentID, branchID int, start time.Time) (models.PickupSlot, error) {
+	var slot models.PickupSlot
+
+	branches := make([]models.Branch, 1)
+	err := branch.Scan(tx.QueryRow(`SELECT `+branch.Columns+` FROM branches WHERE id = $1 AND deleted_at IS NULL`, branchID), &branches[0])
+	if errors.Is(err, sql.ErrNoRows) {
+		return slot, fmt.Errorf("%w: the pickup branch no longer exists", ErrUnavailable)
+	}
+	if err != nil {
+		return slot, err
+	}
+
+	now := time.Now()
+	if err := branch.LoadSchedules(tx, branches, now); err != nil {
+		return slot, err
+	}
+
+	found := false
+	for _, w := range windows(branches[0], now, BookingDays) {
+		if w.Start.Equal(start) {
+			slot, found = w, true
+			break
+		}
+	}
+	if !found {
+		return slot, fmt.Errorf("%w: choose one of the windows listed by /api/pickup-slots", ErrUnavailable)
+	}
+
+	// Keyed on the branch and the window's start in minutes, released by commit or rollback
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1::int, $2::int)`, int32(branchID), int32(slot.Start.Unix()/60))
+	if err != nil {
+		return slot, err
+	}
+
+	sqlCountBooked := `
+	SELECT COUNT(*) FROM shipments
+	WHERE origin_branch_id = $1 AND pickup_window_start = $2 AND status != $3 AND id != $4
+	`
+	err = tx.QueryRow(sqlCountBooked, branchID, slot.Start, models.StatusCancelled, shipmentID).Scan(&slot.Booked)
+	if err != nil {
+		return slot, err
+	}
+	if slot.Booked >= slot.Capacity {
+		return slot, fmt.Errorf("%w: the window is fully booked", ErrUnavailable)
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET pickup_window_start = $2, pickup_window_end = $3 WHERE id = $1`, shipmentID, slot.Start, slot.End)
+	if err != nil {
+		return slot, err
+	}
+
+	slot.Booked++
+	slot.Available = slot.Capacity - slot.Booked
+	return slot, nil
+}
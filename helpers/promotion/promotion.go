@@ -0,0 +1,187 @@
+package promotion
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+// ErrRejected is returned when the code is unknown, used up or its rules do not match the shipment.
+var ErrRejected = errors.New("promo code cannot be applied")
+
+const Columns = `
+	id,
+	code,
+	"desc",
+	discount_type,
+	discount_value,
+	max_discount,
+	min_spend,
+	usage_limit,
+	per_user_limit,
+	used_count,
+	min_distance,
+	max_distance,
+	min_weight,
+	max_weight,
+	origin_keyword,
+	destination_keyword,
+	valid_from,
+	valid_until,
+	is_active,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func Scan(row scanner, p *models.Promotion) error {
+	return row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Desc,
+		&p.DiscountType,
+		&p.DiscountValue,
+		&p.MaxDiscount,
+		&p.MinSpend,
+		&p.UsageLimit,
+		&p.PerUserLimit,
+		&p.UsedCount,
+		&p.MinDistance,
+		&p.MaxDistance,
+		&p.MinWeight,
+		&p.MaxWeight,
+		&p.OriginKeyword,
+		&p.DestinationKeyword,
+		&p.ValidFrom,
+		&p.ValidUntil,
+		&p.IsActive,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+}
+
+// NormalizeCode makes promo codes case-insensitive.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Quote is the part of a shipment a promotion's rules are checked against.
+type Quote struct {
+	UserID           uint
+	Subtotal         int // base + distance + weight price + service surcharge, insurance is never discounted
+	Distance         int // in meters
+	Weight           float64
+	SenderAddress    string
+	RecipientAddress string
+}
+
+// CalculateDiscount returns the discount for subtotal, never exceeding the subtotal itself.
+func CalculateDiscount(p models.Promotion, subtotal int) int {
+	discount := p.DiscountValue
+	if p.DiscountType == models.DiscountTypePercentage {
+		discount = subtotal * p.DiscountValue / 100
+	}
+	if p.MaxDiscount != nil && discount > *p.MaxDiscount {
+		discount = *p.MaxDiscount
+	}
+	if discount > subtotal {
+		discount = subtotal
+	}
+	return discount
+}
+
+// Apply locks the promotion row for the rest of tx and checks every rule, including the global and per-user usage limits.
+// Holding the lock until Redeem commits is what makes the limits race-free.
+func Apply(tx *sql.Tx, code string, q Quote) (models.Promotion, int, error) {
+	var p models.Promotion
+	err := Scan(tx.QueryRow(`SELECT `+Columns+` FROM promotions WHERE code = $1 FOR UPDATE`, NormalizeCode(code)), &p)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, 0, fmt.Errorf("%w: code not found", ErrRejected)
+	}
+	if err != nil {
+		return p, 0, err
+	}
+
+	now := time.Now()
+	if !p.IsActive || now.Before(p.ValidFrom) || now.After(p.ValidUntil) {
+		return p, 0, fmt.Errorf("%w: promotion is not active", ErrRejected)
+	}
+	if p.UsageLimit != nil && p.UsedCount >= *p.UsageLimit {
+		return p, 0, fmt.Errorf("%w: promotion is fully redeemed", ErrRejected)
+	}
+	if q.Subtotal < p.MinSpend {
+		return p, 0, fmt.Errorf("%w: minimum spend is %d", ErrRejected, p.MinSpend)
+	}
+	if p.MinDistance != nil && q.Distance < *p.MinDistance {
+		return p, 0, fmt.Errorf("%w: minimum distance is %d meters", ErrRejected, *p.MinDistance)
+	}
+	if p.MaxDistance != nil && q.Distance > *p.MaxDistance {
+		return p, 0, fmt.Errorf("%w: maximum distance is %d meters", ErrRejected, *p.MaxDistance)
+	}
+	if p.MinWeight != nil && q.Weight < *p.MinWeight {
+		return p, 0, fmt.Errorf("%w: minimum weight is %.2f kg", ErrRejected, *p.MinWeight)
+	}
+	if p.MaxWeight != nil && q.Weight > *p.MaxWeight {
+		return p, 0, fmt.Errorf("%w: maximum weight is %.2f kg", ErrRejected, *p.MaxWeight)
+	}
+	if p.OriginKeyword != nil && !strings.Contains(strings.ToLower(q.SenderAddress), strings.ToLower(*p.OriginKeyword)) {
+		return p, 0, fmt.Errorf("%w: only valid for shipments from %s", ErrRejected, *p.OriginKeyword)
+	}
+	if p.DestinationKeyword != nil && !strings.Contains(strings.ToLower(q.RecipientAddress), strings.ToLower(*p.DestinationKeyword)) {
+		return p, 0, fmt.Errorf("%w: only valid for shipments to %s", ErrRejected, *p.DestinationKeyword)
+	}
+
+	if p.PerUserLimit != nil {
+		var userRedemptions int
+		err = tx.QueryRow(`SELECT COUNT(id) FROM promotion_redemptions WHERE promotion_id = $1 AND user_id = $2`, p.ID, q.UserID).Scan(&userRedemptions)
+		if err != nil {
+			return p, 0, err
+		}
+		if userRedemptions >= *p.PerUserLimit {
+			return p, 0, fmt.Errorf("%w: you have used this promotion the maximum number of times", ErrRejected)
+		}
+	}
+
+	discount := CalculateDiscount(p, q.Subtotal)
+	if discount < 1 {
+		return p, 0, fmt.Errorf("%w: no discount applies to this shipment", ErrRejected)
+	}
+
+	return p, discount, nil
+}
+
+// Redeem records the usage of a promotion previously checked with Apply in the same transaction.
+func Redeem(tx *sql.Tx, p models.Promotion, userID uint, shipmentID int, discount int) error {
+	_, err := tx.Exec(
+		`INSERT INTO promotion_redemptions (promotion_id, user_id, shipment_id, discount) VALUES ($1, $2, $3, $4)`,
+		p.ID, userID, shipmentID, discount,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE promotions SET used_count = used_count + 1 WHERE id = $1`, p.ID)
+	return err
+}
+
+// Release gives the usage back when a shipment is cancelled or its payment expires.
+func Release(tx *sql.Tx, shipmentID int) error {
+	var promotionID int
+	err := tx.QueryRow(`DELETE FROM promotion_redemptions WHERE shipment_id = $1 RETURNING promotion_id`, shipmentID).Scan(&promotionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE promotions SET used_count = GREATEST(used_count - 1, 0) WHERE id = $1`, promotionID)
+	return err
+}
@@ -0,0 +1,161 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+)
+
+// Event is pushed to everyone subscribed to a topic, Data is already encoded so it can cross instances as is
+type Event struct {
+	Type string          `json:"event"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Broker fans events out to the subscribers of a topic. Slow subscribers miss events instead of holding up publishers.
+type Broker interface {
+	Publish(topic string, e Event) error
+	// Subscribe returns the events of the topic and a function to stop receiving them
+	Subscribe(topic string) (<-chan Event, func())
+}
+
+// Events buffered per subscriber before new ones are dropped
+const subscriberBuffer = 16
+
+var broker Broker = NewMemoryBroker()
+
+// Init picks the broker from REALTIME_BROKER, "memory" (the default) only reaches clients connected to this instance,
+// "postgres" relays events through LISTEN/NOTIFY so every instance behind the load balancer receives them.
+func Init() {
+	switch os.Getenv("REALTIME_BROKER") {
+	case "", "memory":
+	case "postgres":
+		b, err := NewPostgresBroker(db.DSN())
+		if err != nil {
+			log.Println("Failed listening for realtime events, using the in-process broker:", err)
+			return
+		}
+		broker = b
+	default:
+		log.Println("Invalid REALTIME_BROKER, using the in-process broker")
+	}
+}
+
+func Publish(topic string, e Event) error {
+	return broker.Publish(topic, e)
+}
+
+func Subscribe(topic string) (<-chan Event, func()) {
+	return broker.Subscribe(topic)
+}
+
+type MemoryBroker struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: map[string]map[chan Event]struct{}{}}
+}
+
+func (b *MemoryBroker) Publish(topic string, e Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(topic string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = map[chan Event]struct{}{}
+	}
+	b.subscribers[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[topic], ch)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+const notifyChannel = "realtime_events"
+
+type notification struct {
+	Topic string `json:"topic"`
+	Event Event  `json:"event"`
+}
+
+// PostgresBroker publishes with NOTIFY and hands what it hears on LISTEN to the clients connected to this instance,
+// including the events this instance published itself.
+type PostgresBroker struct {
+	local    *MemoryBroker
+	listener *pq.Listener
+}
+
+func NewPostgresBroker(dsn string) (*PostgresBroker, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("Realtime listener:", err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	b := &PostgresBroker{local: NewMemoryBroker(), listener: listener}
+	go b.relay()
+	return b, nil
+}
+
+func (b *PostgresBroker) relay() {
+	for n := range b.listener.Notify {
+		if n == nil {
+			continue // the connection was re-established, events sent meanwhile are lost
+		}
+
+		var payload notification
+		if err := json.Unmarshal([]byte(n.Extra), &payload); err != nil {
+			log.Println("Invalid realtime notification", err)
+			continue
+		}
+		b.local.Publish(payload.Topic, payload.Event)
+	}
+}
+
+// Publish fails for events over the 8000 bytes NOTIFY accepts
+func (b *PostgresBroker) Publish(topic string, e Event) error {
+	payload, err := json.Marshal(notification{Topic: topic, Event: e})
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`SELECT pg_notify($1, $2)`, notifyChannel, string(payload))
+	return err
+}
+
+func (b *PostgresBroker) Subscribe(topic string) (<-chan Event, func()) {
+	return b.local.Subscribe(topic)
+}
@@ -0,0 +1,112 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/lib/pq"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+const (
+	EventHistory         = "history"
+	EventCourierLocation = "courier_location"
+)
+
+// TrackingTopic is where the updates of a shipment are published, subscribers only know its tracking number
+func TrackingTopic(trackingNumber string) string {
+	return "tracking:" + trackingNumber
+}
+
+// PublishHistories pushes the given shipment history entries to their trackers.
+// Call it after the transaction that inserted them is committed, failures are only logged.
+func PublishHistories(historyIDs ...int) {
+	if len(historyIDs) == 0 {
+		return
+	}
+
+	sqlGetHistories := `
+	SELECT
+		s.tracking_number,
+		sh.id,
+		sh.shipment_id,
+		sh.status,
+		sh."desc",
+		sh.courier_id,
+		sh.branch_id,
+		sh.is_off_route,
+		sh.timestamp,
+		u.id,
+		u.username,
+		u.email,
+		u.role,
+		b.id,
+		b.name,
+		b.address,
+		b.phone
+	FROM shipment_histories sh
+	JOIN shipments s ON s.id = sh.shipment_id
+	LEFT JOIN users u ON u.id = sh.courier_id
+	LEFT JOIN branches b ON b.id = sh.branch_id
+	WHERE sh.id = ANY($1)
+	ORDER BY sh.timestamp ASC, sh.id ASC
+	`
+	rows, err := db.DB.Query(sqlGetHistories, pq.Array(historyIDs))
+	if err != nil {
+		log.Println("Failed to get shipment histories to publish", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trackingNumber string
+		var h models.ShipmentHistory
+		var c models.ShCourier
+		var b models.ShBranch
+		err := rows.Scan(
+			&trackingNumber,
+			&h.ID,
+			&h.ShipmentID,
+			&h.Status,
+			&h.Desc,
+			&h.CourierID,
+			&h.BranchID,
+			&h.IsOffRoute,
+			&h.Timestamp,
+			&c.ID, &c.Username, &c.Email, &c.Role, // possible nulls
+			&b.ID, &b.Name, &b.Address, &b.Phone, // possible nulls
+		)
+		if err != nil {
+			log.Println("Failed to scan shipment history to publish", err)
+			return
+		}
+
+		if h.CourierID != nil {
+			h.Courier = &c
+		}
+		if h.BranchID != nil {
+			h.Branch = &b
+		}
+
+		publish(TrackingTopic(trackingNumber), EventHistory, h)
+	}
+}
+
+// PublishCourierLocation pushes the courier's new position to the tracker of a shipment out for delivery
+func PublishCourierLocation(trackingNumber string, position models.CourierPosition) {
+	publish(TrackingTopic(trackingNumber), EventCourierLocation, position)
+}
+
+func publish(topic, eventType string, data any) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Println("Failed to encode realtime event", err)
+		return
+	}
+
+	err = Publish(topic, Event{Type: eventType, Data: encoded})
+	if err != nil {
+		log.Println("Failed to publish realtime event", err)
+	}
+}
@@ -0,0 +1,275 @@
+package recipient
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/collection"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/dispatch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/googlemap"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/network"
+)
+
+// ErrRejected is returned once the package is too far along for the recipient to change how it is delivered.
+var ErrRejected = errors.New("request cannot be applied")
+
+// Access links are the token appended to this URL
+var LinkBaseURL = "http://localhost:8080/api/recipient"
+
+// Days an access link stays valid
+var LinkDays = 30
+
+const (
+	DeliveryWindowLength  = 3 * time.Hour
+	MinLeadTime           = time.Hour // couriers need some notice before the window starts
+	BookingDays           = 7         // how far ahead a delivery window can be chosen
+	MaxCollectionDistance = 10000     // in meters, branches further from the recipient are not offered for self-collection
+)
+
+func InitLink() {
+	if baseURL := os.Getenv("RECIPIENT_LINK_BASE_URL"); baseURL != "" {
+		LinkBaseURL = strings.TrimRight(baseURL, "/")
+	}
+	if strDays := os.Getenv("RECIPIENT_LINK_DAYS"); strDays != "" {
+		days, err := strconv.Atoi(strDays)
+		if err != nil || days < 1 {
+			log.Println("Invalid RECIPIENT_LINK_DAYS, using default:", LinkDays)
+		} else {
+			LinkDays = days
+		}
+	}
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// PhoneDigest ties access links to the recipient's phone number without putting the number in the link.
+// Only digits count, so the same number typed with or without separators gives the same digest.
+func PhoneDigest(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	sum := sha256.Sum256([]byte(digits))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Link creates the access link of a shipment for its current recipient phone number.
+func Link(trackingNumber, recipientPhone string) (models.RecipientLink, error) {
+	expiresAt := time.Now().AddDate(0, 0, LinkDays)
+	token, err := helpers.CreateRecipientToken(trackingNumber, PhoneDigest(recipientPhone), time.Until(expiresAt))
+	if err != nil {
+		return models.RecipientLink{}, err
+	}
+
+	return models.RecipientLink{
+		URL:       LinkBaseURL + "/" + token,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Coordinates locates the recipient address, geocoding it once and keeping the result on the shipment.
+func Coordinates(s models.Shipment) (float64, float64, error) {
+	var lat, lng *float64
+	err := db.DB.QueryRow(`SELECT recipient_latitude, recipient_longitude FROM shipments WHERE id = $1`, s.ID).Scan(&lat, &lng)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lat != nil && lng != nil {
+		return *lat, *lng, nil
+	}
+
+	location, err := googlemap.Geocode(s.RecipientAddress)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = db.DB.Exec(`UPDATE shipments SET recipient_latitude = $2, recipient_longitude = $3 WHERE id = $1`, s.ID, location.Lat, location.Lng)
+	if err != nil {
+		log.Println("Failed saving recipient coordinates", err)
+	}
+
+	return location.Lat, location.Lng, nil
+}
+
+// CollectionPoints lists the branches close enough to the recipient to collect the package from, nearest first.
+func CollectionPoints(q querier, lat, lng float64) ([]models.CollectionPoint, error) {
+	rows, err := q.Query(`SELECT ` + branch.Columns + ` FROM branches WHERE latitude IS NOT NULL AND longitude IS NOT NULL AND deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []models.CollectionPoint{}
+	for rows.Next() {
+		var b models.Branch
+		if err := branch.Scan(rows, &b); err != nil {
+			return nil, err
+		}
+
+		d := branch.Distance(lat, lng, *b.Latitude, *b.Longitude)
+		if d > MaxCollectionDistance {
+			continue
+		}
+		points = append(points, models.CollectionPoint{Branch: b, Distance: int(d)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].Distance < points[j].Distance })
+	return points, nil
+}
+
+// OutForDelivery reports whether a courier already has the package on the way to the recipient
+func OutForDelivery(tx *sql.Tx, shipmentID int) (bool, error) {
+	var accepted bool
+	err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM courier_tasks WHERE shipment_id = $1 AND type = $2 AND status = $3)`,
+		shipmentID, models.TaskTypeDelivery, models.TaskStatusAccepted,
+	).Scan(&accepted)
+	return accepted, err
+}
+
+// SetDeliveryWindow asks the courier to come in the window starting at start.
+// s must be locked by the caller.
+func SetDeliveryWindow(tx *sql.Tx, s models.Shipment, start, now time.Time) (time.Time, error) {
+	end := start.Add(DeliveryWindowLength)
+	if s.IsSelfCollect {
+		return end, fmt.Errorf("%w: the package is waiting for self-collection", ErrRejected)
+	}
+	if start.Before(now.Add(MinLeadTime)) || start.After(now.AddDate(0, 0, BookingDays)) {
+		return end, fmt.Errorf("%w: the window must start between %s and %d days from now", ErrRejected, MinLeadTime, BookingDays)
+	}
+
+	out, err := OutForDelivery(tx, s.ID)
+	if err != nil {
+		return end, err
+	}
+	if out {
+		return end, fmt.Errorf("%w: the package is already out for delivery", ErrRejected)
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET delivery_window_start = $2, delivery_window_end = $3 WHERE id = $1`, s.ID, start, end)
+	return end, err
+}
+
+// RedirectToBranch sends the package to branchID for the recipient to collect instead of having it delivered.
+// A package already sitting at branchID is ready for collection right away, the returned Ready is nil otherwise.
+// s must be locked by the caller.
+func RedirectToBranch(tx *sql.Tx, s models.Shipment, branchID int) (*collection.Ready, error) {
+	if s.Status == models.StatusReadyForCollection {
+		return nil, fmt.Errorf("%w: the package is already waiting for collection", ErrRejected)
+	}
+	if s.Status == models.StatusOnHold {
+		return nil, fmt.Errorf("%w: the package is on hold, try again once it is released", ErrRejected)
+	}
+
+	// Set first so that Reroute does not open a delivery task at branchID
+	sqlSelfCollect := `UPDATE shipments SET is_self_collect = TRUE, delivery_window_start = NULL, delivery_window_end = NULL WHERE id = $1`
+	_, err := tx.Exec(sqlSelfCollect, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = Reroute(tx, s, branchID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.CurrentBranchID == nil || *s.CurrentBranchID != branchID {
+		return nil, nil
+	}
+	return collection.OpenOnArrival(tx, s.ID, branchID)
+}
+
+// Reroute changes the destination of the package to branchID and plans its route from the branch it sits at,
+// or from its origin when it has not reached the network yet. The returned legs are nil when no route was found.
+// The delivery task moves along, it is opened right away when the package already sits at branchID.
+// s must be locked by the caller.
+func Reroute(tx *sql.Tx, s models.Shipment, branchID int) ([]models.ShipmentRouteLeg, error) {
+	out, err := OutForDelivery(tx, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	if out {
+		return nil, fmt.Errorf("%w: the package is already out for delivery", ErrRejected)
+	}
+
+	from := s.CurrentBranchID
+	if from == nil {
+		switch s.Status {
+		case models.StatusPendingPayment, models.StatusReadyToPickup, models.StatusPickedUp:
+			from = s.OriginBranchID
+		default:
+			return nil, fmt.Errorf("%w: the package is travelling between branches, try again once it arrives", ErrRejected)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET destination_branch_id = $2 WHERE id = $1`, s.ID, branchID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dispatch.CancelTask(tx, s.ID, models.TaskTypeDelivery)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a route the package is rerouted all the same, staff move it by hand
+	var legs []models.ShipmentRouteLeg
+	if from != nil {
+		legs, err = network.PlanShipmentRoute(tx, s.ID, *from, branchID)
+		if errors.Is(err, network.ErrNoRoute) {
+			log.Printf("No route from branch %d to branch %d for shipment %d\n", *from, branchID, s.ID)
+			legs, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.CurrentBranchID != nil && *s.CurrentBranchID == branchID {
+		err = dispatch.CreateDeliveryTaskOnArrival(tx, s.ID, branchID)
+	}
+	return legs, err
+}
+
+// SetInstructions stores the recipient's notes for the courier, an empty text clears them.
+func SetInstructions(tx *sql.Tx, s models.Shipment, instructions string) error {
+	var value *string
+	if instructions != "" {
+		value = &instructions
+	}
+
+	_, err := tx.Exec(`UPDATE shipments SET delivery_instructions = $2 WHERE id = $1`, s.ID, value)
+	return err
+}
+
+// RecordHistory logs a recipient request on the shipment's history, keeping its current status.
+func RecordHistory(tx *sql.Tx, s models.Shipment, desc string) (int, error) {
+	var historyID int
+	err := tx.QueryRow(
+		`INSERT INTO shipment_histories (shipment_id, status, "desc", branch_id) VALUES ($1, $2, $3, $4) RETURNING id`,
+		s.ID, s.Status, desc, s.CurrentBranchID,
+	).Scan(&historyID)
+	return historyID, err
+}
@@ -0,0 +1,75 @@
+package reverse
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+// ErrRejected is returned for packages that are not delivered or already on their way back.
+var ErrRejected = errors.New("return cannot be requested")
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// CheckReturnable tells why the package of s cannot be sent back, s must be locked by the caller.
+func CheckReturnable(tx *sql.Tx, s models.Shipment) error {
+	if s.ReturnOfShipmentID != nil {
+		return fmt.Errorf("%w: the shipment is a return itself", ErrRejected)
+	}
+	if s.Status != models.StatusDelivered {
+		return fmt.Errorf("%w: only delivered packages can be returned, shipment is %s", ErrRejected, s.Status)
+	}
+	if s.IsReturnedToSender {
+		return fmt.Errorf("%w: the package was already brought back to the sender", ErrRejected)
+	}
+
+	var active string
+	err := tx.QueryRow(
+		`SELECT tracking_number FROM shipments WHERE return_of_shipment_id = $1 AND status <> $2 LIMIT 1`,
+		s.ID, models.StatusCancelled,
+	).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: the package is already returned with shipment %s", ErrRejected, active)
+}
+
+// Links loads both sides of a return: the delivered shipment when s is a return, and the returns requested for s otherwise.
+func Links(q querier, s models.Shipment) (*models.LinkedShipment, []models.LinkedShipment, error) {
+	if s.ReturnOfShipmentID != nil {
+		var original models.LinkedShipment
+		err := q.QueryRow(
+			`SELECT id, tracking_number, status, created_at FROM shipments WHERE id = $1`, *s.ReturnOfShipmentID,
+		).Scan(&original.ID, &original.TrackingNumber, &original.Status, &original.CreatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &original, nil, nil
+	}
+
+	rows, err := q.Query(
+		`SELECT id, tracking_number, status, created_at FROM shipments WHERE return_of_shipment_id = $1 ORDER BY created_at ASC, id ASC`, s.ID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var returns []models.LinkedShipment
+	for rows.Next() {
+		var r models.LinkedShipment
+		if err := rows.Scan(&r.ID, &r.TrackingNumber, &r.Status, &r.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		returns = append(returns, r)
+	}
+	return nil, returns, rows.Err()
+}
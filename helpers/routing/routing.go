@@ -0,0 +1,121 @@
+package routing
+
+import (
+	"log"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/googlemap"
+	"googlemaps.github.io/maps"
+)
+
+const (
+	SourceGoogle    = "google"
+	SourceHaversine = "haversine"
+)
+
+// Average speed used to estimate travel times when the distance provider is not available
+const fallbackSpeed = 25.0 * 1000 / 3600 // 25 km/h in meters per second
+
+// Matrix holds the travel between every pair of points, [from][to], point 0 is where the run starts
+type Matrix struct {
+	Distances [][]int // in meters
+	Durations [][]time.Duration
+	Source    string
+}
+
+// BuildMatrix asks the distance provider for driving distances and falls back to straight-line distances when it fails.
+func BuildMatrix(points []maps.LatLng) Matrix {
+	distances, durations, err := googlemap.TravelMatrix(points)
+	if err == nil {
+		return Matrix{Distances: distances, Durations: durations, Source: SourceGoogle}
+	}
+	log.Println("Distance provider unavailable, falling back to haversine distances", err)
+
+	m := Matrix{
+		Distances: make([][]int, len(points)),
+		Durations: make([][]time.Duration, len(points)),
+		Source:    SourceHaversine,
+	}
+	for i, from := range points {
+		m.Distances[i] = make([]int, len(points))
+		m.Durations[i] = make([]time.Duration, len(points))
+		for j, to := range points {
+			d := branch.Distance(from.Lat, from.Lng, to.Lat, to.Lng)
+			m.Distances[i][j] = int(d)
+			m.Durations[i][j] = time.Duration(d / fallbackSpeed * float64(time.Second))
+		}
+	}
+	return m
+}
+
+// Optimize returns the order to visit points 1..n in, starting from point 0 without returning to it.
+// The path is built nearest-neighbour first and then improved with 2-opt, minimizing travel time.
+func Optimize(m Matrix) []int {
+	order := nearestNeighbour(m.Durations)
+	return twoOpt(m.Durations, order)
+}
+
+func nearestNeighbour(cost [][]time.Duration) []int {
+	n := len(cost)
+	visited := make([]bool, n)
+	visited[0] = true
+
+	order := make([]int, 0, n-1)
+	current := 0
+	for len(order) < n-1 {
+		next := -1
+		for j := 1; j < n; j++ {
+			if !visited[j] && (next == -1 || cost[current][j] < cost[current][next]) {
+				next = j
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		current = next
+	}
+
+	return order
+}
+
+func pathCost(cost [][]time.Duration, order []int) time.Duration {
+	var total time.Duration
+	previous := 0
+	for _, p := range order {
+		total += cost[previous][p]
+		previous = p
+	}
+	return total
+}
+
+// twoOpt keeps reversing segments of the path while that makes it shorter. The full cost is recomputed for every
+// candidate because driving times are not symmetric, so reversing a segment also changes the cost inside it.
+func twoOpt(cost [][]time.Duration, order []int) []int {
+	best := append([]int(nil), order...)
+	bestCost := pathCost(cost, best)
+
+	improved := true
+	for improved {
+		improved = false
+		for i := 0; i < len(best)-1; i++ {
+			for k := i + 1; k < len(best); k++ {
+				candidate := append([]int(nil), best...)
+				for a, b := i, k; a < b; a, b = a+1, b-1 {
+					candidate[a], candidate[b] = candidate[b], candidate[a]
+				}
+				if c := pathCost(cost, candidate); c < bestCost {
+					best, bestCost = candidate, c
+					improved = true
+				}
+			}
+		}
+	}
+
+	return best
+}
+
+// Estimate gives a coarse straight-line distance in meters and travel time between two points without calling the distance provider.
+func Estimate(from, to maps.LatLng) (int, time.Duration) {
+	d := branch.Distance(from.Lat, from.Lng, to.Lat, to.Lng)
+	return int(d), time.Duration(d / fallbackSpeed * float64(time.Second))
+}
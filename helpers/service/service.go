@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+// ErrIneligible is returned when the shipment is too far or booked too late for the service level.
+var ErrIneligible = errors.New("service is not available for this shipment")
+
+// Level is one of the delivery products a sender can choose from
+type Level struct {
+	Type            string
+	Name            string
+	PriceMultiplier float64 // applied to the base, distance and weight price
+	CutoffHour      int     // in local time, later shipments are handled from the next day
+	MaxDistance     int     // in meters, 0 for no limit
+	SameDayOnly     bool    // shipments after the cutoff are refused instead of handled the next day
+
+	// SLA targets for every part of the journey
+	FirstMile     time.Duration // from the start of handling until the package is at the origin branch
+	Handling      time.Duration // sorting at every branch the package passes through
+	LastMile      time.Duration // from the destination branch to the recipient
+	LinehaulSpeed float64       // in meters per hour, used when the branches have no planned route
+}
+
+// Same-day deliveries only run within a city
+const sameDayMaxDistance = 40000
+
+var Levels = map[string]Level{
+	models.ServiceEconomy: {
+		Type:            models.ServiceEconomy,
+		Name:            "Economy",
+		PriceMultiplier: 0.8,
+		CutoffHour:      15,
+		FirstMile:       48 * time.Hour,
+		Handling:        12 * time.Hour,
+		LastMile:        48 * time.Hour,
+		LinehaulSpeed:   25000,
+	},
+	models.ServiceRegular: {
+		Type:            models.ServiceRegular,
+		Name:            "Regular",
+		PriceMultiplier: 1,
+		CutoffHour:      17,
+		FirstMile:       24 * time.Hour,
+		Handling:        6 * time.Hour,
+		LastMile:        24 * time.Hour,
+		LinehaulSpeed:   40000,
+	},
+	models.ServiceExpress: {
+		Type:            models.ServiceExpress,
+		Name:            "Express",
+		PriceMultiplier: 1.5,
+		CutoffHour:      19,
+		FirstMile:       6 * time.Hour,
+		Handling:        2 * time.Hour,
+		LastMile:        12 * time.Hour,
+		LinehaulSpeed:   60000,
+	},
+	models.ServiceSameDay: {
+		Type:            models.ServiceSameDay,
+		Name:            "Same Day",
+		PriceMultiplier: 2.5,
+		CutoffHour:      12,
+		MaxDistance:     sameDayMaxDistance,
+		SameDayOnly:     true,
+		FirstMile:       2 * time.Hour,
+		Handling:        time.Hour,
+		LastMile:        3 * time.Hour,
+		LinehaulSpeed:   30000,
+	},
+}
+
+// Types lists the services in the order they are offered, cheapest first
+var Types = []string{models.ServiceEconomy, models.ServiceRegular, models.ServiceExpress, models.ServiceSameDay}
+
+// Get returns the service level, falling back to regular for unknown types
+func Get(serviceType string) Level {
+	l, ok := Levels[serviceType]
+	if !ok {
+		return Levels[models.ServiceRegular]
+	}
+	return l
+}
+
+// Surcharge is what the service adds to the subtotal, negative for services cheaper than regular
+func (l Level) Surcharge(subtotal int) int {
+	return int(math.Round(float64(subtotal) * (l.PriceMultiplier - 1)))
+}
+
+// CheckEligibility tells whether the service can carry a shipment over distance meters created at the given time.
+func (l Level) CheckEligibility(distance int, at time.Time, loc *time.Location) error {
+	if l.MaxDistance > 0 && distance > l.MaxDistance {
+		return fmt.Errorf("%w: %s is only available up to %d km", ErrIneligible, l.Name, l.MaxDistance/1000)
+	}
+	if l.SameDayOnly && at.In(loc).Hour() >= l.CutoffHour {
+		return fmt.Errorf("%w: %s must be booked before %02d:00", ErrIneligible, l.Name, l.CutoffHour)
+	}
+	return nil
+}
+
+// HandlingStart is when the SLA clock starts, shipments booked after the cutoff start at the beginning of the next day.
+func (l Level) HandlingStart(createdAt time.Time, loc *time.Location) time.Time {
+	local := createdAt.In(loc)
+	if local.Hour() < l.CutoffHour {
+		return createdAt
+	}
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+}
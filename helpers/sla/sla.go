@@ -0,0 +1,117 @@
+package sla
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/service"
+)
+
+// Deadlines are in this time zone when the destination branch is unknown
+const defaultTimezone = "Asia/Jakarta"
+
+// How often open shipments are checked against their deadline
+const monitorInterval = 15 * time.Minute
+
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// EstimateDelivery returns the promised delivery deadline, the end of the day the package is expected at the recipient.
+// legs is the planned branch route, nil when there is none, in which case the line-haul time is derived from the distance.
+func EstimateDelivery(level service.Level, createdAt time.Time, distance float64, legs []models.ShipmentRouteLeg, loc *time.Location) time.Time {
+	transit := level.Handling * 2 // sorted once at the origin and once at the destination branch
+	if legs != nil {
+		transit = level.Handling * time.Duration(len(legs)+1)
+		for _, l := range legs {
+			transit += time.Duration(l.TransitMinutes) * time.Minute
+		}
+	} else {
+		transit += time.Duration(distance / level.LinehaulSpeed * float64(time.Hour))
+	}
+
+	expected := level.HandlingStart(createdAt, loc).Add(level.FirstMile + transit + level.LastMile).In(loc)
+	return time.Date(expected.Year(), expected.Month(), expected.Day(), 23, 59, 59, 0, loc)
+}
+
+// Location is the time zone deadlines are set in, the destination branch's when known
+func Location(q querier, destinationBranchID *int) (*time.Location, error) {
+	timezone := defaultTimezone
+	if destinationBranchID != nil {
+		err := q.QueryRow(`SELECT timezone FROM branches WHERE id = $1`, *destinationBranchID).Scan(&timezone)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Local, nil
+	}
+	return loc, nil
+}
+
+// SetEstimate promises the shipment's delivery date from its service level and planned route and stores it.
+func SetEstimate(tx *sql.Tx, s models.Shipment, legs []models.ShipmentRouteLeg) (time.Time, error) {
+	loc, err := Location(tx, s.DestinationBranchID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var createdAt time.Time
+	var distance float64
+	var serviceType string
+	err = tx.QueryRow(`SELECT created_at, distance, service_type FROM shipments WHERE id = $1`, s.ID).Scan(&createdAt, &distance, &serviceType)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Timestamps are stored without a zone, in UTC like NOW()
+	deadline := EstimateDelivery(service.Get(serviceType), createdAt, distance, legs, loc).UTC()
+	_, err = tx.Exec(`UPDATE shipments SET estimated_delivery_at = $2 WHERE id = $1`, s.ID, deadline)
+	return deadline, err
+}
+
+// RecordDelivery flags a shipment delivered after its deadline that the monitor has not caught yet.
+func RecordDelivery(tx *sql.Tx, shipmentID int) error {
+	_, err := tx.Exec(
+		`UPDATE shipments SET sla_breached_at = NOW() WHERE id = $1 AND sla_breached_at IS NULL AND estimated_delivery_at < NOW()`,
+		shipmentID,
+	)
+	return err
+}
+
+// FlagBreaches marks every open shipment past its deadline as late and returns how many were flagged.
+func FlagBreaches() (int64, error) {
+	sqlFlagBreaches := `
+	UPDATE shipments SET sla_breached_at = NOW()
+	WHERE sla_breached_at IS NULL
+		AND estimated_delivery_at < NOW()
+		AND status NOT IN ($1, $2, $3, $4)
+	`
+	// Packages waiting for collection kept the promise, the recipient decides when to come
+	result, err := db.DB.Exec(sqlFlagBreaches, models.StatusDelivered, models.StatusCancelled, models.StatusWrittenOff, models.StatusReadyForCollection)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartMonitor periodically flags the shipments that breached their SLA.
+func StartMonitor() {
+	go func() {
+		for {
+			flagged, err := FlagBreaches()
+			if err != nil {
+				log.Println("SLA monitor failed:", err)
+			} else if flagged > 0 {
+				log.Printf("SLA monitor flagged %d late shipment(s)\n", flagged)
+			}
+
+			time.Sleep(monitorInterval)
+		}
+	}()
+}
@@ -23,3 +23,11 @@ func GenerateTrackingNumber() string {
 
 	return "GS-" + currDate.Format("20060102150405") + strings.ToUpper(randomString(8))
 }
+
+func GenerateBagBarcode() string {
+	return "BAG-" + time.Now().Format("20060102150405") + strings.ToUpper(randomString(6))
+}
+
+func GenerateManifestNumber() string {
+	return "MNF-" + time.Now().Format("20060102150405") + strings.ToUpper(randomString(6))
+}
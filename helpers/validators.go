@@ -28,6 +28,8 @@ func HandleValidationErrors(validationErrors validator.ValidationErrors) map[str
 			errs[jsonKey] = fieldErr.Field() + " is required"
 		case "min":
 			errs[jsonKey] = fieldErr.Field() + " must be at least " + fieldErr.Param() + " characters long"
+		case "max":
+			errs[jsonKey] = fieldErr.Field() + " must be at most " + fieldErr.Param() + " characters long"
 		case "alphanum":
 			errs[jsonKey] = fieldErr.Field() + " must be alphanumeric"
 		case "eqfield":
@@ -42,6 +44,16 @@ func HandleValidationErrors(validationErrors validator.ValidationErrors) map[str
 			errs[jsonKey] = fieldErr.Field() + " should be greater than " + fieldErr.Param()
 		case "url":
 			errs[jsonKey] = fieldErr.Field() + " must be a valid URL"
+		case "nefield":
+			errs[jsonKey] = fieldErr.Field() + " should not be equal to " + fieldErr.Param()
+		case "oneof":
+			errs[jsonKey] = fieldErr.Field() + " must be one of [" + fieldErr.Param() + "]"
+		case "latitude", "longitude":
+			errs[jsonKey] = fieldErr.Field() + " must be a valid " + fieldErr.Tag()
+		case "datetime":
+			errs[jsonKey] = fieldErr.Field() + " must be in the format " + fieldErr.Param()
+		case "timezone":
+			errs[jsonKey] = fieldErr.Field() + " must be a valid IANA time zone"
 		default:
 			errs[jsonKey] = "Validation failed for " + fieldErr.Field()
 		}
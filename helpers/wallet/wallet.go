@@ -0,0 +1,202 @@
+package wallet
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+)
+
+const (
+	AccountCodePaymentGateway  = "SYS-PAYMENT-GATEWAY"
+	AccountCodeShipmentRevenue = "SYS-SHIPMENT-REVENUE"
+	AccountCodeInsuranceClaims = "SYS-INSURANCE-CLAIMS"
+
+	TransactionTypeTopUp           = "TOP_UP"
+	TransactionTypeShipmentPayment = "SHIPMENT_PAYMENT"
+	TransactionTypeClaimPayout     = "CLAIM_PAYOUT"
+	TransactionTypeAdjustment      = "SHIPMENT_ADJUSTMENT"
+
+	TopUpExternalIDPrefix = "TOPUP-"
+)
+
+var ErrInsufficientBalance = errors.New("insufficient wallet balance")
+
+func userAccountCode(userID uint) string {
+	return fmt.Sprintf("WALLET-%d", userID)
+}
+
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// GetUserAccount returns the user's wallet account, sql.ErrNoRows when the user never topped up or paid with it.
+func GetUserAccount(q querier, userID uint) (models.LedgerAccount, error) {
+	var account models.LedgerAccount
+	err := q.QueryRow(`SELECT id, code, user_id, type, balance, created_at, updated_at FROM ledger_accounts WHERE user_id = $1`, userID).Scan(
+		&account.ID,
+		&account.Code,
+		&account.UserID,
+		&account.Type,
+		&account.Balance,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+
+	return account, err
+}
+
+// EmptyUserAccount is what the user's wallet looks like before it is opened.
+func EmptyUserAccount(userID uint) models.LedgerAccount {
+	id := int(userID)
+	return models.LedgerAccount{Code: userAccountCode(userID), UserID: &id, Type: models.LedgerAccountUserWallet}
+}
+
+// GetOrCreateUserAccount returns the user's wallet account, opening an empty one on first use.
+func GetOrCreateUserAccount(tx *sql.Tx, userID uint) (models.LedgerAccount, error) {
+	sqlUpsertAccount := `
+	INSERT INTO ledger_accounts (code, user_id, type)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+	RETURNING id, code, user_id, type, balance, created_at, updated_at
+	`
+
+	var account models.LedgerAccount
+	err := tx.QueryRow(sqlUpsertAccount, userAccountCode(userID), userID, models.LedgerAccountUserWallet).Scan(
+		&account.ID,
+		&account.Code,
+		&account.UserID,
+		&account.Type,
+		&account.Balance,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+
+	return account, err
+}
+
+// GetSystemAccountID looks up one of the seeded system accounts by its code.
+func GetSystemAccountID(tx *sql.Tx, code string) (int, error) {
+	var id int
+	err := tx.QueryRow(`SELECT id FROM ledger_accounts WHERE code = $1`, code).Scan(&id)
+	return id, err
+}
+
+// GetOrCreateSystemAccountID is GetSystemAccountID for system accounts that are not seeded by a migration.
+func GetOrCreateSystemAccountID(tx *sql.Tx, code, accountType string) (int, error) {
+	sqlUpsertAccount := `
+	INSERT INTO ledger_accounts (code, type)
+	VALUES ($1, $2)
+	ON CONFLICT (code) DO UPDATE SET code = EXCLUDED.code
+	RETURNING id
+	`
+
+	var id int
+	err := tx.QueryRow(sqlUpsertAccount, code, accountType).Scan(&id)
+	return id, err
+}
+
+// Transfer posts a balanced transaction that debits one account and credits another by the same amount.
+// Both account rows are locked in id order so concurrent transfers cannot deadlock or overdraw a wallet.
+func Transfer(tx *sql.Tx, txType, reference, desc string, debitAccountID, creditAccountID, amount int) (models.LedgerTransaction, error) {
+	var ledgerTx models.LedgerTransaction
+	if amount <= 0 {
+		return ledgerTx, errors.New("transfer amount must be positive")
+	}
+	if debitAccountID == creditAccountID {
+		return ledgerTx, errors.New("cannot transfer to the same account")
+	}
+
+	rows, err := tx.Query(`SELECT id, type, balance FROM ledger_accounts WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, debitAccountID, creditAccountID)
+	if err != nil {
+		return ledgerTx, err
+	}
+
+	accounts := map[int]models.LedgerAccount{}
+	for rows.Next() {
+		var a models.LedgerAccount
+		if err := rows.Scan(&a.ID, &a.Type, &a.Balance); err != nil {
+			rows.Close()
+			return ledgerTx, err
+		}
+		accounts[a.ID] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return ledgerTx, err
+	}
+
+	debitAccount, ok := accounts[debitAccountID]
+	if !ok {
+		return ledgerTx, fmt.Errorf("ledger account %d not found", debitAccountID)
+	}
+	creditAccount, ok := accounts[creditAccountID]
+	if !ok {
+		return ledgerTx, fmt.Errorf("ledger account %d not found", creditAccountID)
+	}
+
+	if debitAccount.Type == models.LedgerAccountUserWallet && debitAccount.Balance < amount {
+		return ledgerTx, ErrInsufficientBalance
+	}
+
+	sqlInsertTransaction := `
+	INSERT INTO ledger_transactions (type, reference, "desc")
+	VALUES ($1, $2, $3)
+	RETURNING id, type, reference, "desc", created_at
+	`
+	err = tx.QueryRow(sqlInsertTransaction, txType, reference, desc).Scan(
+		&ledgerTx.ID,
+		&ledgerTx.Type,
+		&ledgerTx.Reference,
+		&ledgerTx.Desc,
+		&ledgerTx.CreatedAt,
+	)
+	if err != nil {
+		return ledgerTx, err
+	}
+
+	debitEntry, err := postEntry(tx, ledgerTx.ID, debitAccount, models.LedgerDebit, amount)
+	if err != nil {
+		return ledgerTx, err
+	}
+	creditEntry, err := postEntry(tx, ledgerTx.ID, creditAccount, models.LedgerCredit, amount)
+	if err != nil {
+		return ledgerTx, err
+	}
+
+	ledgerTx.Entries = []models.LedgerEntry{debitEntry, creditEntry}
+
+	return ledgerTx, nil
+}
+
+func postEntry(tx *sql.Tx, transactionID int, account models.LedgerAccount, direction string, amount int) (models.LedgerEntry, error) {
+	balanceAfter := account.Balance + amount
+	if direction == models.LedgerDebit {
+		balanceAfter = account.Balance - amount
+	}
+
+	var entry models.LedgerEntry
+
+	_, err := tx.Exec(`UPDATE ledger_accounts SET balance = $2, updated_at = NOW() WHERE id = $1`, account.ID, balanceAfter)
+	if err != nil {
+		return entry, err
+	}
+
+	sqlInsertEntry := `
+	INSERT INTO ledger_entries (transaction_id, account_id, direction, amount, balance_after)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, transaction_id, account_id, direction, amount, balance_after, created_at
+	`
+	err = tx.QueryRow(sqlInsertEntry, transactionID, account.ID, direction, amount, balanceAfter).Scan(
+		&entry.ID,
+		&entry.TransactionID,
+		&entry.AccountID,
+		&entry.Direction,
+		&entry.Amount,
+		&entry.BalanceAfter,
+		&entry.CreatedAt,
+	)
+
+	return entry, err
+}
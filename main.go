@@ -10,12 +10,28 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/masadamsahid/golang-gin-goldship-api/db"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	billingService "github.com/masadamsahid/golang-gin-goldship-api/helpers/billing"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers/googlemap"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/location"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/realtime"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/recipient"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/sla"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/sms"
 	xenditService "github.com/masadamsahid/golang-gin-goldship-api/helpers/xendit-service"
 	"github.com/masadamsahid/golang-gin-goldship-api/modules/auth"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/billing"
 	"github.com/masadamsahid/golang-gin-goldship-api/modules/branches"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/claims"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/couriers"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/exceptions"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/linehaul"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/network"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/pickups"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/promotions"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/recipients"
 	"github.com/masadamsahid/golang-gin-goldship-api/modules/shipments"
 	"github.com/masadamsahid/golang-gin-goldship-api/modules/users"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/wallets"
 	"github.com/masadamsahid/golang-gin-goldship-api/modules/webhooks"
 
 	scalargo "github.com/bdpiprava/scalar-go"
@@ -26,12 +42,23 @@ import (
 
 func main() {
 	helpers.InitJWT()
+	helpers.InitInsurance()
+	helpers.InitDropOff()
+	helpers.InitCompany()
 	googlemap.InitGoogleMapAPI()
+	location.InitRetention()
+	recipient.InitLink()
+	sms.InitSMS()
 	xenditService.InitXendit()
 
 	defer db.StopDB()
 	db.ConnectDB()
 
+	billingService.StartScheduler()
+	location.StartMaintenance()
+	sla.StartMonitor()
+	realtime.Init()
+
 	r := gin.Default()
 	r.GET("/health-check", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, gin.H{
@@ -43,9 +70,19 @@ func main() {
 
 	// Routes under "/api"
 	auth.Routes(api.Group("/auth"))
+	billing.Routes(api.Group("/billing"))
 	branches.Routes(api.Group("/branches"))
+	claims.Routes(api.Group("/claims"))
+	couriers.Routes(api.Group("/couriers"))
+	exceptions.Routes(api.Group("/exceptions"))
+	linehaul.Routes(api.Group("/linehaul"))
+	network.Routes(api.Group("/network"))
+	pickups.Routes(api.Group("/pickup-slots"))
+	promotions.Routes(api.Group("/promotions"))
+	recipients.Routes(api.Group("/recipient"))
 	shipments.Routes(api.Group("/shipments"))
 	users.Routes(api.Group("/users"))
+	wallets.Routes(api.Group("/wallets"))
 	webhooks.Routes(api.Group("/webhooks"))
 
 	// OpenAPI
@@ -0,0 +1,436 @@
+package billing
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	billingService "github.com/masadamsahid/golang-gin-goldship-api/helpers/billing"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/pdf"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func isStaff(user helpers.AuthPayload) bool {
+	return user.Role == roles.RoleSuperAdmin || user.Role == roles.RoleAdmin
+}
+
+func getBillingInvoice(id int) (models.BillingInvoice, error) {
+	var bi models.BillingInvoice
+	var u models.User
+	sqlGetInvoice := `
+	SELECT
+		bi.id,
+		bi.user_id,
+		bi.period_start,
+		bi.period_end,
+		bi.amount,
+		bi.invoice_id,
+		bi.external_id,
+		bi.invoice_url,
+		bi.status,
+		bi.paid_at,
+		bi.expired_at,
+		bi.created_at,
+		bi.updated_at,
+		u.id,
+		u.username,
+		u.email
+	FROM billing_invoices bi
+	JOIN users u ON u.id = bi.user_id
+	WHERE bi.id = $1
+	`
+	err := db.DB.QueryRow(sqlGetInvoice, id).Scan(
+		&bi.ID,
+		&bi.UserID,
+		&bi.PeriodStart,
+		&bi.PeriodEnd,
+		&bi.Amount,
+		&bi.InvoiceID,
+		&bi.ExternalID,
+		&bi.InvoiceURL,
+		&bi.Status,
+		&bi.PaidAt,
+		&bi.ExpiredAt,
+		&bi.CreatedAt,
+		&bi.UpdatedAt,
+		&u.ID,
+		&u.Username,
+		&u.Email,
+	)
+	if err != nil {
+		return bi, err
+	}
+	bi.User = &u
+
+	sqlGetItems := `
+	SELECT id, billing_invoice_id, shipment_id, tracking_number, "desc", amount, shipped_at
+	FROM billing_invoice_items
+	WHERE billing_invoice_id = $1
+	ORDER BY shipped_at ASC
+	`
+	rows, err := db.DB.Query(sqlGetItems, id)
+	if err != nil {
+		return bi, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.BillingInvoiceItem
+		err := rows.Scan(
+			&item.ID,
+			&item.BillingInvoiceID,
+			&item.ShipmentID,
+			&item.TrackingNumber,
+			&item.Desc,
+			&item.Amount,
+			&item.ShippedAt,
+		)
+		if err != nil {
+			return bi, err
+		}
+		bi.Items = append(bi.Items, item)
+	}
+
+	return bi, rows.Err()
+}
+
+// loadAuthorizedInvoice writes the error response itself and returns ok=false when the caller must stop.
+func loadAuthorizedInvoice(ctx *gin.Context) (models.BillingInvoice, bool) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return models.BillingInvoice{}, false
+	}
+
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid billing invoice ID",
+		})
+		return models.BillingInvoice{}, false
+	}
+
+	bi, err := getBillingInvoice(id)
+	if err != nil {
+		log.Println("Failed to get billing invoice", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Billing invoice not found",
+			})
+			return bi, false
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return bi, false
+	}
+
+	if !isStaff(user) && uint(bi.UserID) != user.ID {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to access this billing invoice",
+		})
+		return bi, false
+	}
+
+	return bi, true
+}
+
+func GetBillingInvoicesList(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Staff see every account's invoices, customers only their own
+	var userFilter *uint
+	if !isStaff(user) {
+		userFilter = &user.ID
+	}
+
+	sqlGetInvoices := `
+	SELECT id, user_id, period_start, period_end, amount, invoice_id, external_id, invoice_url, status, paid_at, expired_at, created_at, updated_at
+	FROM billing_invoices
+	WHERE ($1::INT IS NULL OR user_id = $1)
+	ORDER BY period_start DESC, id DESC
+	LIMIT $2
+	OFFSET $3
+	`
+	rows, err := db.DB.Query(sqlGetInvoices, userFilter, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println("Failed to get billing invoices", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	invoices := []models.BillingInvoice{}
+	for rows.Next() {
+		var bi models.BillingInvoice
+		err := rows.Scan(
+			&bi.ID,
+			&bi.UserID,
+			&bi.PeriodStart,
+			&bi.PeriodEnd,
+			&bi.Amount,
+			&bi.InvoiceID,
+			&bi.ExternalID,
+			&bi.InvoiceURL,
+			&bi.Status,
+			&bi.PaidAt,
+			&bi.ExpiredAt,
+			&bi.CreatedAt,
+			&bi.UpdatedAt,
+		)
+		if err != nil {
+			log.Println("Failed to scan billing invoice", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		invoices = append(invoices, bi)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Billing invoices retrieved successfully",
+		"data":    invoices,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func GetBillingInvoiceByID(ctx *gin.Context) {
+	bi, ok := loadAuthorizedInvoice(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Billing invoice retrieved successfully",
+		"data":    bi,
+	})
+}
+
+func statementFileName(bi models.BillingInvoice, ext string) string {
+	return fmt.Sprintf("goldship-statement-%d-%s.%s", bi.UserID, bi.PeriodStart.Format("200601"), ext)
+}
+
+func DownloadBillingInvoiceCSV(ctx *gin.Context) {
+	bi, ok := loadAuthorizedInvoice(ctx)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"shipment_id", "tracking_number", "description", "shipped_at", "amount"})
+	for _, item := range bi.Items {
+		w.Write([]string{
+			strconv.Itoa(item.ShipmentID),
+			item.TrackingNumber,
+			item.Desc,
+			item.ShippedAt.Format(time.RFC3339),
+			strconv.Itoa(item.Amount),
+		})
+	}
+	w.Write([]string{"", "", "TOTAL", "", strconv.Itoa(bi.Amount)})
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		log.Println("Failed writing billing statement CSV", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="`+statementFileName(bi, "csv")+`"`)
+	ctx.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
+
+func DownloadBillingInvoicePDF(ctx *gin.Context) {
+	bi, ok := loadAuthorizedInvoice(ctx)
+	if !ok {
+		return
+	}
+
+	doc := pdf.New()
+	doc.Title("Goldship Monthly Statement")
+	doc.Space()
+	doc.Text(fmt.Sprintf("Statement no. : %d", bi.ID))
+	doc.Text(fmt.Sprintf("Customer      : %s (%s)", bi.User.Username, bi.User.Email))
+	doc.Text(fmt.Sprintf("Period        : %s - %s", bi.PeriodStart.Format("02 Jan 2006"), bi.PeriodEnd.Format("02 Jan 2006")))
+	doc.Text(fmt.Sprintf("Status        : %s", bi.Status))
+	if bi.InvoiceURL != nil {
+		doc.Text("Pay online    : " + *bi.InvoiceURL)
+	}
+	doc.Space()
+
+	doc.Heading("Shipments")
+	doc.Mono(fmt.Sprintf("%-12s %-34s %-20s %12s", "Date", "Tracking number", "Item", "Amount (IDR)"))
+	for _, item := range bi.Items {
+		desc := item.Desc
+		if len(desc) > 20 {
+			desc = desc[:17] + "..."
+		}
+		doc.Mono(fmt.Sprintf("%-12s %-34s %-20s %12d", item.ShippedAt.Format("2006-01-02"), item.TrackingNumber, desc, item.Amount))
+	}
+	doc.Space()
+	doc.Mono(fmt.Sprintf("%-68s %12d", "TOTAL", bi.Amount))
+
+	ctx.Header("Content-Disposition", `attachment; filename="`+statementFileName(bi, "pdf")+`"`)
+	ctx.Data(http.StatusOK, "application/pdf", doc.Bytes())
+}
+
+func ReissueBillingInvoice(ctx *gin.Context) {
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid billing invoice ID",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var status models.PaymentStatus
+	err = tx.QueryRow(`SELECT status FROM billing_invoices WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if err != nil {
+		log.Println("Failed to get billing invoice", err)
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Billing invoice not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if status != models.PaymentStatusExpired {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only expired billing invoices can be reissued",
+		})
+		return
+	}
+
+	bi, err := billingService.IssueInvoice(tx, id)
+	if err != nil {
+		log.Println("Failed to reissue billing invoice", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to create invoice",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Billing invoice reissued successfully",
+		"data":    bi,
+	})
+}
+
+func RunMonthlyBilling(ctx *gin.Context) {
+	var body RunBillingDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	period, err := time.Parse("2006-01", body.Period)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid 'period', expected YYYY-MM",
+		})
+		return
+	}
+
+	if !period.Before(billingService.MonthStart(time.Now())) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only past months can be billed",
+		})
+		return
+	}
+
+	invoices, err := billingService.RunMonthlyBilling(period)
+	if err != nil {
+		log.Println("Failed running monthly billing", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Issued %d billing invoice(s)", len(invoices)),
+		"data":    invoices,
+	})
+}
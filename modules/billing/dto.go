@@ -0,0 +1,5 @@
+package billing
+
+type RunBillingDto struct {
+	Period string `json:"period" binding:"required"` // YYYY-MM
+}
@@ -0,0 +1,16 @@
+package billing
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.GET("/invoices", middlewares.JwtAuthMiddleware(), GetBillingInvoicesList)
+	rg.GET("/invoices/:id", middlewares.JwtAuthMiddleware(), GetBillingInvoiceByID)
+	rg.GET("/invoices/:id/statement.csv", middlewares.JwtAuthMiddleware(), DownloadBillingInvoiceCSV)
+	rg.GET("/invoices/:id/statement.pdf", middlewares.JwtAuthMiddleware(), DownloadBillingInvoicePDF)
+	rg.POST("/invoices/:id/reissue", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), ReissueBillingInvoice)
+	rg.POST("/run", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), RunMonthlyBilling)
+}
@@ -1,16 +1,62 @@
 package branches
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
 	"github.com/masadamsahid/golang-gin-goldship-api/db"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/googlemap"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/network"
 )
 
+// scheduleFromDto converts the request schedule, a nil list stays nil so ReplaceSchedule leaves it untouched
+func scheduleFromDto(hoursDto []OperatingHourDto, holidaysDto []HolidayDto) ([]models.BranchOperatingHour, []models.BranchHoliday, error) {
+	var hours []models.BranchOperatingHour
+	if hoursDto != nil {
+		hours = []models.BranchOperatingHour{}
+		seen := map[int]bool{}
+		for _, h := range hoursDto {
+			if seen[*h.Weekday] {
+				return nil, nil, fmt.Errorf("Weekday %d is listed more than once", *h.Weekday)
+			}
+			if h.ClosesAt <= h.OpensAt {
+				return nil, nil, fmt.Errorf("Closing time must be after opening time on weekday %d", *h.Weekday)
+			}
+			seen[*h.Weekday] = true
+			hours = append(hours, models.BranchOperatingHour{Weekday: *h.Weekday, OpensAt: h.OpensAt, ClosesAt: h.ClosesAt})
+		}
+	}
+
+	var holidays []models.BranchHoliday
+	if holidaysDto != nil {
+		holidays = []models.BranchHoliday{}
+		seen := map[string]bool{}
+		for _, h := range holidaysDto {
+			if seen[h.Date] {
+				return nil, nil, fmt.Errorf("Holiday %s is listed more than once", h.Date)
+			}
+			seen[h.Date] = true
+			holidays = append(holidays, models.BranchHoliday{Date: h.Date, Reason: h.Reason})
+		}
+	}
+
+	return hours, holidays, nil
+}
+
 func HandleCreateBranch(ctx *gin.Context) {
 	var body CreateBranchDto
 	err := ctx.ShouldBind(&body)
@@ -31,17 +77,64 @@ func HandleCreateBranch(ctx *gin.Context) {
 		return
 	}
 
-	sqlCreateBranch := `INSERT INTO branches (name, phone, address) VALUES ($1, $2, $3) RETURNING id, name, phone, address, created_at, updated_at`
-	var newBranch models.Branch
-	err = db.DB.QueryRow(sqlCreateBranch, body.Name, body.Phone, body.Address).Scan(
-		&newBranch.ID,
-		&newBranch.Name,
-		&newBranch.Phone,
-		&newBranch.Address,
-		&newBranch.CreatedAt,
-		&newBranch.UpdatedAt,
-	)
+	if (body.Latitude == nil) != (body.Longitude == nil) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "'latitude' and 'longitude' must be set together",
+		})
+		return
+	}
+
+	// New branches get regular office hours unless told otherwise
+	if body.OperatingHours == nil {
+		for weekday := 1; weekday <= 6; weekday++ {
+			body.OperatingHours = append(body.OperatingHours, OperatingHourDto{Weekday: &weekday, OpensAt: "08:00", ClosesAt: "17:00"})
+		}
+	}
+
+	hours, holidays, err := scheduleFromDto(body.OperatingHours, body.Holidays)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed creating branch",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	sqlCreateBranch := `
+	INSERT INTO branches (name, phone, address, latitude, longitude, service_radius, timezone, city, region, pickup_slot_capacity)
+	VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, 'Asia/Jakarta'), $8, $9, COALESCE($10, 20))
+	RETURNING ` + branch.Columns
+	newBranch := make([]models.Branch, 1)
+	err = branch.Scan(tx.QueryRow(
+		sqlCreateBranch,
+		body.Name,
+		body.Phone,
+		body.Address,
+		body.Latitude,
+		body.Longitude,
+		body.ServiceRadius,
+		body.Timezone,
+		body.City,
+		body.Region,
+		body.PickupSlotCapacity,
+	), &newBranch[0])
+	if err == nil {
+		err = branch.ReplaceSchedule(tx, newBranch[0].ID, hours, holidays)
+	}
+	if err == nil {
+		err = branch.LoadSchedules(tx, newBranch, time.Now())
+	}
 	if err != nil {
+		tx.Rollback()
 		status := http.StatusInternalServerError
 		msg := "Failed creating branch"
 
@@ -52,12 +145,34 @@ func HandleCreateBranch(ctx *gin.Context) {
 		return
 	}
 
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed creating branch",
+		})
+		return
+	}
+
 	ctx.JSON(http.StatusCreated, gin.H{
 		"message": "Branch created successfully",
-		"data":    newBranch,
+		"data":    newBranch[0],
 	})
 }
 
+type listedBranch struct {
+	models.Branch
+	Distance *int `json:"distance,omitempty"` // in meters, only when 'lat' and 'lng' are given
+}
+
+var branchSorts = map[string]string{
+	"created_at":  "created_at ASC, id ASC",
+	"-created_at": "created_at DESC, id DESC",
+	"name":        "name ASC, id ASC",
+	"-name":       "name DESC, id DESC",
+	"distance":    "distance ASC NULLS LAST, id ASC",
+}
+
 func HandleGetBranchesList(ctx *gin.Context) {
 	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
 	if err != nil {
@@ -67,9 +182,75 @@ func HandleGetBranchesList(ctx *gin.Context) {
 		return
 	}
 
-	sqlGetBranches := `SELECT id, name, phone, address, created_at, updated_at FROM branches ORDER BY created_at ASC LIMIT $1 OFFSET $2`
+	var lat, lng *float64
+	if ctx.Query("lat") != "" || ctx.Query("lng") != "" {
+		parsedLat, latErr := strconv.ParseFloat(ctx.Query("lat"), 64)
+		parsedLng, lngErr := strconv.ParseFloat(ctx.Query("lng"), 64)
+		if latErr != nil || lngErr != nil || parsedLat < -90 || parsedLat > 90 || parsedLng < -180 || parsedLng > 180 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'lat' or 'lng'",
+			})
+			return
+		}
+		lat, lng = &parsedLat, &parsedLng
+	}
+
+	sortBy := ctx.DefaultQuery("sort", "created_at")
+	orderBy, ok := branchSorts[sortBy]
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "'sort' must be one of created_at, -created_at, name, -name or distance",
+		})
+		return
+	}
+	if sortBy == "distance" && lat == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Sorting by distance requires 'lat' and 'lng'",
+		})
+		return
+	}
+
+	openNow := false
+	if strOpenNow := ctx.Query("open_now"); strOpenNow != "" {
+		openNow, err = strconv.ParseBool(strOpenNow)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'open_now'",
+			})
+			return
+		}
+	}
+
+	var city, region, search *string
+	if c := strings.TrimSpace(ctx.Query("city")); c != "" {
+		city = &c
+	}
+	if r := strings.TrimSpace(ctx.Query("region")); r != "" {
+		region = &r
+	}
+	if q := strings.TrimSpace(ctx.Query("q")); q != "" {
+		search = &q
+	}
+
+	sqlBranchFilter := `
+	WHERE deleted_at IS NULL
+		AND ($1::TEXT IS NULL OR LOWER(city) = LOWER($1))
+		AND ($2::TEXT IS NULL OR LOWER(region) = LOWER($2))
+		AND ($3::TEXT IS NULL OR search_vector @@ websearch_to_tsquery('simple', $3))
+		AND (NOT $4::BOOLEAN OR (` + branch.SqlIsOpenNow + `))
+	`
+
+	sqlGetBranches := `
+	SELECT ` + branch.Columns + `,
+		CASE WHEN $5::FLOAT8 IS NOT NULL AND latitude IS NOT NULL AND longitude IS NOT NULL
+			THEN (` + branch.SqlDistance("$5", "$6::FLOAT8") + `)::INT
+		END AS distance
+	FROM branches
+	` + sqlBranchFilter + `
+	ORDER BY ` + orderBy + `
+	LIMIT $7 OFFSET $8`
 	offset := (page - 1) * pageSize
-	rows, err := db.DB.Query(sqlGetBranches, pageSize, offset)
+	rows, err := db.DB.Query(sqlGetBranches, city, region, search, openNow, lat, lng, pageSize, offset)
 	if err != nil {
 		log.Println(err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -78,18 +259,11 @@ func HandleGetBranchesList(ctx *gin.Context) {
 		return
 	}
 
-	var branches []models.Branch
+	branches := []listedBranch{}
 	defer rows.Close()
 	for rows.Next() {
-		var b models.Branch
-		err := rows.Scan(
-			&b.ID,
-			&b.Name,
-			&b.Phone,
-			&b.Address,
-			&b.CreatedAt,
-			&b.UpdatedAt,
-		)
+		var b listedBranch
+		err := branch.Scan(scanWithExtra{rows, &b.Distance}, &b.Branch)
 		if err != nil {
 			log.Println(err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -100,27 +274,54 @@ func HandleGetBranchesList(ctx *gin.Context) {
 		branches = append(branches, b)
 	}
 
+	var totalBranches int
+	err = db.DB.QueryRow(`SELECT COUNT(id) FROM branches `+sqlBranchFilter, city, region, search, openNow).Scan(&totalBranches)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving branches",
+		})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Success retrieving branches",
 		"data":    branches,
+		"meta": gin.H{
+			"total":     totalBranches,
+			"page":      page,
+			"page_size": pageSize,
+		},
 	})
 }
 
+// scanWithExtra lets branch.Scan read rows that have extra columns after branch.Columns
+type scanWithExtra struct {
+	row   interface{ Scan(dest ...any) error }
+	extra any
+}
+
+func (s scanWithExtra) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra)...)
+}
+
 func HandleGetBranchByID(ctx *gin.Context) {
 	id := ctx.Param("id")
 
-	sqlGetBranch := `SELECT id, name, phone, address, created_at, updated_at FROM branches WHERE id = $1`
-	var branch models.Branch
-	err := db.DB.QueryRow(sqlGetBranch, id).Scan(
-		&branch.ID,
-		&branch.Name,
-		&branch.Phone,
-		&branch.Address,
-		&branch.CreatedAt,
-		&branch.UpdatedAt,
-	)
+	sqlGetBranch := `SELECT ` + branch.Columns + ` FROM branches WHERE id = $1`
+	result := make([]models.Branch, 1)
+	err := branch.Scan(db.DB.QueryRow(sqlGetBranch, id), &result[0])
+	if err == nil {
+		err = branch.LoadSchedules(db.DB, result, time.Now())
+	}
 	if err != nil {
 		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Failed retrieving branch",
 		})
@@ -129,15 +330,138 @@ func HandleGetBranchByID(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Success retrieving branch",
-		"data":    branch,
+		"data":    result[0],
+	})
+}
+
+type nearestBranch struct {
+	models.Branch
+	Distance int `json:"distance"` // in meters
+}
+
+// HandleGetNearestBranches ranks the branches that are open right now by their distance to the customer
+func HandleGetNearestBranches(ctx *gin.Context) {
+	var lat, lng float64
+	if ctx.Query("lat") != "" || ctx.Query("lng") != "" {
+		var latErr, lngErr error
+		lat, latErr = strconv.ParseFloat(ctx.Query("lat"), 64)
+		lng, lngErr = strconv.ParseFloat(ctx.Query("lng"), 64)
+		if latErr != nil || lngErr != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'lat' or 'lng'",
+			})
+			return
+		}
+	} else if address := ctx.Query("address"); address != "" {
+		location, err := googlemap.Geocode(address)
+		if err != nil {
+			log.Println("Failed geocoding address", err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Could not locate the given address",
+			})
+			return
+		}
+		lat, lng = location.Lat, location.Lng
+	} else {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Either 'lat' and 'lng' or 'address' is required",
+		})
+		return
+	}
+
+	limit := 5
+	if strLimit := ctx.Query("limit"); strLimit != "" {
+		l, err := strconv.Atoi(strLimit)
+		if err != nil || l < 1 || l > 50 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "'limit' must be between 1 and 50",
+			})
+			return
+		}
+		limit = l
+	}
+
+	sqlGetBranches := `SELECT ` + branch.Columns + ` FROM branches WHERE latitude IS NOT NULL AND longitude IS NOT NULL AND deleted_at IS NULL`
+	rows, err := db.DB.Query(sqlGetBranches)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving branches",
+		})
+		return
+	}
+
+	var branches []models.Branch
+	for rows.Next() {
+		var b models.Branch
+		if err := branch.Scan(rows, &b); err != nil {
+			rows.Close()
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving branches",
+			})
+			return
+		}
+		branches = append(branches, b)
+	}
+	rows.Close()
+
+	now := time.Now()
+	// Branches ahead of the server's time zone may already be on the next day, starting a day early covers them
+	err = branch.LoadSchedules(db.DB, branches, now.AddDate(0, 0, -1))
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving branches",
+		})
+		return
+	}
+
+	nearest := []nearestBranch{}
+	for _, b := range branches {
+		distance := branch.Distance(lat, lng, *b.Latitude, *b.Longitude)
+		if b.ServiceRadius != nil && distance > float64(*b.ServiceRadius) {
+			continue
+		}
+		if !branch.IsOpenAt(b, now) {
+			continue
+		}
+		nearest = append(nearest, nearestBranch{Branch: b, Distance: int(distance)})
+	}
+
+	sort.Slice(nearest, func(i, j int) bool {
+		return nearest[i].Distance < nearest[j].Distance
+	})
+	if len(nearest) > limit {
+		nearest = nearest[:limit]
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving nearest branches",
+		"data":    nearest,
 	})
 }
 
 // TODO: Implement optional field updating
 func HandleUpdateBranch(ctx *gin.Context) {
 	id := ctx.Param("id")
+	branchID, err := strconv.Atoi(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid branch ID",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, branchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only manage the branches you are assigned to",
+		})
+		return
+	}
+
 	var body UpdateBranchDto
-	err := ctx.ShouldBind(&body)
+	err = ctx.ShouldBind(&body)
 	if err != nil {
 		validationErrors, ok := err.(validator.ValidationErrors)
 		if !ok {
@@ -155,19 +479,75 @@ func HandleUpdateBranch(ctx *gin.Context) {
 		return
 	}
 
-	sqlUpdateBranch := `UPDATE branches SET name = $2, phone = $3, address = $4, updated_at = NOW() WHERE id = $1 RETURNING id, name, phone, address, created_at, updated_at`
-	var updatedBranch models.Branch
-	err = db.DB.QueryRow(sqlUpdateBranch, id, body.Name, body.Phone, body.Address).Scan(
-		&updatedBranch.ID,
-		&updatedBranch.Name,
-		&updatedBranch.Phone,
-		&updatedBranch.Address,
-		&updatedBranch.CreatedAt,
-		&updatedBranch.UpdatedAt,
-	)
+	if (body.Latitude == nil) != (body.Longitude == nil) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "'latitude' and 'longitude' must be set together",
+		})
+		return
+	}
+
+	hours, holidays, err := scheduleFromDto(body.OperatingHours, body.Holidays)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed updating branch",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	sqlUpdateBranch := `
+	UPDATE branches SET
+		name = $2,
+		phone = $3,
+		address = $4,
+		latitude = $5,
+		longitude = $6,
+		service_radius = $7,
+		timezone = COALESCE($8, timezone),
+		city = $9,
+		region = $10,
+		pickup_slot_capacity = COALESCE($11, pickup_slot_capacity),
+		updated_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING ` + branch.Columns
+	updatedBranch := make([]models.Branch, 1)
+	err = branch.Scan(tx.QueryRow(
+		sqlUpdateBranch,
+		id,
+		body.Name,
+		body.Phone,
+		body.Address,
+		body.Latitude,
+		body.Longitude,
+		body.ServiceRadius,
+		body.Timezone,
+		body.City,
+		body.Region,
+		body.PickupSlotCapacity,
+	), &updatedBranch[0])
+	if err == nil {
+		err = branch.ReplaceSchedule(tx, updatedBranch[0].ID, hours, holidays)
+	}
+	if err == nil {
+		err = branch.LoadSchedules(tx, updatedBranch, time.Now())
+	}
 	if err != nil {
+		tx.Rollback()
 		status := http.StatusInternalServerError
 		msg := "Failed updating branch"
+		if errors.Is(err, sql.ErrNoRows) {
+			status = http.StatusNotFound
+			msg = "Branch not found"
+		}
 
 		log.Println(err)
 		ctx.JSON(status, gin.H{
@@ -176,18 +556,126 @@ func HandleUpdateBranch(ctx *gin.Context) {
 		return
 	}
 
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed updating branch",
+		})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Branch updated successfully",
-		"data":    updatedBranch,
+		"data":    updatedBranch[0],
 	})
 }
 
+type affectedShipment struct {
+	id                  int
+	status              string
+	originBranchID      *int
+	destinationBranchID *int
+	lastSeenBranchID    *int
+}
+
+// HandleDeleteBranch soft deletes a branch. It is refused while packages or line-haul work are still at the branch,
+// and when staff or planned routes depend on it they are moved to the 'reassign_to' branch.
 func HandleDeleteBranch(ctx *gin.Context) {
 	id := ctx.Param("id")
+	branchID, err := strconv.Atoi(id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid branch ID",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, branchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only manage the branches you are assigned to",
+		})
+		return
+	}
 
-	sqlDeleteBranch := `DELETE FROM branches WHERE id = $1`
-	_, err := db.DB.Exec(sqlDeleteBranch, id)
+	var reassignTo *int
+	if strReassignTo := ctx.Query("reassign_to"); strReassignTo != "" {
+		targetID, err := strconv.Atoi(strReassignTo)
+		if err != nil || targetID == branchID {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'reassign_to'",
+			})
+			return
+		}
+		reassignTo = &targetID
+
+		// Staff and stock move to the target, branch admins cannot hand them to a branch they don't manage
+		if !middlewares.CanAccessBranch(ctx, targetID) {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "You can only reassign to the branches you are assigned to",
+			})
+			return
+		}
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed deleting branch",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var deletedAt *time.Time
+	err = tx.QueryRow(`SELECT deleted_at FROM branches WHERE id = $1 FOR UPDATE`, branchID).Scan(&deletedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed deleting branch",
+		})
+		return
+	}
+	if err != nil || deletedAt != nil {
+		tx.Rollback()
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Branch not found",
+		})
+		return
+	}
+
+	if reassignTo != nil {
+		active, err := branch.AllActive(tx, *reassignTo)
+		if err != nil {
+			tx.Rollback()
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed deleting branch",
+			})
+			return
+		}
+		if !active {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Reassignment branch not found",
+			})
+			return
+		}
+	}
+
+	sqlGetWorkload := `
+	SELECT
+		(SELECT COUNT(*) FROM shipments WHERE current_branch_id = $1),
+		(SELECT COUNT(*) FROM bags WHERE (origin_branch_id = $1 OR destination_branch_id = $1) AND status <> $2)
+			+ (SELECT COUNT(*) FROM manifests WHERE (origin_branch_id = $1 OR destination_branch_id = $1) AND status <> $3),
+		(SELECT COUNT(*) FROM branch_staff WHERE branch_id = $1)
+	`
+	var heldCount, linehaulCount, staffCount int
+	err = tx.QueryRow(sqlGetWorkload, branchID, models.BagStatusArrived, models.ManifestStatusArrived).Scan(&heldCount, &linehaulCount, &staffCount)
 	if err != nil {
+		tx.Rollback()
 		log.Println(err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Failed deleting branch",
@@ -195,7 +683,637 @@ func HandleDeleteBranch(ctx *gin.Context) {
 		return
 	}
 
+	if heldCount > 0 {
+		tx.Rollback()
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": fmt.Sprintf("Branch still holds %d packages, move them out before deleting it", heldCount),
+		})
+		return
+	}
+	if linehaulCount > 0 {
+		tx.Rollback()
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": fmt.Sprintf("Branch still has %d line-haul bags or manifests in progress", linehaulCount),
+		})
+		return
+	}
+
+	// Shipments that have yet to enter the network at the branch, are headed to it, or still have to pass through it
+	sqlGetAffected := `
+	SELECT
+		s.id,
+		s.status,
+		s.origin_branch_id,
+		s.destination_branch_id,
+		COALESCE(s.current_branch_id, (
+			SELECT l.to_branch_id FROM shipment_route_legs l
+			WHERE l.shipment_id = s.id AND l.arrived_at IS NOT NULL
+			ORDER BY l.sequence DESC
+			LIMIT 1
+		))
+	FROM shipments s
+	WHERE s.status NOT IN ($2, $3, $7)
+		AND (
+			(s.origin_branch_id = $1 AND s.status IN ($4, $5, $6))
+			OR s.destination_branch_id = $1
+			OR EXISTS (
+				SELECT 1 FROM shipment_route_legs l
+				WHERE l.shipment_id = s.id AND l.arrived_at IS NULL AND (l.from_branch_id = $1 OR l.to_branch_id = $1)
+			)
+		)
+	ORDER BY s.id
+	FOR UPDATE
+	`
+	rows, err := tx.Query(
+		sqlGetAffected, branchID,
+		models.StatusDelivered, models.StatusCancelled,
+		models.StatusPendingPayment, models.StatusReadyToPickup, models.StatusPickedUp,
+		models.StatusWrittenOff,
+	)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed deleting branch",
+		})
+		return
+	}
+	var affected []affectedShipment
+	for rows.Next() {
+		var a affectedShipment
+		if err := rows.Scan(&a.id, &a.status, &a.originBranchID, &a.destinationBranchID, &a.lastSeenBranchID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed deleting branch",
+			})
+			return
+		}
+		affected = append(affected, a)
+	}
+	rows.Close()
+
+	if reassignTo == nil && (staffCount > 0 || len(affected) > 0) {
+		tx.Rollback()
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": fmt.Sprintf("Branch still has %d staff and %d shipments planned through it, pass 'reassign_to' to move them to another branch", staffCount, len(affected)),
+		})
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE branches SET deleted_at = NOW(), updated_at = NOW() WHERE id = $1`, branchID)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE branch_links SET is_active = FALSE, updated_at = NOW() WHERE (from_branch_id = $1 OR to_branch_id = $1) AND is_active = TRUE`, branchID)
+	}
+	if err == nil && staffCount > 0 {
+		_, err = tx.Exec(`INSERT INTO branch_staff (user_id, branch_id) SELECT user_id, $2 FROM branch_staff WHERE branch_id = $1 ON CONFLICT DO NOTHING`, branchID, *reassignTo)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM branch_staff WHERE branch_id = $1`, branchID)
+		}
+	}
+
+	unrouted := 0
+	for _, a := range affected {
+		if err != nil {
+			break
+		}
+
+		notYetReceived := a.status == models.StatusPendingPayment || a.status == models.StatusReadyToPickup || a.status == models.StatusPickedUp
+		if a.originBranchID != nil && *a.originBranchID == branchID && notYetReceived {
+			a.originBranchID = reassignTo
+		}
+		if a.destinationBranchID != nil && *a.destinationBranchID == branchID {
+			a.destinationBranchID = reassignTo
+		}
+		_, err = tx.Exec(
+			`UPDATE shipments SET origin_branch_id = $2, destination_branch_id = $3, updated_at = NOW() WHERE id = $1`,
+			a.id, a.originBranchID, a.destinationBranchID,
+		)
+		if err != nil {
+			break
+		}
+
+		from := a.lastSeenBranchID
+		if from == nil {
+			from = a.originBranchID
+		}
+		if from == nil || a.destinationBranchID == nil {
+			continue
+		}
+		_, err = network.PlanShipmentRoute(tx, a.id, *from, *a.destinationBranchID)
+		if errors.Is(err, network.ErrNoRoute) {
+			log.Printf("No route for shipment %d after deleting branch %d\n", a.id, branchID)
+			unrouted++
+			err = nil
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed deleting branch",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed deleting branch",
+		})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Branch deleted successfully",
+		"data": gin.H{
+			"reassigned_to":      reassignTo,
+			"reassigned_staff":   staffCount,
+			"rerouted_shipments": len(affected),
+			"unrouted_shipments": unrouted,
+		},
+	})
+}
+
+// branchForStaff resolves the :id param for the branch staff endpoints, writing the error response itself when it fails
+func branchForStaff(ctx *gin.Context) (int, bool) {
+	branchID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid branch ID",
+		})
+		return 0, false
+	}
+
+	if !middlewares.CanAccessBranch(ctx, branchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only manage the branches you are assigned to",
+		})
+		return 0, false
+	}
+
+	var exists bool
+	err = db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM branches WHERE id = $1 AND deleted_at IS NULL)`, branchID).Scan(&exists)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return 0, false
+	}
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Branch not found",
+		})
+		return 0, false
+	}
+
+	return branchID, true
+}
+
+// HandleGetBranchInventory lists the packages sitting at a branch right now, oldest arrivals first.
+// The counts always cover the whole branch so they are not affected by the filters.
+func HandleGetBranchInventory(ctx *gin.Context) {
+	branchID, ok := branchForStaff(ctx)
+	if !ok {
+		return
+	}
+
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var statuses []string
+	if s := ctx.Query("status"); s != "" {
+		for _, status := range strings.Split(s, ",") {
+			if status != models.StatusPickedUp && status != models.StatusReceivedAtBranch && status != models.StatusInTransit && status != models.StatusReadyForCollection && status != models.StatusOnHold {
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"message": "Invalid 'status'",
+				})
+				return
+			}
+			statuses = append(statuses, status)
+		}
+	}
+
+	var minAgeHours *int
+	if strMinAge := ctx.Query("min_age_hours"); strMinAge != "" {
+		hours, err := strconv.Atoi(strMinAge)
+		if err != nil || hours < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'min_age_hours'",
+			})
+			return
+		}
+		minAgeHours = &hours
+	}
+
+	sqlGetInventory := `
+	SELECT
+		id,
+		tracking_number,
+		status,
+		recipient_name,
+		recipient_address,
+		origin_branch_id,
+		destination_branch_id,
+		located_at,
+		(EXTRACT(EPOCH FROM NOW() - located_at) / 60)::INT
+	FROM shipments
+	WHERE current_branch_id = $1
+		AND ($2::TEXT[] IS NULL OR status::TEXT = ANY($2))
+		AND ($3::INT IS NULL OR located_at <= NOW() - make_interval(hours => $3))
+	ORDER BY located_at ASC, id ASC
+	LIMIT $4 OFFSET $5
+	`
+	var statusFilter any
+	if statuses != nil {
+		statusFilter = pq.Array(statuses)
+	}
+	offset := (page - 1) * pageSize
+	rows, err := db.DB.Query(sqlGetInventory, branchID, statusFilter, minAgeHours, pageSize, offset)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving branch inventory",
+		})
+		return
+	}
+
+	items := []models.InventoryItem{}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.InventoryItem
+		err := rows.Scan(
+			&item.ShipmentID,
+			&item.TrackingNumber,
+			&item.Status,
+			&item.RecipientName,
+			&item.RecipientAddress,
+			&item.OriginBranchID,
+			&item.DestinationBranchID,
+			&item.LocatedAt,
+			&item.AgeMinutes,
+		)
+		if err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving branch inventory",
+			})
+			return
+		}
+		items = append(items, item)
+	}
+
+	sqlCountInventory := `
+	SELECT
+		status,
+		COUNT(*),
+		COUNT(*) FILTER (WHERE located_at > NOW() - INTERVAL '24 hours'),
+		COUNT(*) FILTER (WHERE located_at <= NOW() - INTERVAL '24 hours' AND located_at > NOW() - INTERVAL '72 hours'),
+		COUNT(*) FILTER (WHERE located_at <= NOW() - INTERVAL '72 hours')
+	FROM shipments
+	WHERE current_branch_id = $1
+	GROUP BY status
+	`
+	countRows, err := db.DB.Query(sqlCountInventory, branchID)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving branch inventory",
+		})
+		return
+	}
+	defer countRows.Close()
+
+	total := 0
+	byStatus := map[string]int{}
+	byAge := map[string]int{"under_24h": 0, "24h_to_72h": 0, "over_72h": 0}
+	for countRows.Next() {
+		var status string
+		var count, under24h, under72h, over72h int
+		err := countRows.Scan(&status, &count, &under24h, &under72h, &over72h)
+		if err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving branch inventory",
+			})
+			return
+		}
+		total += count
+		byStatus[status] = count
+		byAge["under_24h"] += under24h
+		byAge["24h_to_72h"] += under72h
+		byAge["over_72h"] += over72h
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving branch inventory",
+		"data":    items,
+		"counts": gin.H{
+			"total":     total,
+			"by_status": byStatus,
+			"by_age":    byAge,
+		},
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// HandleCreateStockTake compares the tracking numbers scanned on the floor with the packages the system expects at the branch
+func HandleCreateStockTake(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	branchID, ok := branchForStaff(ctx)
+	if !ok {
+		return
+	}
+
+	var body StockTakeDto
+	err = ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	// The same parcel is often scanned more than once
+	scanned := []string{}
+	seen := map[string]bool{}
+	for _, trackingNumber := range body.TrackingNumbers {
+		trackingNumber = strings.TrimSpace(trackingNumber)
+		if trackingNumber == "" || seen[trackingNumber] {
+			continue
+		}
+		seen[trackingNumber] = true
+		scanned = append(scanned, trackingNumber)
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	sqlGetStock := `
+	SELECT id, tracking_number, current_branch_id
+	FROM shipments
+	WHERE current_branch_id = $1 OR tracking_number = ANY($2)
+	ORDER BY id ASC
+	`
+	rows, err := tx.Query(sqlGetStock, branchID, pq.Array(scanned))
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed taking stock",
+		})
+		return
+	}
+
+	stockTake := models.StockTake{BranchID: branchID, TakenBy: int(user.ID), ScannedCount: len(scanned)}
+	known := map[string]bool{}
+	for rows.Next() {
+		var shipmentID int
+		var trackingNumber string
+		var currentBranchID *int
+		if err := rows.Scan(&shipmentID, &trackingNumber, &currentBranchID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed taking stock",
+			})
+			return
+		}
+		known[trackingNumber] = true
+
+		expected := currentBranchID != nil && *currentBranchID == branchID
+		item := models.StockTakeItem{TrackingNumber: trackingNumber, ShipmentID: &shipmentID}
+		switch {
+		case expected && seen[trackingNumber]:
+			item.Result = models.StockTakeFound
+		case expected:
+			item.Result = models.StockTakeMissing
+			stockTake.MissingCount++
+		default:
+			item.Result = models.StockTakeUnexpected
+			item.CurrentBranchID = currentBranchID
+			stockTake.UnexpectedCount++
+		}
+		if expected {
+			stockTake.ExpectedCount++
+		}
+		stockTake.Items = append(stockTake.Items, item)
+	}
+	rows.Close()
+
+	for _, trackingNumber := range scanned {
+		if !known[trackingNumber] {
+			stockTake.Items = append(stockTake.Items, models.StockTakeItem{TrackingNumber: trackingNumber, Result: models.StockTakeUnexpected})
+			stockTake.UnexpectedCount++
+		}
+	}
+
+	sqlCreateStockTake := `
+	INSERT INTO stock_takes (branch_id, taken_by, expected_count, scanned_count, missing_count, unexpected_count)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+	err = tx.QueryRow(
+		sqlCreateStockTake,
+		stockTake.BranchID,
+		stockTake.TakenBy,
+		stockTake.ExpectedCount,
+		stockTake.ScannedCount,
+		stockTake.MissingCount,
+		stockTake.UnexpectedCount,
+	).Scan(&stockTake.ID, &stockTake.CreatedAt)
+	if err == nil {
+		for _, item := range stockTake.Items {
+			_, err = tx.Exec(
+				`INSERT INTO stock_take_items (stock_take_id, tracking_number, shipment_id, result) VALUES ($1, $2, $3, $4)`,
+				stockTake.ID, item.TrackingNumber, item.ShipmentID, item.Result,
+			)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed saving stock take", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed taking stock",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": fmt.Sprintf("Stock taken, %d missing and %d unexpected packages", stockTake.MissingCount, stockTake.UnexpectedCount),
+		"data":    stockTake,
+	})
+}
+
+func HandleGetStockTakes(ctx *gin.Context) {
+	branchID, ok := branchForStaff(ctx)
+	if !ok {
+		return
+	}
+
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	sqlGetStockTakes := `
+	SELECT id, branch_id, taken_by, expected_count, scanned_count, missing_count, unexpected_count, created_at
+	FROM stock_takes
+	WHERE branch_id = $1
+	ORDER BY created_at DESC
+	LIMIT $2 OFFSET $3
+	`
+	offset := (page - 1) * pageSize
+	rows, err := db.DB.Query(sqlGetStockTakes, branchID, pageSize, offset)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving stock takes",
+		})
+		return
+	}
+
+	stockTakes := []models.StockTake{}
+	defer rows.Close()
+	for rows.Next() {
+		var st models.StockTake
+		err := rows.Scan(&st.ID, &st.BranchID, &st.TakenBy, &st.ExpectedCount, &st.ScannedCount, &st.MissingCount, &st.UnexpectedCount, &st.CreatedAt)
+		if err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving stock takes",
+			})
+			return
+		}
+		stockTakes = append(stockTakes, st)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving stock takes",
+		"data":    stockTakes,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func HandleGetStockTakeByID(ctx *gin.Context) {
+	branchID, ok := branchForStaff(ctx)
+	if !ok {
+		return
+	}
+
+	stockTakeID, err := strconv.Atoi(ctx.Param("stockTakeId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid stock take ID",
+		})
+		return
+	}
+
+	var st models.StockTake
+	sqlGetStockTake := `
+	SELECT id, branch_id, taken_by, expected_count, scanned_count, missing_count, unexpected_count, created_at
+	FROM stock_takes
+	WHERE id = $1 AND branch_id = $2
+	`
+	err = db.DB.QueryRow(sqlGetStockTake, stockTakeID, branchID).Scan(
+		&st.ID, &st.BranchID, &st.TakenBy, &st.ExpectedCount, &st.ScannedCount, &st.MissingCount, &st.UnexpectedCount, &st.CreatedAt,
+	)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Stock take not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving stock take",
+		})
+		return
+	}
+
+	rows, err := db.DB.Query(
+		`SELECT tracking_number, shipment_id, result FROM stock_take_items WHERE stock_take_id = $1 ORDER BY result, tracking_number`,
+		st.ID,
+	)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving stock take",
+		})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.StockTakeItem
+		if err := rows.Scan(&item.TrackingNumber, &item.ShipmentID, &item.Result); err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving stock take",
+			})
+			return
+		}
+		st.Items = append(st.Items, item)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving stock take",
+		"data":    st,
 	})
 }
@@ -1,13 +1,47 @@
 package branches
 
+type OperatingHourDto struct {
+	Weekday  *int   `json:"weekday" binding:"required,min=0,max=6"`
+	OpensAt  string `json:"opens_at" binding:"required,datetime=15:04"`
+	ClosesAt string `json:"closes_at" binding:"required,datetime=15:04"`
+}
+
+type HolidayDto struct {
+	Date   string  `json:"date" binding:"required,datetime=2006-01-02"`
+	Reason *string `json:"reason"`
+}
+
 type CreateBranchDto struct {
-	Name    string `json:"name" binding:"required"`
-	Address string `json:"address" binding:"required"`
-	Phone   string `json:"phone" binding:"required"`
+	Name               string             `json:"name" binding:"required"`
+	Address            string             `json:"address" binding:"required"`
+	Phone              string             `json:"phone" binding:"required"`
+	City               *string            `json:"city"`
+	Region             *string            `json:"region"`
+	Latitude           *float64           `json:"latitude" binding:"omitempty,latitude"`
+	Longitude          *float64           `json:"longitude" binding:"omitempty,longitude"`
+	ServiceRadius      *int               `json:"service_radius" binding:"omitempty,gt=0"`
+	Timezone           *string            `json:"timezone" binding:"omitempty,timezone"`
+	PickupSlotCapacity *int               `json:"pickup_slot_capacity" binding:"omitempty,gte=0"` // defaults to 20 courier pickups per window
+	OperatingHours     []OperatingHourDto `json:"operating_hours" binding:"omitempty,dive"`
+	Holidays           []HolidayDto       `json:"holidays" binding:"omitempty,dive"`
 }
 
+// Omitting operating_hours or holidays keeps the current ones, an empty list clears them
 type UpdateBranchDto struct {
-	Name    string `json:"name"`
-	Address string `json:"address"`
-	Phone   string `json:"phone"`
+	Name               string             `json:"name"`
+	Address            string             `json:"address"`
+	Phone              string             `json:"phone"`
+	City               *string            `json:"city"`
+	Region             *string            `json:"region"`
+	Latitude           *float64           `json:"latitude" binding:"omitempty,latitude"`
+	Longitude          *float64           `json:"longitude" binding:"omitempty,longitude"`
+	ServiceRadius      *int               `json:"service_radius" binding:"omitempty,gt=0"`
+	Timezone           *string            `json:"timezone" binding:"omitempty,timezone"`
+	PickupSlotCapacity *int               `json:"pickup_slot_capacity" binding:"omitempty,gte=0"` // omit to keep the current capacity
+	OperatingHours     []OperatingHourDto `json:"operating_hours" binding:"omitempty,dive"`
+	Holidays           []HolidayDto       `json:"holidays" binding:"omitempty,dive"`
+}
+
+type StockTakeDto struct {
+	TrackingNumbers []string `json:"tracking_numbers" binding:"required,min=1,dive,required"`
 }
@@ -9,7 +9,12 @@ import (
 func Routes(rg *gin.RouterGroup) {
 	rg.POST("/", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleCreateBranch)
 	rg.GET("/", HandleGetBranchesList)
+	rg.GET("/nearest", HandleGetNearestBranches)
 	rg.GET("/:id", HandleGetBranchByID)
-	rg.PUT("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleUpdateBranch)
-	rg.DELETE("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleDeleteBranch)
+	rg.GET("/:id/inventory", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleGetBranchInventory)
+	rg.POST("/:id/stock-takes", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleCreateStockTake)
+	rg.GET("/:id/stock-takes", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleGetStockTakes)
+	rg.GET("/:id/stock-takes/:stockTakeId", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleGetStockTakeByID)
+	rg.PUT("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleUpdateBranch)
+	rg.DELETE("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleDeleteBranch)
 }
@@ -0,0 +1,682 @@
+package claims
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/wallet"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func isStaff(user helpers.AuthPayload) bool {
+	return user.Role == roles.RoleSuperAdmin || user.Role == roles.RoleAdmin
+}
+
+func bindBody(ctx *gin.Context, body any) bool {
+	err := ctx.ShouldBind(body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return false
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return false
+	}
+
+	return true
+}
+
+const claimColumns = `
+	id,
+	shipment_id,
+	claimant_id,
+	type,
+	"desc",
+	claimed_amount,
+	approved_amount,
+	status,
+	resolution_note,
+	reviewed_by,
+	reviewed_at,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanClaim(row scanner, c *models.Claim) error {
+	return row.Scan(
+		&c.ID,
+		&c.ShipmentID,
+		&c.ClaimantID,
+		&c.Type,
+		&c.Desc,
+		&c.ClaimedAmount,
+		&c.ApprovedAmount,
+		&c.Status,
+		&c.ResolutionNote,
+		&c.ReviewedBy,
+		&c.ReviewedAt,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+}
+
+func getClaim(id int) (models.Claim, error) {
+	var c models.Claim
+	err := scanClaim(db.DB.QueryRow(`SELECT `+claimColumns+` FROM claims WHERE id = $1`, id), &c)
+	if err != nil {
+		return c, err
+	}
+
+	var s models.Shipment
+	sqlGetShipment := `SELECT id, tracking_number, item_name, declared_value, is_insured, status FROM shipments WHERE id = $1`
+	err = db.DB.QueryRow(sqlGetShipment, c.ShipmentID).Scan(&s.ID, &s.TrackingNumber, &s.ItemName, &s.DeclaredValue, &s.IsInsured, &s.Status)
+	if err != nil {
+		return c, err
+	}
+	c.Shipment = &s
+
+	rows, err := db.DB.Query(`SELECT id, claim_id, url, "desc", uploaded_by, created_at FROM claim_evidences WHERE claim_id = $1 ORDER BY created_at ASC`, id)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.ClaimEvidence
+		if err := rows.Scan(&e.ID, &e.ClaimID, &e.URL, &e.Desc, &e.UploadedBy, &e.CreatedAt); err != nil {
+			return c, err
+		}
+		c.Evidences = append(c.Evidences, e)
+	}
+
+	var p models.ClaimPayout
+	sqlGetPayout := `SELECT id, claim_id, amount, method, reference, ledger_transaction_id, paid_by, paid_at FROM claim_payouts WHERE claim_id = $1`
+	err = db.DB.QueryRow(sqlGetPayout, id).Scan(&p.ID, &p.ClaimID, &p.Amount, &p.Method, &p.Reference, &p.LedgerTransactionID, &p.PaidBy, &p.PaidAt)
+	if err == nil {
+		c.Payout = &p
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return c, err
+	}
+
+	return c, nil
+}
+
+func parseClaimID(ctx *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid claim ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func HandleCreateClaim(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body CreateClaimDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	var s models.Shipment
+	sqlGetShipment := `SELECT id, sender_id, status, is_insured, declared_value FROM shipments WHERE id = $1`
+	err = db.DB.QueryRow(sqlGetShipment, body.ShipmentID).Scan(&s.ID, &s.SenderID, &s.Status, &s.IsInsured, &s.DeclaredValue)
+	if err != nil {
+		log.Println("Failed to get shipment for claim", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if uint(s.SenderID) != user.ID {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "Only the sender can file a claim for this shipment",
+		})
+		return
+	}
+
+	if !s.IsInsured {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is not insured",
+		})
+		return
+	}
+
+	if s.Status == models.StatusPendingPayment || s.Status == models.StatusCancelled {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Claims can only be filed for paid shipments",
+		})
+		return
+	}
+
+	if body.ClaimedAmount > s.DeclaredValue {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("Claimed amount cannot exceed the declared value of %d", s.DeclaredValue),
+		})
+		return
+	}
+
+	sqlCreateClaim := `
+	INSERT INTO claims (shipment_id, claimant_id, type, "desc", claimed_amount)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING ` + claimColumns
+
+	var newClaim models.Claim
+	err = scanClaim(db.DB.QueryRow(sqlCreateClaim, s.ID, user.ID, body.Type, body.Desc, body.ClaimedAmount), &newClaim)
+	if err != nil {
+		log.Println("Failed creating claim", err)
+		if strings.Contains(err.Error(), "unique constraint") {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "Shipment already has an active claim",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Claim created successfully",
+		"data":    newClaim,
+	})
+}
+
+func HandleGetClaimsList(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var claimantFilter *uint
+	if !isStaff(user) {
+		claimantFilter = &user.ID
+	}
+
+	var statusFilter *string
+	if status := ctx.Query("status"); status != "" {
+		statusFilter = &status
+	}
+
+	sqlGetClaims := `
+	SELECT ` + claimColumns + `
+	FROM claims
+	WHERE ($1::INT IS NULL OR claimant_id = $1)
+		AND ($2::TEXT IS NULL OR status::TEXT = $2)
+	ORDER BY created_at DESC
+	LIMIT $3
+	OFFSET $4
+	`
+	rows, err := db.DB.Query(sqlGetClaims, claimantFilter, statusFilter, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println("Failed to get claims", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	claims := []models.Claim{}
+	for rows.Next() {
+		var c models.Claim
+		if err := scanClaim(rows, &c); err != nil {
+			log.Println("Failed to scan claim", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		claims = append(claims, c)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Claims retrieved successfully",
+		"data":    claims,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func HandleGetClaimByID(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, ok := parseClaimID(ctx)
+	if !ok {
+		return
+	}
+
+	c, err := getClaim(id)
+	if err != nil {
+		log.Println("Failed to get claim", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Claim not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !isStaff(user) && uint(c.ClaimantID) != user.ID {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to access this claim",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Claim retrieved successfully",
+		"data":    c,
+	})
+}
+
+func HandleAddClaimEvidence(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, ok := parseClaimID(ctx)
+	if !ok {
+		return
+	}
+
+	var body AddClaimEvidenceDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	var c models.Claim
+	err = db.DB.QueryRow(`SELECT id, claimant_id, status FROM claims WHERE id = $1`, id).Scan(&c.ID, &c.ClaimantID, &c.Status)
+	if err != nil {
+		log.Println("Failed to get claim", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Claim not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !isStaff(user) && uint(c.ClaimantID) != user.ID {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to access this claim",
+		})
+		return
+	}
+
+	if c.Status != models.ClaimStatusOpen {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Evidence can only be attached to open claims",
+		})
+		return
+	}
+
+	var evidence models.ClaimEvidence
+	sqlCreateEvidence := `
+	INSERT INTO claim_evidences (claim_id, url, "desc", uploaded_by)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, claim_id, url, "desc", uploaded_by, created_at
+	`
+	err = db.DB.QueryRow(sqlCreateEvidence, id, body.URL, body.Desc, user.ID).Scan(
+		&evidence.ID,
+		&evidence.ClaimID,
+		&evidence.URL,
+		&evidence.Desc,
+		&evidence.UploadedBy,
+		&evidence.CreatedAt,
+	)
+	if err != nil {
+		log.Println("Failed attaching claim evidence", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Evidence attached successfully",
+		"data":    evidence,
+	})
+}
+
+func HandleApproveClaim(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, ok := parseClaimID(ctx)
+	if !ok {
+		return
+	}
+
+	var body ApproveClaimDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	// Approval can't exceed what was claimed, and the claim itself is capped at the declared value
+	sqlApproveClaim := `
+	UPDATE claims
+	SET status = $2, approved_amount = $3, resolution_note = $4, reviewed_by = $5, reviewed_at = NOW(), updated_at = NOW()
+	WHERE id = $1 AND status = $6 AND claimed_amount >= $3
+	RETURNING ` + claimColumns
+
+	var c models.Claim
+	err = scanClaim(db.DB.QueryRow(sqlApproveClaim, id, models.ClaimStatusApproved, body.ApprovedAmount, body.Note, user.ID, models.ClaimStatusOpen), &c)
+	if err != nil {
+		log.Println("Failed approving claim", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Only open claims can be approved, for at most the claimed amount",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Claim approved successfully",
+		"data":    c,
+	})
+}
+
+func HandleRejectClaim(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, ok := parseClaimID(ctx)
+	if !ok {
+		return
+	}
+
+	var body RejectClaimDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	sqlRejectClaim := `
+	UPDATE claims
+	SET status = $2, resolution_note = $3, reviewed_by = $4, reviewed_at = NOW(), updated_at = NOW()
+	WHERE id = $1 AND status = $5
+	RETURNING ` + claimColumns
+
+	var c models.Claim
+	err = scanClaim(db.DB.QueryRow(sqlRejectClaim, id, models.ClaimStatusRejected, body.Note, user.ID, models.ClaimStatusOpen), &c)
+	if err != nil {
+		log.Println("Failed rejecting claim", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Only open claims can be rejected",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Claim rejected successfully",
+		"data":    c,
+	})
+}
+
+func HandlePayoutClaim(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, ok := parseClaimID(ctx)
+	if !ok {
+		return
+	}
+
+	var body PayoutClaimDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	if body.Method == models.ClaimPayoutBankTransfer && body.Reference == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Validation failed",
+			"errors": gin.H{
+				"reference": "Reference is required for bank transfers",
+			},
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var c models.Claim
+	err = tx.QueryRow(`SELECT id, claimant_id, approved_amount, status FROM claims WHERE id = $1 FOR UPDATE`, id).Scan(
+		&c.ID,
+		&c.ClaimantID,
+		&c.ApprovedAmount,
+		&c.Status,
+	)
+	if err != nil {
+		log.Println("Failed to get claim for payout", err)
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Claim not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if c.Status != models.ClaimStatusApproved || c.ApprovedAmount == nil {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only approved claims can be paid out",
+		})
+		return
+	}
+
+	reference := body.Reference
+	var ledgerTxID *int
+	if body.Method == models.ClaimPayoutWallet {
+		reference = fmt.Sprintf("CLAIM-%d", c.ID)
+
+		insuranceAccountID, err := wallet.GetOrCreateSystemAccountID(tx, wallet.AccountCodeInsuranceClaims, models.LedgerAccountInsuranceClaims)
+		if err != nil {
+			log.Println("Failed to get insurance claims account", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		claimantAccount, err := wallet.GetOrCreateUserAccount(tx, uint(c.ClaimantID))
+		if err != nil {
+			log.Println("Failed to get claimant wallet", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		ledgerTx, err := wallet.Transfer(
+			tx,
+			wallet.TransactionTypeClaimPayout,
+			reference,
+			fmt.Sprintf("Insurance claim #%d payout", c.ID),
+			insuranceAccountID,
+			claimantAccount.ID,
+			*c.ApprovedAmount,
+		)
+		if err != nil {
+			log.Println("Failed crediting claimant wallet", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		ledgerTxID = &ledgerTx.ID
+	}
+
+	var payout models.ClaimPayout
+	sqlCreatePayout := `
+	INSERT INTO claim_payouts (claim_id, amount, method, reference, ledger_transaction_id, paid_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, claim_id, amount, method, reference, ledger_transaction_id, paid_by, paid_at
+	`
+	err = tx.QueryRow(sqlCreatePayout, c.ID, *c.ApprovedAmount, body.Method, reference, ledgerTxID, user.ID).Scan(
+		&payout.ID,
+		&payout.ClaimID,
+		&payout.Amount,
+		&payout.Method,
+		&payout.Reference,
+		&payout.LedgerTransactionID,
+		&payout.PaidBy,
+		&payout.PaidAt,
+	)
+	if err != nil {
+		log.Println("Failed recording claim payout", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE claims SET status = $2, updated_at = NOW() WHERE id = $1`, c.ID, models.ClaimStatusPaid)
+	if err != nil {
+		log.Println("Failed updating claim status", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Claim paid out successfully",
+		"data":    payout,
+	})
+}
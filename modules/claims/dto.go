@@ -0,0 +1,27 @@
+package claims
+
+type CreateClaimDto struct {
+	ShipmentID    int    `json:"shipment_id" binding:"required"`
+	Type          string `json:"type" binding:"required,oneof=LOST DAMAGED"`
+	Desc          string `json:"desc" binding:"required"`
+	ClaimedAmount int    `json:"claimed_amount" binding:"required,gt=0"` // in IDR
+}
+
+type AddClaimEvidenceDto struct {
+	URL  string  `json:"url" binding:"required,url"`
+	Desc *string `json:"desc"`
+}
+
+type ApproveClaimDto struct {
+	ApprovedAmount int     `json:"approved_amount" binding:"required,gt=0"`
+	Note           *string `json:"note"`
+}
+
+type RejectClaimDto struct {
+	Note string `json:"note" binding:"required"`
+}
+
+type PayoutClaimDto struct {
+	Method    string `json:"method" binding:"required,oneof=WALLET BANK_TRANSFER"`
+	Reference string `json:"reference"` // bank transfer reference, required for BANK_TRANSFER
+}
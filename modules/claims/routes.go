@@ -0,0 +1,17 @@
+package claims
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.POST("/", middlewares.JwtAuthMiddleware(), HandleCreateClaim)
+	rg.GET("/", middlewares.JwtAuthMiddleware(), HandleGetClaimsList)
+	rg.GET("/:id", middlewares.JwtAuthMiddleware(), HandleGetClaimByID)
+	rg.POST("/:id/evidences", middlewares.JwtAuthMiddleware(), HandleAddClaimEvidence)
+	rg.POST("/:id/approve", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleApproveClaim)
+	rg.POST("/:id/reject", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleRejectClaim)
+	rg.POST("/:id/payout", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandlePayoutClaim)
+}
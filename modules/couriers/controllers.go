@@ -0,0 +1,1194 @@
+package couriers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/dispatch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/googlemap"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/location"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/realtime"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/routing"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+	"googlemaps.github.io/maps"
+)
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func bindBody(ctx *gin.Context, body any) bool {
+	return checkBind(ctx, ctx.ShouldBind(body))
+}
+
+// bindOptionalBody is bindBody for requests whose fields all have defaults, an empty body is accepted
+func bindOptionalBody(ctx *gin.Context, body any) bool {
+	err := ctx.ShouldBind(body)
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	return checkBind(ctx, err)
+}
+
+func checkBind(ctx *gin.Context, err error) bool {
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return false
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return false
+	}
+
+	return true
+}
+
+// parseTaskStatuses reads the comma separated 'status' query, falling back to the given statuses
+func parseTaskStatuses(ctx *gin.Context, fallback []string) ([]string, bool) {
+	s := ctx.Query("status")
+	if s == "" {
+		return fallback, true
+	}
+
+	var statuses []string
+	for _, status := range strings.Split(s, ",") {
+		switch status {
+		case models.TaskStatusUnassigned, models.TaskStatusAssigned, models.TaskStatusAccepted, models.TaskStatusCompleted, models.TaskStatusCancelled:
+			statuses = append(statuses, status)
+		default:
+			return nil, false
+		}
+	}
+	return statuses, true
+}
+
+// loadTaskShipments fills in what the courier needs to know about the shipment of every task
+func loadTaskShipments(q querier, tasks []models.CourierTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.ShipmentID
+	}
+
+	sqlGetShipments := `
+	SELECT id, tracking_number, status, service_type, sender_name, sender_phone, sender_address, recipient_name, recipient_phone, recipient_address, item_name, item_weight, pickup_window_start, pickup_window_end, delivery_window_start, delivery_window_end, delivery_instructions
+	FROM shipments
+	WHERE id = ANY($1)
+	`
+	rows, err := q.Query(sqlGetShipments, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type contact struct{ name, phone, address string }
+	type shipmentRow struct {
+		models.CourierTaskShipment
+		sender, recipient contact
+	}
+	shipments := map[int]shipmentRow{}
+	for rows.Next() {
+		var id int
+		var s shipmentRow
+		err := rows.Scan(
+			&id,
+			&s.TrackingNumber,
+			&s.Status,
+			&s.ServiceType,
+			&s.sender.name,
+			&s.sender.phone,
+			&s.sender.address,
+			&s.recipient.name,
+			&s.recipient.phone,
+			&s.recipient.address,
+			&s.ItemName,
+			&s.ItemWeight,
+			&s.PickupWindowStart,
+			&s.PickupWindowEnd,
+			&s.DeliveryWindowStart,
+			&s.DeliveryWindowEnd,
+			&s.Instructions,
+		)
+		if err != nil {
+			return err
+		}
+		shipments[id] = s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range tasks {
+		s, ok := shipments[tasks[i].ShipmentID]
+		if !ok {
+			continue
+		}
+		shipment := s.CourierTaskShipment
+		c := s.recipient
+		if tasks[i].Type == models.TaskTypePickup {
+			c = s.sender
+			shipment.DeliveryWindowStart, shipment.DeliveryWindowEnd, shipment.Instructions = nil, nil, nil
+		} else {
+			shipment.PickupWindowStart, shipment.PickupWindowEnd = nil, nil
+		}
+		shipment.ContactName, shipment.ContactPhone, shipment.Address = c.name, c.phone, c.address
+		tasks[i].Shipment = &shipment
+	}
+
+	return nil
+}
+
+// HandleGetMyTasks is the courier's work queue, open tasks by default in the order of the optimized run,
+// then by the earliest pickup or delivery window the customer chose, then oldest first
+func HandleGetMyTasks(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	statuses, ok := parseTaskStatuses(ctx, []string{models.TaskStatusAssigned, models.TaskStatusAccepted})
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid 'status'",
+		})
+		return
+	}
+
+	sqlGetTasks := `
+	SELECT ` + dispatch.TaskColumns + `
+	FROM courier_tasks
+	WHERE courier_id = $1 AND status::TEXT = ANY($2)
+	ORDER BY stop_sequence ASC NULLS LAST,
+		(
+			SELECT CASE WHEN courier_tasks.type = 'PICKUP' THEN s.pickup_window_start ELSE s.delivery_window_start END
+			FROM shipments s WHERE s.id = courier_tasks.shipment_id
+		) ASC NULLS LAST,
+		created_at ASC, id ASC
+	LIMIT $3 OFFSET $4
+	`
+	offset := (page - 1) * pageSize
+	rows, err := db.DB.Query(sqlGetTasks, user.ID, pq.Array(statuses), pageSize, offset)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving tasks",
+		})
+		return
+	}
+
+	tasks := []models.CourierTask{}
+	for rows.Next() {
+		var t models.CourierTask
+		if err := dispatch.ScanTask(rows, &t); err != nil {
+			rows.Close()
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving tasks",
+			})
+			return
+		}
+		tasks = append(tasks, t)
+	}
+	rows.Close()
+
+	err = loadTaskShipments(db.DB, tasks)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving tasks",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving tasks",
+		"data":    tasks,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// lockCourierTask loads the task for the courier acting on it, writing the error response itself when it fails
+func lockCourierTask(ctx *gin.Context, tx *sql.Tx, courierID uint) (models.CourierTask, bool) {
+	var task models.CourierTask
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid task ID",
+		})
+		return task, false
+	}
+
+	err = dispatch.ScanTask(tx.QueryRow(`SELECT `+dispatch.TaskColumns+` FROM courier_tasks WHERE id = $1 FOR UPDATE`, id), &task)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Task not found",
+			})
+			return task, false
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return task, false
+	}
+
+	if task.CourierID == nil || *task.CourierID != int(courierID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "This task is not assigned to you",
+		})
+		return task, false
+	}
+
+	return task, true
+}
+
+func HandleAcceptTask(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	task, ok := lockCourierTask(ctx, tx, user.ID)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	if task.Status != models.TaskStatusAssigned {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("Task is %s and cannot be accepted", task.Status),
+		})
+		return
+	}
+
+	sqlAcceptTask := `
+	UPDATE courier_tasks SET status = $2, accepted_at = NOW(), updated_at = NOW()
+	WHERE id = $1
+	RETURNING ` + dispatch.TaskColumns
+	err = dispatch.ScanTask(tx.QueryRow(sqlAcceptTask, task.ID, models.TaskStatusAccepted), &task)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed accepting task",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Task accepted",
+		"data":    task,
+	})
+}
+
+// HandleDeclineTask hands the task back, the auto-assigner immediately offers it to another courier of the branch
+func HandleDeclineTask(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body DeclineTaskDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	task, ok := lockCourierTask(ctx, tx, user.ID)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	if task.Status != models.TaskStatusAssigned && task.Status != models.TaskStatusAccepted {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("Task is %s and cannot be declined", task.Status),
+		})
+		return
+	}
+
+	sqlDecline := `
+	INSERT INTO courier_task_declines (task_id, courier_id, reason)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (task_id, courier_id) DO UPDATE SET reason = EXCLUDED.reason, created_at = NOW()
+	`
+	_, err = tx.Exec(sqlDecline, task.ID, user.ID, body.Reason)
+	if err == nil {
+		sqlUnassign := `
+		UPDATE courier_tasks
+		SET status = $2, courier_id = NULL, assigned_by = NULL, assigned_at = NULL, accepted_at = NULL,
+			stop_sequence = NULL, estimated_arrival_at = NULL, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + dispatch.TaskColumns
+		err = dispatch.ScanTask(tx.QueryRow(sqlUnassign, task.ID, models.TaskStatusUnassigned), &task)
+	}
+	if err == nil {
+		err = dispatch.AutoAssign(tx, &task)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed declining task", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed declining task",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Task declined",
+	})
+}
+
+func HandleGetTasksList(ctx *gin.Context) {
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	statuses, ok := parseTaskStatuses(ctx, nil)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid 'status'",
+		})
+		return
+	}
+
+	var taskType *string
+	if t := ctx.Query("type"); t != "" {
+		if t != models.TaskTypePickup && t != models.TaskTypeDelivery {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'type'",
+			})
+			return
+		}
+		taskType = &t
+	}
+
+	var branchID, courierID *int
+	if strBranchID := ctx.Query("branch_id"); strBranchID != "" {
+		id, err := strconv.Atoi(strBranchID)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'branch_id'",
+			})
+			return
+		}
+		branchID = &id
+	}
+	if strCourierID := ctx.Query("courier_id"); strCourierID != "" {
+		id, err := strconv.Atoi(strCourierID)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'courier_id'",
+			})
+			return
+		}
+		courierID = &id
+	}
+
+	var statusFilter, scopeFilter any
+	if statuses != nil {
+		statusFilter = pq.Array(statuses)
+	}
+	if ids, scoped := middlewares.BranchScope(ctx); scoped {
+		scopeFilter = pq.Array(ids)
+	}
+
+	sqlGetTasks := `
+	SELECT ` + dispatch.TaskColumns + `
+	FROM courier_tasks
+	WHERE ($1::TEXT[] IS NULL OR status::TEXT = ANY($1))
+		AND ($2::TEXT IS NULL OR type::TEXT = $2)
+		AND ($3::INT IS NULL OR branch_id = $3)
+		AND ($4::INT IS NULL OR courier_id = $4)
+		AND ($5::INT[] IS NULL OR branch_id = ANY($5))
+	ORDER BY created_at DESC, id DESC
+	LIMIT $6 OFFSET $7
+	`
+	offset := (page - 1) * pageSize
+	rows, err := db.DB.Query(sqlGetTasks, statusFilter, taskType, branchID, courierID, scopeFilter, pageSize, offset)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving tasks",
+		})
+		return
+	}
+
+	tasks := []models.CourierTask{}
+	for rows.Next() {
+		var t models.CourierTask
+		if err := dispatch.ScanTask(rows, &t); err != nil {
+			rows.Close()
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving tasks",
+			})
+			return
+		}
+		tasks = append(tasks, t)
+	}
+	rows.Close()
+
+	err = loadTaskShipments(db.DB, tasks)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving tasks",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving tasks",
+		"data":    tasks,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// checkCourier makes sure the user exists and is a courier working out of branchID, writing the error response itself when it is not
+func checkCourier(ctx *gin.Context, tx *sql.Tx, courierID int, branchID *int) bool {
+	var role string
+	var isStaff bool
+	err := tx.QueryRow(
+		`SELECT role, EXISTS (SELECT 1 FROM branch_staff WHERE user_id = users.id AND branch_id = $2) FROM users WHERE id = $1`,
+		courierID, branchID,
+	).Scan(&role, &isStaff)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return false
+	}
+	if err != nil || role != roles.RoleCourier {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Courier not found",
+		})
+		return false
+	}
+	if !isStaff {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Courier does not work at the task's branch",
+		})
+		return false
+	}
+	return true
+}
+
+// HandleCreateTask opens a task by hand, for shipments the automatic dispatch could not place (e.g. without a destination branch)
+func HandleCreateTask(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body CreateTaskDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var shipment models.Shipment
+	err = tx.QueryRow(
+		`SELECT id, status, pickup_method, origin_branch_id, destination_branch_id, current_branch_id FROM shipments WHERE id = $1 FOR UPDATE`,
+		body.ShipmentID,
+	).Scan(&shipment.ID, &shipment.Status, &shipment.PickupMethod, &shipment.OriginBranchID, &shipment.DestinationBranchID, &shipment.CurrentBranchID)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	branchID := body.BranchID
+	switch body.Type {
+	case models.TaskTypePickup:
+		if shipment.Status != models.StatusReadyToPickup || shipment.PickupMethod != models.PickupMethodCourier {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Only shipments waiting for a courier pickup can get a pickup task",
+			})
+			return
+		}
+		if branchID == nil {
+			branchID = shipment.OriginBranchID
+		}
+	case models.TaskTypeDelivery:
+		if shipment.Status != models.StatusInTransit {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Only shipments in transit can get a delivery task",
+			})
+			return
+		}
+		// Couriers deliver from the branch closest to the recipient, once the package has arrived there
+		if shipment.CurrentBranchID == nil || shipment.DestinationBranchID == nil || *shipment.CurrentBranchID != *shipment.DestinationBranchID {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Only shipments at their destination branch can get a delivery task",
+			})
+			return
+		}
+		if branchID != nil && *branchID != *shipment.CurrentBranchID {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Delivery tasks are dispatched from the branch holding the package",
+			})
+			return
+		}
+		branchID = shipment.CurrentBranchID
+	}
+
+	if _, scoped := middlewares.BranchScope(ctx); scoped && (branchID == nil || !middlewares.CanAccessBranch(ctx, *branchID)) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only dispatch couriers at the branches you are assigned to",
+		})
+		return
+	}
+
+	var hasOpenTask bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM courier_tasks WHERE shipment_id = $1 AND type = $2 AND status::TEXT = ANY($3))`,
+		shipment.ID, body.Type, pq.Array(dispatch.OpenStatuses),
+	).Scan(&hasOpenTask)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if hasOpenTask {
+		tx.Rollback()
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": fmt.Sprintf("Shipment already has an open %s task", body.Type),
+		})
+		return
+	}
+
+	if body.CourierID != nil && !checkCourier(ctx, tx, *body.CourierID, branchID) {
+		tx.Rollback()
+		return
+	}
+
+	task, err := dispatch.CreateTask(tx, shipment.ID, body.Type, branchID)
+	if err == nil && body.CourierID != nil {
+		assignedBy := int(user.ID)
+		err = dispatch.Assign(tx, &task, *body.CourierID, &assignedBy)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed creating courier task", err)
+		if strings.Contains(err.Error(), "foreign key constraint") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed creating task",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Task created successfully",
+		"data":    task,
+	})
+}
+
+func HandleAssignTask(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid task ID",
+		})
+		return
+	}
+
+	var body AssignTaskDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var task models.CourierTask
+	err = dispatch.ScanTask(tx.QueryRow(`SELECT `+dispatch.TaskColumns+` FROM courier_tasks WHERE id = $1 FOR UPDATE`, id), &task)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Task not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if _, scoped := middlewares.BranchScope(ctx); scoped && (task.BranchID == nil || !middlewares.CanAccessBranch(ctx, *task.BranchID)) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only dispatch couriers at the branches you are assigned to",
+		})
+		return
+	}
+
+	if task.Status == models.TaskStatusCompleted || task.Status == models.TaskStatusCancelled {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("Task is already %s", task.Status),
+		})
+		return
+	}
+
+	if body.CourierID != nil {
+		if !checkCourier(ctx, tx, *body.CourierID, task.BranchID) {
+			tx.Rollback()
+			return
+		}
+		assignedBy := int(user.ID)
+		err = dispatch.Assign(tx, &task, *body.CourierID, &assignedBy)
+	} else {
+		err = dispatch.AutoAssign(tx, &task)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed assigning courier task", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed assigning task",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	message := "Task assigned successfully"
+	if task.Status == models.TaskStatusUnassigned {
+		message = "No courier is available at the task's branch, the task stays unassigned"
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"data":    task,
+	})
+}
+
+// Time spent handing over the package at every stop, added to the arrival estimates of the following stops
+const stopServiceTime = 5 * time.Minute
+
+// Pings older than this no longer tell where the courier is, the run starts from the last completed stop instead
+const startPositionMaxAge = 30 * time.Minute
+
+type plannedDelivery struct {
+	taskID      int
+	branchID    *int
+	shipmentID  int
+	windowStart *time.Time
+	stop        models.RouteStop
+	located     bool
+}
+
+// HandleOptimizeMyRoute orders the courier's open deliveries of the day into the quickest run.
+// The run starts from start_branch_id when given, otherwise from the courier's latest GPS position,
+// the last delivery they completed today or the branch most of the deliveries are dispatched from, in that order.
+// It is called again whenever deliveries are added to or completed from the run to refresh the order and the estimates.
+func HandleOptimizeMyRoute(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body OptimizeRouteDto
+	if !bindOptionalBody(ctx, &body) {
+		return
+	}
+
+	sqlGetDeliveries := `
+	SELECT t.id, t.branch_id, s.id, s.tracking_number, s.recipient_name, s.recipient_phone, s.recipient_address, s.recipient_latitude, s.recipient_longitude, s.delivery_window_start
+	FROM courier_tasks t
+	JOIN shipments s ON s.id = t.shipment_id
+	WHERE t.courier_id = $1 AND t.type = $2 AND t.status IN ($3, $4)
+	ORDER BY t.created_at ASC, t.id ASC
+	`
+	rows, err := db.DB.Query(sqlGetDeliveries, user.ID, models.TaskTypeDelivery, models.TaskStatusAssigned, models.TaskStatusAccepted)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed optimizing route",
+		})
+		return
+	}
+
+	var deliveries []plannedDelivery
+	branchCounts := map[int]int{}
+	for rows.Next() {
+		var d plannedDelivery
+		var lat, lng *float64
+		err := rows.Scan(&d.taskID, &d.branchID, &d.shipmentID, &d.stop.TrackingNumber, &d.stop.RecipientName, &d.stop.RecipientPhone, &d.stop.Address, &lat, &lng, &d.windowStart)
+		if err != nil {
+			rows.Close()
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed optimizing route",
+			})
+			return
+		}
+		d.stop.TaskID, d.stop.ShipmentID = d.taskID, d.shipmentID
+		if lat != nil && lng != nil {
+			d.stop.Latitude, d.stop.Longitude, d.located = *lat, *lng, true
+		}
+		if d.branchID != nil {
+			branchCounts[*d.branchID]++
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+
+	if len(deliveries) == 0 {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "You have no deliveries left",
+			"data":    models.RoutePlan{StartedAt: time.Now(), Stops: []models.RouteStop{}, Unlocated: []int{}, Deferred: []int{}},
+		})
+		return
+	}
+
+	startBranchID := body.StartBranchID
+	if startBranchID == nil {
+		for id, count := range branchCounts {
+			if startBranchID == nil || count > branchCounts[*startBranchID] || (count == branchCounts[*startBranchID] && id < *startBranchID) {
+				startBranchID = &id
+			}
+		}
+	}
+	if startBranchID == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "'start_branch_id' is required",
+		})
+		return
+	}
+
+	var startBranch models.Branch
+	err = branch.Scan(db.DB.QueryRow(`SELECT `+branch.Columns+` FROM branches WHERE id = $1 AND deleted_at IS NULL`, *startBranchID), &startBranch)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Starting branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed optimizing route",
+		})
+		return
+	}
+
+	// The day follows the branch's clock, timestamps are stored without a zone, in UTC like NOW()
+	now := time.Now()
+	today := now.In(branch.Location(startBranch))
+	dayStart := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	plan := models.RoutePlan{StartBranchID: *startBranchID, StartedAt: now, Stops: []models.RouteStop{}, Unlocated: []int{}, Deferred: []int{}}
+
+	var start *maps.LatLng
+	if body.StartBranchID == nil {
+		last, err := location.LastKnown(db.DB, int(user.ID))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed optimizing route",
+			})
+			return
+		}
+		if err == nil && now.Sub(last.RecordedAt) <= startPositionMaxAge {
+			start, plan.Start = &maps.LatLng{Lat: last.Latitude, Lng: last.Longitude}, models.RouteStartPosition
+		}
+	}
+	if start == nil && body.StartBranchID == nil {
+		var lastStop maps.LatLng
+		err = db.DB.QueryRow(`
+		SELECT s.recipient_latitude, s.recipient_longitude
+		FROM courier_tasks t
+		JOIN shipments s ON s.id = t.shipment_id
+		WHERE t.courier_id = $1 AND t.type = $2 AND t.status = $3 AND t.completed_at >= $4
+			AND s.recipient_latitude IS NOT NULL AND s.recipient_longitude IS NOT NULL
+		ORDER BY t.completed_at DESC
+		LIMIT 1
+		`, user.ID, models.TaskTypeDelivery, models.TaskStatusCompleted, dayStart.UTC()).Scan(&lastStop.Lat, &lastStop.Lng)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed optimizing route",
+			})
+			return
+		}
+		if err == nil {
+			start, plan.Start = &lastStop, models.RouteStartLastStop
+		}
+	}
+	if start == nil {
+		if startBranch.Latitude == nil || startBranch.Longitude == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Starting branch has no coordinates",
+			})
+			return
+		}
+		start, plan.Start = &maps.LatLng{Lat: *startBranch.Latitude, Lng: *startBranch.Longitude}, models.RouteStartBranch
+	}
+	plan.StartLatitude, plan.StartLongitude = start.Lat, start.Lng
+
+	points := []maps.LatLng{*start}
+	var located []plannedDelivery
+	for _, d := range deliveries {
+		// Recipients who asked for a later day are left for that day's run
+		if d.windowStart != nil && !d.windowStart.Before(dayEnd) {
+			plan.Deferred = append(plan.Deferred, d.taskID)
+			continue
+		}
+		if !d.located {
+			location, err := googlemap.Geocode(d.stop.Address)
+			if err != nil {
+				log.Printf("Failed geocoding the recipient of shipment %d: %v\n", d.shipmentID, err)
+				plan.Unlocated = append(plan.Unlocated, d.taskID)
+				continue
+			}
+			d.stop.Latitude, d.stop.Longitude = location.Lat, location.Lng
+			_, err = db.DB.Exec(`UPDATE shipments SET recipient_latitude = $2, recipient_longitude = $3 WHERE id = $1`, d.shipmentID, location.Lat, location.Lng)
+			if err != nil {
+				log.Println("Failed saving recipient coordinates", err)
+			}
+		}
+		points = append(points, maps.LatLng{Lat: d.stop.Latitude, Lng: d.stop.Longitude})
+		located = append(located, d)
+	}
+
+	if len(located) > 0 {
+		matrix := routing.BuildMatrix(points)
+		plan.Source = matrix.Source
+
+		previous := 0
+		arrival := plan.StartedAt
+		for i, p := range routing.Optimize(matrix) {
+			if i > 0 {
+				arrival = arrival.Add(stopServiceTime)
+			}
+			arrival = arrival.Add(matrix.Durations[previous][p])
+
+			stop := located[p-1].stop
+			stop.Sequence = i + 1
+			stop.DistanceFromPrevious = matrix.Distances[previous][p]
+			stop.EstimatedArrivalAt = arrival
+			plan.Stops = append(plan.Stops, stop)
+			plan.TotalDistance += stop.DistanceFromPrevious
+			previous = p
+		}
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed optimizing route",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	// Deliveries completed or reassigned in the meantime are simply not updated
+	_, err = tx.Exec(
+		`UPDATE courier_tasks SET stop_sequence = NULL, estimated_arrival_at = NULL WHERE courier_id = $1 AND type = $2 AND status IN ($3, $4)`,
+		user.ID, models.TaskTypeDelivery, models.TaskStatusAssigned, models.TaskStatusAccepted,
+	)
+	for _, stop := range plan.Stops {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(
+			`UPDATE courier_tasks SET stop_sequence = $3, estimated_arrival_at = $4, updated_at = NOW() WHERE id = $1 AND courier_id = $2 AND status IN ($5, $6)`,
+			stop.TaskID, user.ID, stop.Sequence, stop.EstimatedArrivalAt, models.TaskStatusAssigned, models.TaskStatusAccepted,
+		)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed saving optimized route", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed optimizing route",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed optimizing route",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Route optimized with %d stops", len(plan.Stops)),
+		"data":    plan,
+	})
+}
+
+// HandlePostMyLocations stores a batch of GPS pings from the courier's device.
+// Pings too old to be kept or too far in the future are skipped rather than failing the whole batch.
+func HandlePostMyLocations(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body PostLocationsDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	now := time.Now()
+	var pings []models.CourierLocation
+	for _, p := range body.Pings {
+		if !location.Accepts(p.RecordedAt, now) {
+			continue
+		}
+		pings = append(pings, models.CourierLocation{
+			CourierID:  int(user.ID),
+			Latitude:   *p.Latitude,
+			Longitude:  *p.Longitude,
+			Accuracy:   p.Accuracy,
+			RecordedAt: p.RecordedAt.UTC(),
+		})
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed saving locations",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	err = location.Record(tx, int(user.ID), pings)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed saving courier locations", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed saving locations",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed saving locations",
+		})
+		return
+	}
+
+	if len(pings) > 0 {
+		publishDeliveryPositions(int(user.ID))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("%d location(s) saved", len(pings)),
+		"data": gin.H{
+			"accepted": len(pings),
+			"rejected": len(body.Pings) - len(pings),
+		},
+	})
+}
+
+// publishDeliveryPositions pushes the courier's newest position to the trackers of the shipments they are out delivering
+func publishDeliveryPositions(courierID int) {
+	last, err := location.LastKnown(db.DB, courierID)
+	if err != nil {
+		log.Println("Failed to get courier position to publish", err)
+		return
+	}
+
+	positions, err := location.DeliveryPositions(courierID, last)
+	if err != nil {
+		log.Println("Failed to get courier deliveries to publish", err)
+		return
+	}
+	for trackingNumber, position := range positions {
+		realtime.PublishCourierLocation(trackingNumber, position)
+	}
+}
@@ -0,0 +1,35 @@
+package couriers
+
+import "time"
+
+type CreateTaskDto struct {
+	ShipmentID int    `json:"shipment_id" binding:"required"`
+	Type       string `json:"type" binding:"required,oneof=PICKUP DELIVERY"`
+	BranchID   *int   `json:"branch_id"`  // defaults to the origin branch for pickups, deliveries always leave from the destination branch holding the package
+	CourierID  *int   `json:"courier_id"` // assigned automatically when omitted
+}
+
+// Omitting courier_id lets the auto-assigner pick the least busy courier of the task's branch
+type AssignTaskDto struct {
+	CourierID *int `json:"courier_id"`
+}
+
+type DeclineTaskDto struct {
+	Reason *string `json:"reason"`
+}
+
+type OptimizeRouteDto struct {
+	StartBranchID *int `json:"start_branch_id"` // starts the run at the branch, by default it starts where the courier is and the branch is the one most of the deliveries are dispatched from
+}
+
+type LocationPingDto struct {
+	Latitude   *float64  `json:"latitude" binding:"required,gte=-90,lte=90"`
+	Longitude  *float64  `json:"longitude" binding:"required,gte=-180,lte=180"`
+	Accuracy   *float64  `json:"accuracy" binding:"omitempty,gte=0"` // in meters
+	RecordedAt time.Time `json:"recorded_at" binding:"required"`
+}
+
+// Devices buffer pings while offline and send them in batches
+type PostLocationsDto struct {
+	Pings []LocationPingDto `json:"pings" binding:"required,min=1,max=500,dive"`
+}
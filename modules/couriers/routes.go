@@ -0,0 +1,19 @@
+package couriers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.GET("/me/tasks", middlewares.JwtAuthMiddleware(roles.RoleCourier), HandleGetMyTasks)
+	rg.POST("/me/route", middlewares.JwtAuthMiddleware(roles.RoleCourier), HandleOptimizeMyRoute)
+	rg.POST("/me/locations", middlewares.JwtAuthMiddleware(roles.RoleCourier), HandlePostMyLocations)
+	rg.POST("/tasks/:id/accept", middlewares.JwtAuthMiddleware(roles.RoleCourier), HandleAcceptTask)
+	rg.POST("/tasks/:id/decline", middlewares.JwtAuthMiddleware(roles.RoleCourier), HandleDeclineTask)
+
+	rg.GET("/tasks", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleGetTasksList)
+	rg.POST("/tasks", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleCreateTask)
+	rg.POST("/tasks/:id/assign", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleAssignTask)
+}
@@ -0,0 +1,524 @@
+package exceptions
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/exception"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/realtime"
+)
+
+func bindBody(ctx *gin.Context, body any) bool {
+	err := ctx.ShouldBind(body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return false
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return false
+	}
+
+	return true
+}
+
+func parseExceptionID(ctx *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid exception ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+const sqlGetShipment = `
+SELECT
+	id,
+	tracking_number,
+	sender_name,
+	sender_phone,
+	sender_address,
+	recipient_name,
+	recipient_phone,
+	recipient_address,
+	origin_branch_id,
+	destination_branch_id,
+	current_branch_id,
+	current_courier_id,
+	is_returned_to_sender,
+	status
+FROM shipments
+`
+
+// lockShipment loads and locks the shipment matching the condition, the handler answers the request itself on failure
+func lockShipment(ctx *gin.Context, tx *sql.Tx, condition string, arg any) (models.Shipment, bool) {
+	var s models.Shipment
+	err := tx.QueryRow(sqlGetShipment+condition+` FOR UPDATE`, arg).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.SenderName,
+		&s.SenderPhone,
+		&s.SenderAddress,
+		&s.RecipientName,
+		&s.RecipientPhone,
+		&s.RecipientAddress,
+		&s.OriginBranchID,
+		&s.DestinationBranchID,
+		&s.CurrentBranchID,
+		&s.CurrentCourierID,
+		&s.IsReturnedToSender,
+		&s.Status,
+	)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get shipment for exception", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return s, false
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return s, false
+	}
+	return s, true
+}
+
+func recordHistory(tx *sql.Tx, shipmentID int, status, desc string, branchID int) (models.ShipmentHistory, error) {
+	var h models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", branch_id)
+	VALUES ($1, $2, $3, $4)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+	err := tx.QueryRow(sqlInitHistory, shipmentID, status, desc, branchID).Scan(
+		&h.ID,
+		&h.ShipmentID,
+		&h.Status,
+		&h.Desc,
+		&h.CourierID,
+		&h.BranchID,
+		&h.Timestamp,
+	)
+	return h, err
+}
+
+func HandleRaiseException(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body RaiseExceptionDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, body.BranchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not assigned to this branch",
+		})
+		return
+	}
+
+	var heldAt models.Branch
+	err = db.DB.QueryRow(`SELECT id, name, address FROM branches WHERE id = $1 AND deleted_at IS NULL`, body.BranchID).Scan(&heldAt.ID, &heldAt.Name, &heldAt.Address)
+	if err != nil {
+		log.Println("Failed to get branch for exception", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	s, ok := lockShipment(ctx, tx, `WHERE tracking_number = $1`, strings.TrimSpace(body.TrackingNumber))
+	if !ok {
+		return
+	}
+
+	e, err := exception.Raise(tx, s, body.BranchID, int(user.ID), body.Type, body.Desc)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed raising exception", err)
+		if errors.Is(err, exception.ErrRejected) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if strings.Contains(err.Error(), "unique constraint") {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "Shipment already has an open exception",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	desc := fmt.Sprintf(
+		"%s has put the package on hold at branch %s [%d | %s] for a %s exception: %s. Shipment currently is %s",
+		user.Username, heldAt.Name, heldAt.ID, heldAt.Address, e.Type, e.Desc, models.StatusOnHold,
+	)
+	history, err := recordHistory(tx, s.ID, models.StatusOnHold, desc, body.BranchID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to record exception history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(history.ID)
+
+	s.Status = models.StatusOnHold
+	e.Shipment = &s
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Exception raised successfully",
+		"data":    e,
+	})
+}
+
+// HandleGetExceptionsList is the queue of exceptions waiting for a decision, oldest first.
+func HandleGetExceptionsList(ctx *gin.Context) {
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	status := ctx.DefaultQuery("status", models.ExceptionStatusOpen)
+	if status != models.ExceptionStatusOpen && status != models.ExceptionStatusResolved {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid 'status'",
+		})
+		return
+	}
+
+	var typeFilter *string
+	if t := ctx.Query("type"); t != "" {
+		typeFilter = &t
+	}
+
+	// Branch admins only see the exceptions raised at their branches
+	var branchFilter []int64
+	branchIDs, scoped := middlewares.BranchScope(ctx)
+	if scoped || ctx.Query("my_branches") == "true" {
+		branchFilter = []int64{}
+		for _, id := range branchIDs {
+			branchFilter = append(branchFilter, int64(id))
+		}
+	}
+
+	sqlFilter := `
+	WHERE status::TEXT = $1
+		AND ($2::TEXT IS NULL OR type::TEXT = $2)
+		AND ($3::INT[] IS NULL OR branch_id = ANY($3))
+	`
+
+	var total int
+	err = db.DB.QueryRow(`SELECT COUNT(*) FROM shipment_exceptions`+sqlFilter, status, typeFilter, pq.Array(branchFilter)).Scan(&total)
+	if err != nil {
+		log.Println("Failed to count exceptions", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	sqlGetExceptions := `
+	SELECT ` + exception.Columns + `
+	FROM shipment_exceptions
+	` + sqlFilter + `
+	ORDER BY created_at ASC, id ASC
+	LIMIT $4
+	OFFSET $5
+	`
+	rows, err := db.DB.Query(sqlGetExceptions, status, typeFilter, pq.Array(branchFilter), pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println("Failed to get exceptions", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	exceptions := []models.ShipmentException{}
+	shipmentIDs := []int64{}
+	for rows.Next() {
+		var e models.ShipmentException
+		if err := exception.Scan(rows, &e); err != nil {
+			log.Println("Failed to scan exception", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		exceptions = append(exceptions, e)
+		shipmentIDs = append(shipmentIDs, int64(e.ShipmentID))
+	}
+
+	// The queue shows which package each exception holds
+	shipmentRows, err := db.DB.Query(`SELECT id, tracking_number, item_name, status FROM shipments WHERE id = ANY($1)`, pq.Array(shipmentIDs))
+	if err != nil {
+		log.Println("Failed to get shipments of exceptions", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer shipmentRows.Close()
+
+	shipments := map[int]*models.Shipment{}
+	for shipmentRows.Next() {
+		var s models.Shipment
+		if err := shipmentRows.Scan(&s.ID, &s.TrackingNumber, &s.ItemName, &s.Status); err != nil {
+			log.Println("Failed to scan shipment of exception", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		shipments[s.ID] = &s
+	}
+	for i := range exceptions {
+		exceptions[i].Shipment = shipments[exceptions[i].ShipmentID]
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Exceptions retrieved successfully",
+		"data":    exceptions,
+		"meta": gin.H{
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func HandleGetExceptionByID(ctx *gin.Context) {
+	id, ok := parseExceptionID(ctx)
+	if !ok {
+		return
+	}
+
+	var e models.ShipmentException
+	err := exception.Scan(db.DB.QueryRow(`SELECT `+exception.Columns+` FROM shipment_exceptions WHERE id = $1`, id), &e)
+	if err != nil {
+		log.Println("Failed to get exception", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Exception not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, e.BranchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to access this exception",
+		})
+		return
+	}
+
+	s := models.Shipment{ID: e.ShipmentID}
+	sqlGetShipment := `SELECT tracking_number, item_name, current_branch_id, is_returned_to_sender, status FROM shipments WHERE id = $1`
+	err = db.DB.QueryRow(sqlGetShipment, e.ShipmentID).Scan(&s.TrackingNumber, &s.ItemName, &s.CurrentBranchID, &s.IsReturnedToSender, &s.Status)
+	if err != nil {
+		log.Println("Failed to get shipment of exception", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	e.Shipment = &s
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Exception retrieved successfully",
+		"data":    e,
+	})
+}
+
+// HandleResolveException lifts the hold by resuming, returning or writing off the shipment.
+func HandleResolveException(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, ok := parseExceptionID(ctx)
+	if !ok {
+		return
+	}
+
+	var body ResolveExceptionDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var e models.ShipmentException
+	err = exception.Scan(tx.QueryRow(`SELECT `+exception.Columns+` FROM shipment_exceptions WHERE id = $1 FOR UPDATE`, id), &e)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get exception", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Exception not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, e.BranchID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to resolve this exception",
+		})
+		return
+	}
+
+	s, ok := lockShipment(ctx, tx, `WHERE id = $1`, e.ShipmentID)
+	if !ok {
+		return
+	}
+
+	status, err := exception.Resolve(tx, &e, s, body.Action, body.Note, int(user.ID))
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed resolving exception", err)
+		if errors.Is(err, exception.ErrRejected) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var desc string
+	switch body.Action {
+	case models.ExceptionResolutionResume:
+		desc = fmt.Sprintf("%s has resolved the %s exception, the shipment resumes. Shipment currently is %s", user.Username, e.Type, status)
+	case models.ExceptionResolutionReturn:
+		desc = fmt.Sprintf(
+			"%s has resolved the %s exception by returning the package to the sender, it was addressed to %s (%s), %s. Shipment currently is %s",
+			user.Username, e.Type, s.RecipientName, s.RecipientPhone, s.RecipientAddress, status,
+		)
+	case models.ExceptionResolutionWriteOff:
+		desc = fmt.Sprintf("%s has resolved the %s exception by writing the package off. Shipment currently is %s", user.Username, e.Type, status)
+	}
+
+	history, err := recordHistory(tx, s.ID, status, desc, e.BranchID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to record resolution history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(history.ID)
+
+	s.Status = status
+	e.Shipment = &s
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Exception resolved successfully",
+		"data":    e,
+	})
+}
@@ -0,0 +1,13 @@
+package exceptions
+
+type RaiseExceptionDto struct {
+	TrackingNumber string `json:"tracking_number" binding:"required"`
+	BranchID       int    `json:"branch_id" binding:"required"` // branch holding the package
+	Type           string `json:"type" binding:"required,oneof=DAMAGED HELD_FOR_INSPECTION ADDRESS_PROBLEM LOST"`
+	Desc           string `json:"desc" binding:"required"`
+}
+
+type ResolveExceptionDto struct {
+	Action string  `json:"action" binding:"required,oneof=RESUME RETURN WRITE_OFF"`
+	Note   *string `json:"note"`
+}
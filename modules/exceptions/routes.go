@@ -0,0 +1,14 @@
+package exceptions
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.POST("/", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleRaiseException)
+	rg.GET("/", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleGetExceptionsList)
+	rg.GET("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleGetExceptionByID)
+	rg.POST("/:id/resolve", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), HandleResolveException)
+}
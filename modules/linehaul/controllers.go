@@ -0,0 +1,1172 @@
+package linehaul
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/collection"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/dispatch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/inventory"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/network"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/realtime"
+)
+
+const bagColumns = `
+	id,
+	barcode,
+	origin_branch_id,
+	destination_branch_id,
+	status,
+	created_by,
+	sealed_at,
+	created_at,
+	updated_at
+`
+
+const manifestColumns = `
+	id,
+	manifest_number,
+	origin_branch_id,
+	destination_branch_id,
+	vehicle_plate,
+	driver_name,
+	status,
+	created_by,
+	dispatched_at,
+	arrived_at,
+	created_at,
+	updated_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func scanBag(row scanner, b *models.Bag) error {
+	return row.Scan(
+		&b.ID,
+		&b.Barcode,
+		&b.OriginBranchID,
+		&b.DestinationBranchID,
+		&b.Status,
+		&b.CreatedBy,
+		&b.SealedAt,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+	)
+}
+
+func scanManifest(row scanner, m *models.Manifest) error {
+	return row.Scan(
+		&m.ID,
+		&m.ManifestNumber,
+		&m.OriginBranchID,
+		&m.DestinationBranchID,
+		&m.VehiclePlate,
+		&m.DriverName,
+		&m.Status,
+		&m.CreatedBy,
+		&m.DispatchedAt,
+		&m.ArrivedAt,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	)
+}
+
+func loadBagShipments(q querier, b *models.Bag) error {
+	sqlGetShipments := `
+	SELECT s.id, s.tracking_number, s.status, bi.added_at
+	FROM bag_items bi
+	JOIN shipments s ON s.id = bi.shipment_id
+	WHERE bi.bag_id = $1
+	ORDER BY bi.added_at ASC
+	`
+	rows, err := q.Query(sqlGetShipments, b.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	b.Shipments = []models.BagShipment{}
+	for rows.Next() {
+		var s models.BagShipment
+		if err := rows.Scan(&s.ShipmentID, &s.TrackingNumber, &s.Status, &s.AddedAt); err != nil {
+			return err
+		}
+		b.Shipments = append(b.Shipments, s)
+	}
+
+	return rows.Err()
+}
+
+// scopeArray is the caller's branches as a query parameter, NULL when the caller is not limited to them
+func scopeArray(ctx *gin.Context) any {
+	branchIDs, scoped := middlewares.BranchScope(ctx)
+	if !scoped {
+		return nil
+	}
+
+	ids := []int64{}
+	for _, id := range branchIDs {
+		ids = append(ids, int64(id))
+	}
+	return pq.Array(ids)
+}
+
+func bindBody(ctx *gin.Context, body any) bool {
+	err := ctx.ShouldBind(body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return false
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return false
+	}
+
+	return true
+}
+
+func HandleCreateBag(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body CreateBagDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, body.OriginBranchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only handle line-haul at the branches you are assigned to",
+		})
+		return
+	}
+
+	active, err := branch.AllActive(db.DB, body.OriginBranchID, body.DestinationBranchID)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if !active {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Branch not found",
+		})
+		return
+	}
+
+	sqlCreateBag := `
+	INSERT INTO bags (barcode, origin_branch_id, destination_branch_id, created_by)
+	VALUES ($1, $2, $3, $4)
+	RETURNING ` + bagColumns
+
+	var newBag models.Bag
+	err = scanBag(db.DB.QueryRow(sqlCreateBag, helpers.GenerateBagBarcode(), body.OriginBranchID, body.DestinationBranchID, user.ID), &newBag)
+	if err != nil {
+		log.Println(err)
+		if strings.Contains(err.Error(), "foreign key constraint") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed creating bag",
+		})
+		return
+	}
+	newBag.Shipments = []models.BagShipment{}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Bag created successfully",
+		"data":    newBag,
+	})
+}
+
+func HandleGetBagByBarcode(ctx *gin.Context) {
+	barcode := ctx.Param("barcode")
+
+	var bag models.Bag
+	err := scanBag(db.DB.QueryRow(`SELECT `+bagColumns+` FROM bags WHERE barcode = $1`, barcode), &bag)
+	if err == nil {
+		err = loadBagShipments(db.DB, &bag)
+	}
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Bag not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving bag",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving bag",
+		"data":    bag,
+	})
+}
+
+func HandleAddShipmentToBag(ctx *gin.Context) {
+	barcode := ctx.Param("barcode")
+
+	var body BagShipmentDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var bag models.Bag
+	err := scanBag(tx.QueryRow(`SELECT `+bagColumns+` FROM bags WHERE barcode = $1 FOR UPDATE`, barcode), &bag)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Bag not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, bag.OriginBranchID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only handle line-haul at the branches you are assigned to",
+		})
+		return
+	}
+
+	if bag.Status != models.BagStatusOpen {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bag is already sealed",
+		})
+		return
+	}
+
+	var shipment models.Shipment
+	err = tx.QueryRow(
+		`SELECT id, tracking_number, status, current_branch_id FROM shipments WHERE tracking_number = $1 FOR UPDATE`,
+		strings.TrimSpace(body.TrackingNumber),
+	).Scan(&shipment.ID, &shipment.TrackingNumber, &shipment.Status, &shipment.CurrentBranchID)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if shipment.Status != models.StatusPickedUp &&
+		shipment.Status != models.StatusReceivedAtBranch &&
+		shipment.Status != models.StatusInTransit {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only shipments inside the network can be bagged",
+		})
+		return
+	}
+
+	// A bag only carries packages that are physically at the branch it leaves from
+	if shipment.CurrentBranchID == nil || *shipment.CurrentBranchID != bag.OriginBranchID {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is not at the bag's origin branch",
+		})
+		return
+	}
+
+	// The shipment row lock above serializes concurrent bagging of the same shipment
+	var currentBag string
+	err = tx.QueryRow(`
+	SELECT b.barcode
+	FROM bag_items bi
+	JOIN bags b ON b.id = bi.bag_id
+	WHERE bi.shipment_id = $1 AND b.status <> $2
+	`, shipment.ID, models.BagStatusArrived).Scan(&currentBag)
+	if err == nil {
+		tx.Rollback()
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Shipment is already in bag " + currentBag,
+		})
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	_, err = tx.Exec(`INSERT INTO bag_items (bag_id, shipment_id) VALUES ($1, $2)`, bag.ID, shipment.ID)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE bags SET updated_at = NOW() WHERE id = $1`, bag.ID)
+	}
+	if err == nil {
+		err = loadBagShipments(tx, &bag)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed adding shipment to bag",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment added to bag successfully",
+		"data":    bag,
+	})
+}
+
+func HandleRemoveShipmentFromBag(ctx *gin.Context) {
+	barcode := ctx.Param("barcode")
+	trackingNumber := ctx.Param("tracking_number")
+
+	sqlRemoveShipment := `
+	DELETE FROM bag_items bi
+	USING bags b, shipments s
+	WHERE b.id = bi.bag_id AND s.id = bi.shipment_id
+	AND b.barcode = $1 AND s.tracking_number = $2 AND b.status = $3
+	AND ($4::INT[] IS NULL OR b.origin_branch_id = ANY($4))
+	`
+	result, err := db.DB.Exec(sqlRemoveShipment, barcode, trackingNumber, models.BagStatusOpen, scopeArray(ctx))
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed removing shipment from bag",
+		})
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Shipment not found in an open bag with this barcode at your branches",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment removed from bag successfully",
+	})
+}
+
+func HandleSealBag(ctx *gin.Context) {
+	barcode := ctx.Param("barcode")
+
+	sqlSealBag := `
+	UPDATE bags SET status = $2, sealed_at = NOW(), updated_at = NOW()
+	WHERE barcode = $1 AND status = $3 AND EXISTS (SELECT 1 FROM bag_items WHERE bag_id = bags.id)
+	AND ($4::INT[] IS NULL OR origin_branch_id = ANY($4))
+	RETURNING ` + bagColumns
+
+	var bag models.Bag
+	err := scanBag(db.DB.QueryRow(sqlSealBag, barcode, models.BagStatusSealed, models.BagStatusOpen, scopeArray(ctx)), &bag)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only open bags at your branches with at least one shipment can be sealed",
+		})
+		return
+	}
+	if err == nil {
+		err = loadBagShipments(db.DB, &bag)
+	}
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed sealing bag",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Bag sealed successfully",
+		"data":    bag,
+	})
+}
+
+func HandleCreateManifest(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body CreateManifestDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, body.OriginBranchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only handle line-haul at the branches you are assigned to",
+		})
+		return
+	}
+
+	active, err := branch.AllActive(db.DB, body.OriginBranchID, body.DestinationBranchID)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if !active {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Branch not found",
+		})
+		return
+	}
+
+	sqlCreateManifest := `
+	INSERT INTO manifests (manifest_number, origin_branch_id, destination_branch_id, vehicle_plate, driver_name, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + manifestColumns
+
+	var newManifest models.Manifest
+	err = scanManifest(db.DB.QueryRow(
+		sqlCreateManifest,
+		helpers.GenerateManifestNumber(),
+		body.OriginBranchID,
+		body.DestinationBranchID,
+		strings.ToUpper(strings.TrimSpace(body.VehiclePlate)),
+		body.DriverName,
+		user.ID,
+	), &newManifest)
+	if err != nil {
+		log.Println(err)
+		if strings.Contains(err.Error(), "foreign key constraint") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed creating manifest",
+		})
+		return
+	}
+	newManifest.Bags = []models.Bag{}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Manifest created successfully",
+		"data":    newManifest,
+	})
+}
+
+func HandleGetManifestsList(ctx *gin.Context) {
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var status *string
+	if s := ctx.Query("status"); s != "" {
+		if s != models.ManifestStatusDraft && s != models.ManifestStatusDispatched && s != models.ManifestStatusArrived {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'status'",
+			})
+			return
+		}
+		status = &s
+	}
+
+	var branchID *int
+	if strBranchID := ctx.Query("branch_id"); strBranchID != "" {
+		id, err := strconv.Atoi(strBranchID)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'branch_id'",
+			})
+			return
+		}
+		branchID = &id
+	}
+
+	sqlGetManifests := `
+	SELECT ` + manifestColumns + `
+	FROM manifests
+	WHERE ($1::manifest_status_enum IS NULL OR status = $1)
+	AND ($2::INT IS NULL OR origin_branch_id = $2 OR destination_branch_id = $2)
+	ORDER BY created_at DESC
+	LIMIT $3 OFFSET $4
+	`
+	rows, err := db.DB.Query(sqlGetManifests, status, branchID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving manifests",
+		})
+		return
+	}
+	defer rows.Close()
+
+	manifests := []models.Manifest{}
+	for rows.Next() {
+		var m models.Manifest
+		if err := scanManifest(rows, &m); err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving manifests",
+			})
+			return
+		}
+		manifests = append(manifests, m)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving manifests",
+		"data":    manifests,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func getManifestWithBags(q querier, id int) (models.Manifest, error) {
+	var m models.Manifest
+	err := scanManifest(q.QueryRow(`SELECT `+manifestColumns+` FROM manifests WHERE id = $1`, id), &m)
+	if err != nil {
+		return m, err
+	}
+
+	sqlGetBags := `
+	SELECT ` + bagColumns + `
+	FROM bags
+	WHERE id IN (SELECT bag_id FROM manifest_bags WHERE manifest_id = $1)
+	ORDER BY id
+	`
+	rows, err := q.Query(sqlGetBags, id)
+	if err != nil {
+		return m, err
+	}
+	m.Bags = []models.Bag{}
+	for rows.Next() {
+		var b models.Bag
+		if err := scanBag(rows, &b); err != nil {
+			rows.Close()
+			return m, err
+		}
+		m.Bags = append(m.Bags, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return m, err
+	}
+
+	for i := range m.Bags {
+		if err := loadBagShipments(q, &m.Bags[i]); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+func HandleGetManifestByID(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid manifest ID",
+		})
+		return
+	}
+
+	manifest, err := getManifestWithBags(db.DB, id)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Manifest not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving manifest",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving manifest",
+		"data":    manifest,
+	})
+}
+
+func HandleAddBagToManifest(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid manifest ID",
+		})
+		return
+	}
+
+	var body ManifestBagDto
+	if !bindBody(ctx, &body) {
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var manifest models.Manifest
+	err = scanManifest(tx.QueryRow(`SELECT `+manifestColumns+` FROM manifests WHERE id = $1 FOR UPDATE`, id), &manifest)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Manifest not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, manifest.OriginBranchID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only handle line-haul at the branches you are assigned to",
+		})
+		return
+	}
+
+	if manifest.Status != models.ManifestStatusDraft {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bags can only be loaded on a manifest that has not been dispatched",
+		})
+		return
+	}
+
+	var bag models.Bag
+	err = scanBag(tx.QueryRow(`SELECT `+bagColumns+` FROM bags WHERE barcode = $1 FOR UPDATE`, strings.TrimSpace(body.Barcode)), &bag)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Bag not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if bag.Status != models.BagStatusSealed {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only sealed bags can be loaded on a manifest",
+		})
+		return
+	}
+
+	if bag.OriginBranchID != manifest.OriginBranchID || bag.DestinationBranchID != manifest.DestinationBranchID {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bag and manifest must travel between the same branches",
+		})
+		return
+	}
+
+	_, err = tx.Exec(`INSERT INTO manifest_bags (manifest_id, bag_id) VALUES ($1, $2)`, manifest.ID, bag.ID)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if strings.Contains(err.Error(), "unique constraint") {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "Bag is already loaded on a manifest",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed loading bag on manifest",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Bag loaded on manifest successfully",
+	})
+}
+
+type manifestShipment struct {
+	id             int
+	trackingNumber string
+	status         string
+	bagBarcode     string
+}
+
+// lockManifestShipments locks every shipment carried by the manifest, ordered by id so concurrent scans cannot deadlock
+func lockManifestShipments(tx *sql.Tx, manifestID int) ([]manifestShipment, error) {
+	sqlGetShipments := `
+	SELECT s.id, s.tracking_number, s.status, b.barcode
+	FROM manifest_bags mb
+	JOIN bags b ON b.id = mb.bag_id
+	JOIN bag_items bi ON bi.bag_id = b.id
+	JOIN shipments s ON s.id = bi.shipment_id
+	WHERE mb.manifest_id = $1
+	ORDER BY s.id
+	FOR UPDATE OF s
+	`
+	rows, err := tx.Query(sqlGetShipments, manifestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shipments []manifestShipment
+	for rows.Next() {
+		var s manifestShipment
+		if err := rows.Scan(&s.id, &s.trackingNumber, &s.status, &s.bagBarcode); err != nil {
+			return nil, err
+		}
+		shipments = append(shipments, s)
+	}
+
+	return shipments, rows.Err()
+}
+
+func HandleDispatchManifest(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid manifest ID",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var manifest models.Manifest
+	err = scanManifest(tx.QueryRow(`SELECT `+manifestColumns+` FROM manifests WHERE id = $1 FOR UPDATE`, id), &manifest)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Manifest not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, manifest.OriginBranchID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only handle line-haul at the branches you are assigned to",
+		})
+		return
+	}
+
+	if manifest.Status != models.ManifestStatusDraft {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Manifest is already dispatched",
+		})
+		return
+	}
+
+	shipments, err := lockManifestShipments(tx, manifest.ID)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if len(shipments) == 0 {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Manifest has no shipments to dispatch",
+		})
+		return
+	}
+
+	var notDispatchable []string
+	for _, s := range shipments {
+		if s.status != models.StatusPickedUp && s.status != models.StatusReceivedAtBranch && s.status != models.StatusInTransit {
+			notDispatchable = append(notDispatchable, s.trackingNumber)
+		}
+	}
+	if len(notDispatchable) > 0 {
+		tx.Rollback()
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Some shipments can no longer be dispatched, unload them first",
+			"errors":  notDispatchable,
+		})
+		return
+	}
+
+	var originBranch models.Branch
+	err = tx.QueryRow(`SELECT id, name, address FROM branches WHERE id = $1`, manifest.OriginBranchID).Scan(&originBranch.ID, &originBranch.Name, &originBranch.Address)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id, branch_id)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id
+	`
+	var historyIDs []int
+	for _, s := range shipments {
+		_, err = tx.Exec(`UPDATE shipments SET status = $1, updated_at = NOW() WHERE id = $2`, models.StatusInTransit, s.id)
+		if err != nil {
+			break
+		}
+
+		desc := fmt.Sprintf(
+			"%s has dispatched the package from branch %s [%d | %s] in bag %s on manifest %s (vehicle %s). Shipment currently is %s",
+			user.Username, originBranch.Name, originBranch.ID, originBranch.Address, s.bagBarcode, manifest.ManifestNumber, manifest.VehiclePlate, models.StatusInTransit,
+		)
+		var historyID int
+		err = tx.QueryRow(sqlInitHistory, s.id, models.StatusInTransit, desc, user.ID, originBranch.ID).Scan(&historyID)
+		if err != nil {
+			break
+		}
+		historyIDs = append(historyIDs, historyID)
+
+		err = inventory.ClearLocation(tx, s.id)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE bags SET status = $2, updated_at = NOW() WHERE id IN (SELECT bag_id FROM manifest_bags WHERE manifest_id = $1)`, manifest.ID, models.BagStatusInTransit)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE manifests SET status = $2, dispatched_at = NOW(), updated_at = NOW() WHERE id = $1`, manifest.ID, models.ManifestStatusDispatched)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed dispatching manifest", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed dispatching manifest",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(historyIDs...)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Manifest dispatched with %d shipments", len(shipments)),
+	})
+}
+
+func HandleArriveManifest(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid manifest ID",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var manifest models.Manifest
+	err = scanManifest(tx.QueryRow(`SELECT `+manifestColumns+` FROM manifests WHERE id = $1 FOR UPDATE`, id), &manifest)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Manifest not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, manifest.DestinationBranchID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only handle line-haul at the branches you are assigned to",
+		})
+		return
+	}
+
+	if manifest.Status != models.ManifestStatusDispatched {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only dispatched manifests can arrive",
+		})
+		return
+	}
+
+	shipments, err := lockManifestShipments(tx, manifest.ID)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var destinationBranch models.Branch
+	err = tx.QueryRow(`SELECT id, name, address FROM branches WHERE id = $1`, manifest.DestinationBranchID).Scan(&destinationBranch.ID, &destinationBranch.Name, &destinationBranch.Address)
+	if err != nil {
+		tx.Rollback()
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id, branch_id, is_off_route)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id
+	`
+	var historyIDs []int
+	var readies []*collection.Ready
+	offRoute, skipped := 0, 0
+	for _, s := range shipments {
+		// Held and written-off packages stay where their exception put them until it is resolved
+		if s.status != models.StatusPickedUp && s.status != models.StatusReceivedAtBranch && s.status != models.StatusInTransit {
+			skipped++
+			continue
+		}
+
+		var onRoute bool
+		onRoute, err = network.RecordArrival(tx, s.id, manifest.DestinationBranchID)
+		if err != nil {
+			break
+		}
+
+		desc := fmt.Sprintf(
+			"%s has received the package at branch %s [%d | %s] from bag %s on manifest %s. Shipment currently is %s",
+			user.Username, destinationBranch.Name, destinationBranch.ID, destinationBranch.Address, s.bagBarcode, manifest.ManifestNumber, models.StatusInTransit,
+		)
+		if !onRoute {
+			offRoute++
+			desc += ". The branch is not on the planned route"
+		}
+
+		var historyID int
+		err = tx.QueryRow(sqlInitHistory, s.id, models.StatusInTransit, desc, user.ID, destinationBranch.ID, !onRoute).Scan(&historyID)
+		if err != nil {
+			break
+		}
+		historyIDs = append(historyIDs, historyID)
+
+		err = inventory.MoveToBranch(tx, s.id, manifest.DestinationBranchID)
+		if err != nil {
+			break
+		}
+
+		err = dispatch.CreateDeliveryTaskOnArrival(tx, s.id, manifest.DestinationBranchID)
+		if err != nil {
+			break
+		}
+
+		var ready *collection.Ready
+		ready, err = collection.OpenOnArrival(tx, s.id, manifest.DestinationBranchID)
+		if err != nil {
+			break
+		}
+		if ready != nil {
+			historyIDs = append(historyIDs, ready.HistoryID)
+			readies = append(readies, ready)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE bags SET status = $2, updated_at = NOW() WHERE id IN (SELECT bag_id FROM manifest_bags WHERE manifest_id = $1)`, manifest.ID, models.BagStatusArrived)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE manifests SET status = $2, arrived_at = NOW(), updated_at = NOW() WHERE id = $1`, manifest.ID, models.ManifestStatusArrived)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed receiving manifest", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed receiving manifest",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(historyIDs...)
+	for _, ready := range readies {
+		ready.Notify()
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Manifest arrived with %d shipments, %d off the planned route, %d skipped for their status", len(shipments)-skipped, offRoute, skipped),
+	})
+}
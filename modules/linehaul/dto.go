@@ -0,0 +1,21 @@
+package linehaul
+
+type CreateBagDto struct {
+	OriginBranchID      int `json:"origin_branch_id" binding:"required"`
+	DestinationBranchID int `json:"destination_branch_id" binding:"required,nefield=OriginBranchID"`
+}
+
+type BagShipmentDto struct {
+	TrackingNumber string `json:"tracking_number" binding:"required"`
+}
+
+type CreateManifestDto struct {
+	OriginBranchID      int    `json:"origin_branch_id" binding:"required"`
+	DestinationBranchID int    `json:"destination_branch_id" binding:"required,nefield=OriginBranchID"`
+	VehiclePlate        string `json:"vehicle_plate" binding:"required,max=20"`
+	DriverName          string `json:"driver_name" binding:"required"`
+}
+
+type ManifestBagDto struct {
+	Barcode string `json:"barcode" binding:"required"`
+}
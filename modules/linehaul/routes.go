@@ -0,0 +1,22 @@
+package linehaul
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.POST("/bags", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleCreateBag)
+	rg.GET("/bags/:barcode", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleGetBagByBarcode)
+	rg.POST("/bags/:barcode/shipments", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleAddShipmentToBag)
+	rg.DELETE("/bags/:barcode/shipments/:tracking_number", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleRemoveShipmentFromBag)
+	rg.POST("/bags/:barcode/seal", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleSealBag)
+
+	rg.POST("/manifests", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleCreateManifest)
+	rg.GET("/manifests", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleGetManifestsList)
+	rg.GET("/manifests/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleGetManifestByID)
+	rg.POST("/manifests/:id/bags", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleAddBagToManifest)
+	rg.POST("/manifests/:id/dispatch", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleDispatchManifest)
+	rg.POST("/manifests/:id/arrive", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), HandleArriveManifest)
+}
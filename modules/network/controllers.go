@@ -0,0 +1,272 @@
+package network
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/network"
+)
+
+func HandleCreateBranchLink(ctx *gin.Context) {
+	var body CreateBranchLinkDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	isActive := true
+	if body.IsActive != nil {
+		isActive = *body.IsActive
+	}
+
+	active, err := branch.AllActive(db.DB, body.FromBranchID, body.ToBranchID)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if !active {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Branch not found",
+		})
+		return
+	}
+
+	sqlCreateLink := `
+	INSERT INTO branch_links (from_branch_id, to_branch_id, transit_minutes, cost, is_active)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING ` + network.LinkColumns
+
+	var newLink models.BranchLink
+	err = network.ScanLink(db.DB.QueryRow(sqlCreateLink, body.FromBranchID, body.ToBranchID, body.TransitMinutes, body.Cost, isActive), &newLink)
+	if err != nil {
+		log.Println(err)
+		if strings.Contains(err.Error(), "unique constraint") {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "A link between these branches already exists",
+			})
+			return
+		}
+		if strings.Contains(err.Error(), "foreign key constraint") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed creating branch link",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Branch link created successfully",
+		"data":    newLink,
+	})
+}
+
+func HandleGetBranchLinksList(ctx *gin.Context) {
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var branchID *int
+	if strBranchID := ctx.Query("branch_id"); strBranchID != "" {
+		id, err := strconv.Atoi(strBranchID)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid 'branch_id'",
+			})
+			return
+		}
+		branchID = &id
+	}
+
+	sqlGetLinks := `
+	SELECT ` + network.LinkColumns + `
+	FROM branch_links
+	WHERE ($1::INT IS NULL OR from_branch_id = $1 OR to_branch_id = $1)
+	ORDER BY from_branch_id, to_branch_id
+	LIMIT $2 OFFSET $3
+	`
+	rows, err := db.DB.Query(sqlGetLinks, branchID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving branch links",
+		})
+		return
+	}
+	defer rows.Close()
+
+	links := []models.BranchLink{}
+	for rows.Next() {
+		var l models.BranchLink
+		if err := network.ScanLink(rows, &l); err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving branch links",
+			})
+			return
+		}
+		links = append(links, l)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving branch links",
+		"data":    links,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func HandleUpdateBranchLink(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var body UpdateBranchLinkDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	sqlUpdateLink := `
+	UPDATE branch_links SET
+		transit_minutes = $2,
+		cost = $3,
+		is_active = COALESCE($4, is_active),
+		updated_at = NOW()
+	WHERE id = $1
+	RETURNING ` + network.LinkColumns
+
+	var updatedLink models.BranchLink
+	err = network.ScanLink(db.DB.QueryRow(sqlUpdateLink, id, body.TransitMinutes, body.Cost, body.IsActive), &updatedLink)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Branch link not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed updating branch link",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Branch link updated successfully",
+		"data":    updatedLink,
+	})
+}
+
+// Planned shipment legs keep their own copy of the link, so links can be deleted at any time
+func HandleDeleteBranchLink(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	result, err := db.DB.Exec(`DELETE FROM branch_links WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed deleting branch link",
+		})
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Branch link not found",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Branch link deleted successfully",
+	})
+}
+
+// HandleGetPlannedRoute previews the route a shipment between two branches would be planned on
+func HandleGetPlannedRoute(ctx *gin.Context) {
+	fromBranchID, fromErr := strconv.Atoi(ctx.Query("from"))
+	toBranchID, toErr := strconv.Atoi(ctx.Query("to"))
+	if fromErr != nil || toErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "'from' and 'to' branch IDs are required",
+		})
+		return
+	}
+
+	legs, err := network.PlanRoute(db.DB, fromBranchID, toBranchID)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, network.ErrNoRoute) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "No route between these branches",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed planning route",
+		})
+		return
+	}
+
+	totalMinutes, totalCost := 0, 0
+	for _, l := range legs {
+		totalMinutes += l.TransitMinutes
+		totalCost += l.Cost
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success planning route",
+		"data": gin.H{
+			"legs":            legs,
+			"transit_minutes": totalMinutes,
+			"cost":            totalCost,
+		},
+	})
+}
@@ -0,0 +1,15 @@
+package network
+
+type CreateBranchLinkDto struct {
+	FromBranchID   int   `json:"from_branch_id" binding:"required"`
+	ToBranchID     int   `json:"to_branch_id" binding:"required,nefield=FromBranchID"`
+	TransitMinutes int   `json:"transit_minutes" binding:"required,gt=0"`
+	Cost           int   `json:"cost" binding:"gte=0"`
+	IsActive       *bool `json:"is_active"`
+}
+
+type UpdateBranchLinkDto struct {
+	TransitMinutes int   `json:"transit_minutes" binding:"required,gt=0"`
+	Cost           int   `json:"cost" binding:"gte=0"`
+	IsActive       *bool `json:"is_active"`
+}
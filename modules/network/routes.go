@@ -0,0 +1,15 @@
+package network
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.POST("/links", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleCreateBranchLink)
+	rg.GET("/links", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleGetBranchLinksList)
+	rg.PUT("/links/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleUpdateBranchLink)
+	rg.DELETE("/links/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleDeleteBranchLink)
+	rg.GET("/route", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleGetPlannedRoute)
+}
@@ -0,0 +1,114 @@
+package pickups
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/network"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/pickup"
+)
+
+// HandleGetPickupSlots lists the courier pickup windows of a branch for the coming days with their remaining capacity.
+func HandleGetPickupSlots(ctx *gin.Context) {
+	var query GetPickupSlotsQuery
+	err := ctx.ShouldBindQuery(&query)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid query parameters",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	branchID := query.BranchID
+	if branchID == nil {
+		if query.Address == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Either 'branch_id' or 'address' is required",
+			})
+			return
+		}
+
+		branchID, err = network.NearestBranchID(db.DB, query.Address)
+		if err != nil {
+			log.Println("Failed finding the nearest branch", err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Failed locating the address",
+			})
+			return
+		}
+		if branchID == nil {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "No branch picks up from this address",
+			})
+			return
+		}
+	}
+
+	days := query.Days
+	if days == 0 {
+		days = pickup.BookingDays
+	}
+
+	branches := make([]models.Branch, 1)
+	err = branch.Scan(db.DB.QueryRow(`SELECT `+branch.Columns+` FROM branches WHERE id = $1 AND deleted_at IS NULL`, *branchID), &branches[0])
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving pickup slots",
+		})
+		return
+	}
+
+	now := time.Now()
+	err = branch.LoadSchedules(db.DB, branches, now)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving pickup slots",
+		})
+		return
+	}
+
+	slots, err := pickup.Slots(db.DB, branches[0], now, days)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving pickup slots",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving pickup slots",
+		"data":    slots,
+		"meta": gin.H{
+			"branch_id": *branchID,
+			"timezone":  branches[0].Timezone,
+		},
+	})
+}
@@ -0,0 +1,8 @@
+package pickups
+
+// Either branch_id or the sender's address, which is matched to the nearest branch like courier pickups are
+type GetPickupSlotsQuery struct {
+	BranchID *int   `form:"branch_id"`
+	Address  string `form:"address"`
+	Days     int    `form:"days" binding:"omitempty,min=1,max=7"` // defaults to the whole booking horizon
+}
@@ -0,0 +1,10 @@
+package pickups
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.GET("/", middlewares.JwtAuthMiddleware(), HandleGetPickupSlots)
+}
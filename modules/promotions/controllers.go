@@ -0,0 +1,288 @@
+package promotions
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/promotion"
+)
+
+func bindPromotionDto(ctx *gin.Context, body *PromotionDto) bool {
+	err := ctx.ShouldBind(body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return false
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return false
+	}
+
+	if body.DiscountType == models.DiscountTypePercentage && body.DiscountValue > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Percentage discount cannot exceed 100",
+		})
+		return false
+	}
+
+	return true
+}
+
+func HandleCreatePromotion(ctx *gin.Context) {
+	var body PromotionDto
+	if !bindPromotionDto(ctx, &body) {
+		return
+	}
+
+	isActive := true
+	if body.IsActive != nil {
+		isActive = *body.IsActive
+	}
+
+	sqlCreatePromotion := `
+	INSERT INTO promotions (
+		code,
+		"desc",
+		discount_type,
+		discount_value,
+		max_discount,
+		min_spend,
+		usage_limit,
+		per_user_limit,
+		min_distance,
+		max_distance,
+		min_weight,
+		max_weight,
+		origin_keyword,
+		destination_keyword,
+		valid_from,
+		valid_until,
+		is_active
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+	RETURNING ` + promotion.Columns
+
+	var newPromotion models.Promotion
+	err := promotion.Scan(db.DB.QueryRow(
+		sqlCreatePromotion,
+		promotion.NormalizeCode(body.Code),
+		body.Desc,
+		body.DiscountType,
+		body.DiscountValue,
+		body.MaxDiscount,
+		body.MinSpend,
+		body.UsageLimit,
+		body.PerUserLimit,
+		body.MinDistance,
+		body.MaxDistance,
+		body.MinWeight,
+		body.MaxWeight,
+		body.OriginKeyword,
+		body.DestinationKeyword,
+		body.ValidFrom,
+		body.ValidUntil,
+		isActive,
+	), &newPromotion)
+	if err != nil {
+		log.Println(err)
+		if strings.Contains(err.Error(), "unique constraint") {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "'code' already taken",
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed creating promotion",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Promotion created successfully",
+		"data":    newPromotion,
+	})
+}
+
+func HandleGetPromotionsList(ctx *gin.Context) {
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	sqlGetPromotions := `SELECT ` + promotion.Columns + ` FROM promotions ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	rows, err := db.DB.Query(sqlGetPromotions, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving promotions",
+		})
+		return
+	}
+	defer rows.Close()
+
+	promotions := []models.Promotion{}
+	for rows.Next() {
+		var p models.Promotion
+		if err := promotion.Scan(rows, &p); err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed retrieving promotions",
+			})
+			return
+		}
+		promotions = append(promotions, p)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving promotions",
+		"data":    promotions,
+	})
+}
+
+func HandleGetPromotionByID(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var p models.Promotion
+	err := promotion.Scan(db.DB.QueryRow(`SELECT `+promotion.Columns+` FROM promotions WHERE id = $1`, id), &p)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Promotion not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed retrieving promotion",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving promotion",
+		"data":    p,
+	})
+}
+
+func HandleUpdatePromotion(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	var body PromotionDto
+	if !bindPromotionDto(ctx, &body) {
+		return
+	}
+
+	sqlUpdatePromotion := `
+	UPDATE promotions SET
+		code = $2,
+		"desc" = $3,
+		discount_type = $4,
+		discount_value = $5,
+		max_discount = $6,
+		min_spend = $7,
+		usage_limit = $8,
+		per_user_limit = $9,
+		min_distance = $10,
+		max_distance = $11,
+		min_weight = $12,
+		max_weight = $13,
+		origin_keyword = $14,
+		destination_keyword = $15,
+		valid_from = $16,
+		valid_until = $17,
+		is_active = COALESCE($18, is_active),
+		updated_at = NOW()
+	WHERE id = $1
+	RETURNING ` + promotion.Columns
+
+	var updatedPromotion models.Promotion
+	err := promotion.Scan(db.DB.QueryRow(
+		sqlUpdatePromotion,
+		id,
+		promotion.NormalizeCode(body.Code),
+		body.Desc,
+		body.DiscountType,
+		body.DiscountValue,
+		body.MaxDiscount,
+		body.MinSpend,
+		body.UsageLimit,
+		body.PerUserLimit,
+		body.MinDistance,
+		body.MaxDistance,
+		body.MinWeight,
+		body.MaxWeight,
+		body.OriginKeyword,
+		body.DestinationKeyword,
+		body.ValidFrom,
+		body.ValidUntil,
+		body.IsActive,
+	), &updatedPromotion)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Promotion not found",
+			})
+			return
+		}
+		if strings.Contains(err.Error(), "unique constraint") {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "'code' already taken",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed updating promotion",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Promotion updated successfully",
+		"data":    updatedPromotion,
+	})
+}
+
+// Promotions are referenced by redemptions, so "deleting" one only deactivates it
+func HandleDeactivatePromotion(ctx *gin.Context) {
+	id := ctx.Param("id")
+
+	result, err := db.DB.Exec(`UPDATE promotions SET is_active = FALSE, updated_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed deactivating promotion",
+		})
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Promotion not found",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Promotion deactivated successfully",
+	})
+}
@@ -0,0 +1,23 @@
+package promotions
+
+import "time"
+
+type PromotionDto struct {
+	Code               string    `json:"code" binding:"required,alphanum"`
+	Desc               string    `json:"desc"`
+	DiscountType       string    `json:"discount_type" binding:"required,oneof=PERCENTAGE FIXED"`
+	DiscountValue      int       `json:"discount_value" binding:"required,gt=0"` // percent for PERCENTAGE, IDR for FIXED
+	MaxDiscount        *int      `json:"max_discount" binding:"omitempty,gt=0"`
+	MinSpend           int       `json:"min_spend" binding:"gte=0"`
+	UsageLimit         *int      `json:"usage_limit" binding:"omitempty,gt=0"`
+	PerUserLimit       *int      `json:"per_user_limit" binding:"omitempty,gt=0"`
+	MinDistance        *int      `json:"min_distance" binding:"omitempty,gte=0"` // in meters
+	MaxDistance        *int      `json:"max_distance" binding:"omitempty,gt=0"`  // in meters
+	MinWeight          *float64  `json:"min_weight" binding:"omitempty,gte=0"`   // in KG
+	MaxWeight          *float64  `json:"max_weight" binding:"omitempty,gt=0"`    // in KG
+	OriginKeyword      *string   `json:"origin_keyword"`
+	DestinationKeyword *string   `json:"destination_keyword"`
+	ValidFrom          time.Time `json:"valid_from" binding:"required"`
+	ValidUntil         time.Time `json:"valid_until" binding:"required,gtfield=ValidFrom"`
+	IsActive           *bool     `json:"is_active"`
+}
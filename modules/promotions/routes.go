@@ -0,0 +1,15 @@
+package promotions
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.POST("/", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleCreatePromotion)
+	rg.GET("/", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleGetPromotionsList)
+	rg.GET("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleGetPromotionByID)
+	rg.PUT("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleUpdatePromotion)
+	rg.DELETE("/:id", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), HandleDeactivatePromotion)
+}
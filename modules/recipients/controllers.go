@@ -0,0 +1,502 @@
+package recipients
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/collection"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/realtime"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/recipient"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/reverse"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/sla"
+)
+
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+const sqlGetShipment = `
+SELECT
+	id,
+	tracking_number,
+	recipient_name,
+	recipient_address,
+	recipient_phone,
+	service_type,
+	origin_branch_id,
+	destination_branch_id,
+	current_branch_id,
+	estimated_delivery_at,
+	delivery_window_start,
+	delivery_window_end,
+	delivery_instructions,
+	is_self_collect,
+	status
+FROM shipments
+WHERE tracking_number = $1
+`
+
+// findShipment resolves the shipment of the access link in the URL and answers the request itself when the link is not valid.
+// Links stop working once the recipient phone number of the shipment changes.
+func findShipment(ctx *gin.Context, q querier, lock bool) (models.Shipment, bool) {
+	var s models.Shipment
+
+	trackingNumber, phoneDigest, err := helpers.VerifyRecipientToken(ctx.Param("token"))
+	if err != nil {
+		log.Println("Invalid recipient token", err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Invalid or expired link",
+		})
+		return s, false
+	}
+
+	sqlQuery := sqlGetShipment
+	if lock {
+		sqlQuery += ` FOR UPDATE`
+	}
+	err = q.QueryRow(sqlQuery, trackingNumber).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.RecipientName,
+		&s.RecipientAddress,
+		&s.RecipientPhone,
+		&s.ServiceType,
+		&s.OriginBranchID,
+		&s.DestinationBranchID,
+		&s.CurrentBranchID,
+		&s.EstimatedDeliveryAt,
+		&s.DeliveryWindowStart,
+		&s.DeliveryWindowEnd,
+		&s.DeliveryInstructions,
+		&s.IsSelfCollect,
+		&s.Status,
+	)
+	if err != nil {
+		log.Println("Failed to get shipment for recipient", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return s, false
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return s, false
+	}
+
+	if recipient.PhoneDigest(s.RecipientPhone) != phoneDigest {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "This link is no longer valid",
+		})
+		return s, false
+	}
+
+	return s, true
+}
+
+func isClosed(s models.Shipment) bool {
+	return s.Status == models.StatusDelivered || s.Status == models.StatusCancelled || s.Status == models.StatusWrittenOff
+}
+
+// HandleGetRecipientShipment shows the recipient their shipment, their preferences and where they could collect it.
+func HandleGetRecipientShipment(ctx *gin.Context) {
+	s, ok := findShipment(ctx, db.DB, false)
+	if !ok {
+		return
+	}
+
+	view := models.RecipientShipment{
+		ShipmentID:           s.ID,
+		TrackingNumber:       s.TrackingNumber,
+		RecipientName:        s.RecipientName,
+		RecipientAddress:     s.RecipientAddress,
+		Status:               s.Status,
+		ServiceType:          s.ServiceType,
+		EstimatedDeliveryAt:  s.EstimatedDeliveryAt,
+		DeliveryWindowStart:  s.DeliveryWindowStart,
+		DeliveryWindowEnd:    s.DeliveryWindowEnd,
+		DeliveryInstructions: s.DeliveryInstructions,
+		IsSelfCollect:        s.IsSelfCollect,
+		CollectionPoints:     []models.CollectionPoint{},
+	}
+
+	if s.IsSelfCollect && s.DestinationBranchID != nil {
+		var b models.ShBranch
+		err := db.DB.QueryRow(`SELECT id, name, phone, address FROM branches WHERE id = $1 AND deleted_at IS NULL`, *s.DestinationBranchID).Scan(&b.ID, &b.Name, &b.Phone, &b.Address)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Println("Failed to get collection branch", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		// A closed branch is not shown, the package is moved on with the rest of its stock
+		if err == nil {
+			view.CollectionBranch = &b
+		}
+	}
+
+	// Collection points are a convenience, the shipment is still shown when the recipient address cannot be located
+	if !isClosed(s) {
+		lat, lng, err := recipient.Coordinates(s)
+		if err == nil {
+			view.CollectionPoints, err = recipient.CollectionPoints(db.DB, lat, lng)
+		}
+		if err != nil {
+			log.Println("Failed finding collection points", err)
+			view.CollectionPoints = []models.CollectionPoint{}
+		}
+	}
+
+	if s.Status == models.StatusDelivered {
+		var err error
+		_, view.Returns, err = reverse.Links(db.DB, s)
+		if err != nil {
+			log.Println("Failed to get return shipments", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment retrieved successfully",
+		"data":    view,
+	})
+}
+
+// HandleSetDeliveryWindow lets the recipient choose when the courier should come.
+func HandleSetDeliveryWindow(ctx *gin.Context) {
+	var body SetDeliveryWindowDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	s, ok := findShipment(ctx, tx, true)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if isClosed(s) {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "The shipment is already " + s.Status,
+		})
+		return
+	}
+
+	start := body.DeliveryWindowStart.UTC()
+	end, err := recipient.SetDeliveryWindow(tx, s, start, time.Now())
+	if err == nil {
+		var loc *time.Location
+		loc, err = sla.Location(tx, s.DestinationBranchID)
+		if err == nil {
+			desc := fmt.Sprintf(
+				"Recipient asked for delivery on %s between %s and %s",
+				start.In(loc).Format("Mon, 02 Jan 2006"), start.In(loc).Format("15:04"), end.In(loc).Format("15:04"),
+			)
+			_, err = recordAndCommit(tx, s, desc)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed setting delivery window", err)
+		if errors.Is(err, recipient.ErrRejected) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Delivery window saved successfully",
+		"data": gin.H{
+			"delivery_window_start": start,
+			"delivery_window_end":   end,
+		},
+	})
+}
+
+// HandleRedirectToBranch sends the package to a branch near the recipient for them to collect.
+func HandleRedirectToBranch(ctx *gin.Context) {
+	var body RedirectToBranchDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	// The recipient address is located before locking the shipment, geocoding can be slow
+	s, ok := findShipment(ctx, db.DB, false)
+	if !ok {
+		return
+	}
+	lat, lng, err := recipient.Coordinates(s)
+	if err != nil {
+		log.Println("Failed locating the recipient address", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Could not locate the recipient address",
+		})
+		return
+	}
+	points, err := recipient.CollectionPoints(db.DB, lat, lng)
+	if err != nil {
+		log.Println("Failed finding collection points", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var point *models.CollectionPoint
+	for i := range points {
+		if int(points[i].ID) == body.BranchID {
+			point = &points[i]
+			break
+		}
+	}
+	if point == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "The package cannot be collected at this branch, choose one of the collection points",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	s, ok = findShipment(ctx, tx, true)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if isClosed(s) {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "The shipment is already " + s.Status,
+		})
+		return
+	}
+
+	// The redirect is logged first so it comes before the package is ready for collection on the history
+	var ready *collection.Ready
+	desc := fmt.Sprintf("Recipient redirected the package to %s for self-collection", point.Name)
+	historyID, err := recipient.RecordHistory(tx, s, desc)
+	if err == nil {
+		ready, err = recipient.RedirectToBranch(tx, s, body.BranchID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed redirecting shipment", err)
+		if errors.Is(err, recipient.ErrRejected) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(historyID)
+	if ready != nil {
+		realtime.PublishHistories(ready.HistoryID)
+		ready.Notify()
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment redirected successfully",
+		"data":    point,
+	})
+}
+
+// HandleSetInstructions lets the recipient leave notes for the courier, like where to leave the package.
+func HandleSetInstructions(ctx *gin.Context) {
+	var body SetInstructionsDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	s, ok := findShipment(ctx, tx, true)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if isClosed(s) {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "The shipment is already " + s.Status,
+		})
+		return
+	}
+
+	desc := "Recipient removed the delivery instructions"
+	if body.Instructions != "" {
+		desc = "Recipient left delivery instructions: " + body.Instructions
+	}
+
+	err = recipient.SetInstructions(tx, s, body.Instructions)
+	if err == nil {
+		_, err = recordAndCommit(tx, s, desc)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed setting delivery instructions", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Delivery instructions saved successfully",
+	})
+}
+
+// HandleResendCollectionCode texts the recipient a new collection code, the previous one stops working.
+func HandleResendCollectionCode(ctx *gin.Context) {
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	s, ok := findShipment(ctx, tx, true)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	ready, err := collection.Reissue(tx, s)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed reissuing collection code", err)
+		if errors.Is(err, collection.ErrRejected) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ready.Notify()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "A new collection code was sent to the recipient phone number",
+	})
+}
+
+// recordAndCommit logs the recipient's request on the shipment history, commits and pushes the entry to tracking streams.
+func recordAndCommit(tx *sql.Tx, s models.Shipment, desc string) (int, error) {
+	historyID, err := recipient.RecordHistory(tx, s, desc)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	realtime.PublishHistories(historyID)
+	return historyID, nil
+}
@@ -0,0 +1,15 @@
+package recipients
+
+import "time"
+
+type SetDeliveryWindowDto struct {
+	DeliveryWindowStart *time.Time `json:"delivery_window_start" binding:"required"` // the window lasts recipient.DeliveryWindowLength
+}
+
+type RedirectToBranchDto struct {
+	BranchID int `json:"branch_id" binding:"required"` // one of the collection points of the shipment
+}
+
+type SetInstructionsDto struct {
+	Instructions string `json:"instructions" binding:"max=500"` // empty removes the instructions
+}
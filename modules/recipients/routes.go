@@ -0,0 +1,14 @@
+package recipients
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Recipients have no account, the signed token of their access link is their credential
+func Routes(rg *gin.RouterGroup) {
+	rg.GET("/:token", HandleGetRecipientShipment)
+	rg.PUT("/:token/delivery-window", HandleSetDeliveryWindow)
+	rg.PUT("/:token/collection-branch", HandleRedirectToBranch)
+	rg.PUT("/:token/instructions", HandleSetInstructions)
+	rg.POST("/:token/collection-code", HandleResendCollectionCode)
+}
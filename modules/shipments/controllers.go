@@ -4,96 +4,91 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
 	"github.com/masadamsahid/golang-gin-goldship-api/db"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/adjustment"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/collection"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/dispatch"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers/googlemap"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/inventory"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/location"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/network"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/pdf"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/pickup"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/promotion"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/realtime"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/recipient"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/reverse"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/service"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/sla"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/wallet"
 	xenditService "github.com/masadamsahid/golang-gin-goldship-api/helpers/xendit-service"
 	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
 	"github.com/xendit/xendit-go/v7/invoice"
+	"golang.org/x/net/websocket"
 	"googlemaps.github.io/maps"
 )
 
-func CreateNewShipment(ctx *gin.Context) {
-	u, ok := ctx.Get("user")
-	if !ok {
-		log.Println("Failed get user from context")
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Unauthorized",
-		})
-		return
+// calculatePrices breaks the regular price of a shipment down, before the service level, promotions and insurance
+func calculatePrices(distance int, weight float64, pickupMethod string) (int, int, int) {
+	basePrice := 15000
+	if pickupMethod == models.PickupMethodDropOff {
+		basePrice = max(basePrice-helpers.DropOffDiscount, 0)
 	}
-
-	user, ok := u.(helpers.AuthPayload)
-	if !ok {
-		log.Println("Failed convert user from context to AuthPayload")
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Unauthorized",
-		})
-		return
+	additionalDistancePrice := 0
+	if distance > 100000 {
+		additionalDistancePrice = int(math.Ceil(float64(distance-100000)/10000)) * 500
 	}
 
-	var body CreateShipmentDto
-	err := ctx.ShouldBind(&body)
-	if err != nil {
-		validationErrors, ok := err.(validator.ValidationErrors)
-		if !ok {
-			log.Println(err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error",
-			})
-			return
-		}
-
-		log.Printf("%+v\n", validationErrors)
-		errs := helpers.HandleValidationErrors(validationErrors)
-
-		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
-		return
+	additionalWeightPrice := 0
+	if weight > 5 {
+		additionalWeightPrice = int(math.Ceil((weight-5)*float64(distance)/10000)) * 100
 	}
 
-	trackingNumber := helpers.GenerateTrackingNumber()
-
-	distance, err := googlemap.CalculateDistance(&maps.DistanceMatrixRequest{
-		Origins:      []string{body.SenderAddress},
-		Destinations: []string{body.RecipientAddress},
-	})
-	if err != nil {
-		log.Println("Failed to calculate distance", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error",
-		})
-		return
-	}
+	return basePrice, additionalDistancePrice, additionalWeightPrice
+}
 
-	basePrice := 15000
-	additionalDistancePrice := 0
-	log.Println("Distance:", distance)
-	log.Println("Distance in meter:", distance.Meters)
-	if distance.Meters > 100000 {
-		log.Println("More than 100K")
-		additionalDistancePrice = int(math.Ceil(float64(distance.Meters-100000)/10000)) * 500
+// priceShipment fills in the price of a new shipment from its item, pickup method and insurance, before promotions.
+// Insurance is charged on top and is never discounted by promotions.
+func priceShipment(s *models.Shipment, distance int, level service.Level) {
+	s.Distance = float64(distance)
+	s.BasePrice, s.DistancePrice, s.WeightPrice = calculatePrices(distance, s.ItemWeight, s.PickupMethod)
+	s.ServicePrice = level.Surcharge(s.BasePrice + s.DistancePrice + s.WeightPrice)
+	s.InsurancePrice = 0
+	if s.IsInsured {
+		s.InsurancePrice = helpers.CalculateInsurancePremium(s.DeclaredValue)
 	}
+	s.TotalPrice = s.BasePrice + s.DistancePrice + s.WeightPrice + s.ServicePrice + s.InsurancePrice
+}
 
-	additionalWeightPrice := 0
-	if body.ItemWeight > 5 {
-		log.Println("More than 5kg")
-		additionalWeightPrice = int(math.Ceil((body.ItemWeight-5)*float64(distance.Meters)/10000)) * 100
+// initialStatus is the status a new shipment starts with once it is priced.
+// Wallet and postpaid shipments don't wait for an invoice and neither do shipments with nothing to pay, so they skip PENDING_PAYMENT.
+func initialStatus(paymentMethod string, totalPrice int) string {
+	if totalPrice == 0 || paymentMethod == models.PaymentMethodWallet || paymentMethod == models.PaymentMethodPostpaid {
+		return models.StatusReadyToPickup
 	}
+	return models.StatusPendingPayment
+}
 
-	log.Println("Distance:", basePrice, additionalDistancePrice, additionalWeightPrice)
-
-	totalPrice := basePrice + additionalDistancePrice + additionalWeightPrice
-
+// openShipment inserts the priced shipment s with a new tracking number, plans its route and promises a delivery date.
+// A shipment without a known branch at either end or without a route between them gets no planned legs.
+func openShipment(tx *sql.Tx, s *models.Shipment) error {
 	sqlCreateShipment := `
 		INSERT INTO shipments (
 			tracking_number,
@@ -111,8 +106,20 @@ func CreateNewShipment(ctx *gin.Context) {
 			distance_price,
 			weight_price,
 			total_price,
-			"status"
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			"status",
+			promo_code,
+			discount_price,
+			declared_value,
+			is_insured,
+			insurance_price,
+			pickup_method,
+			origin_branch_id,
+			destination_branch_id,
+			service_type,
+			service_price,
+			return_of_shipment_id,
+			return_payer
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28)
 		RETURNING
 			id,
 			tracking_number,
@@ -125,187 +132,550 @@ func CreateNewShipment(ctx *gin.Context) {
 			recipient_phone,
 			item_name,
 			item_weight,
+			declared_value,
+			is_insured,
 			distance,
 			base_price,
 			distance_price,
 			weight_price,
+			service_price,
+			discount_price,
+			insurance_price,
 			total_price,
+			promo_code,
+			pickup_method,
+			service_type,
+			origin_branch_id,
+			destination_branch_id,
+			return_of_shipment_id,
+			return_payer,
 			"status",
 			created_at,
 			updated_at
 	`
+	err := tx.QueryRow(
+		sqlCreateShipment,
+		helpers.GenerateTrackingNumber(),
+		s.SenderID,
+		s.SenderName,
+		s.SenderPhone,
+		s.SenderAddress,
+		s.RecipientName,
+		s.RecipientAddress,
+		s.RecipientPhone,
+		s.ItemName,
+		s.ItemWeight,
+		s.Distance,
+		s.BasePrice,
+		s.DistancePrice,
+		s.WeightPrice,
+		s.TotalPrice,
+		s.Status,
+		s.PromoCode,
+		s.DiscountPrice,
+		s.DeclaredValue,
+		s.IsInsured,
+		s.InsurancePrice,
+		s.PickupMethod,
+		s.OriginBranchID,
+		s.DestinationBranchID,
+		s.ServiceType,
+		s.ServicePrice,
+		s.ReturnOfShipmentID,
+		s.ReturnPayer,
+	).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.SenderID,
+		&s.SenderName,
+		&s.SenderPhone,
+		&s.SenderAddress,
+		&s.RecipientName,
+		&s.RecipientAddress,
+		&s.RecipientPhone,
+		&s.ItemName,
+		&s.ItemWeight,
+		&s.DeclaredValue,
+		&s.IsInsured,
+		&s.Distance,
+		&s.BasePrice,
+		&s.DistancePrice,
+		&s.WeightPrice,
+		&s.ServicePrice,
+		&s.DiscountPrice,
+		&s.InsurancePrice,
+		&s.TotalPrice,
+		&s.PromoCode,
+		&s.PickupMethod,
+		&s.ServiceType,
+		&s.OriginBranchID,
+		&s.DestinationBranchID,
+		&s.ReturnOfShipmentID,
+		&s.ReturnPayer,
+		&s.Status,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
 
-	tx, txErr := db.DB.BeginTx(ctx, nil)
-	if txErr != nil {
-		log.Printf("Error beginning transaction: %v\n", txErr)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error",
+	if s.OriginBranchID != nil && s.DestinationBranchID != nil {
+		s.RouteLegs, err = network.PlanShipmentRoute(tx, s.ID, *s.OriginBranchID, *s.DestinationBranchID)
+		if errors.Is(err, network.ErrNoRoute) {
+			log.Printf("No route from branch %d to branch %d for shipment %d\n", *s.OriginBranchID, *s.DestinationBranchID, s.ID)
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline, err := sla.SetEstimate(tx, *s, s.RouteLegs)
+	if err != nil {
+		return err
+	}
+	estimatedDeliveryAt := deadline.Format(time.RFC3339)
+	s.EstimatedDeliveryAt = &estimatedDeliveryAt
+	return nil
+}
+
+// chargeShipment takes the payment of a new shipment from userID with paymentMethod, or records it as paid when there is nothing to pay.
+// It returns wallet.ErrInsufficientBalance and errCreditLimitExceeded when the account cannot cover the price.
+func chargeShipment(tx *sql.Tx, userID uint, s models.Shipment, paymentMethod string) (models.Payment, error) {
+	switch {
+	case s.TotalPrice == 0:
+		return recordFreePayment(tx, s, paymentMethod)
+	case paymentMethod == models.PaymentMethodWallet:
+		return payShipmentWithWallet(tx, userID, s)
+	case paymentMethod == models.PaymentMethodPostpaid:
+		return deferShipmentPayment(tx, userID, s)
+	default:
+		return createShipmentInvoice(tx, s)
+	}
+}
+
+// findDropOffBranch resolves the branch a package is dropped off at and answers the request itself when it is missing.
+func findDropOffBranch(ctx *gin.Context, originBranchID *int) (models.Branch, bool) {
+	var originBranch models.Branch
+	if originBranchID == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Validation failed",
+			"errors": gin.H{
+				"origin_branch_id": "OriginBranchID is required for drop-off shipments",
+			},
 		})
-		return
+		return originBranch, false
 	}
-	defer db.CloseTx(tx, txErr)
 
-	var newShipment models.Shipment
-	err = tx.QueryRow(
-		sqlCreateShipment,
-		trackingNumber,
-		user.ID,
-		body.SenderName,
-		body.SenderPhone,
-		body.SenderAddress,
-		body.RecipientName,
-		body.RecipientAddress,
-		body.RecipientPhone,
-		body.ItemName,
-		body.ItemWeight,
-		distance.Meters,
-		basePrice,
-		additionalDistancePrice,
-		additionalWeightPrice,
-		totalPrice,
-		models.StatusPendingPayment,
-	).Scan(
-		&newShipment.ID,
-		&newShipment.TrackingNumber,
-		&newShipment.SenderID,
-		&newShipment.SenderName,
-		&newShipment.SenderPhone,
-		&newShipment.SenderAddress,
-		&newShipment.RecipientName,
-		&newShipment.RecipientAddress,
-		&newShipment.RecipientPhone,
-		&newShipment.ItemName,
-		&newShipment.ItemWeight,
-		&newShipment.Distance,
-		&newShipment.BasePrice,
-		&newShipment.DistancePrice,
-		&newShipment.WeightPrice,
-		&newShipment.TotalPrice,
-		&newShipment.Status,
-		&newShipment.CreatedAt,
-		&newShipment.UpdatedAt,
-	)
+	err := db.DB.QueryRow(`SELECT id, name FROM branches WHERE id = $1 AND deleted_at IS NULL`, *originBranchID).Scan(&originBranch.ID, &originBranch.Name)
 	if err != nil {
-		log.Println("Failed creating shipment", err)
+		log.Println("Failed to get origin branch", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Origin branch not found",
+			})
+			return originBranch, false
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
-		return
+		return originBranch, false
 	}
 
-	createInvoiceReq := *invoice.NewCreateInvoiceRequest(
-		"INV-"+newShipment.TrackingNumber,
-		float64(totalPrice),
-	)
+	return originBranch, true
+}
 
-	createInvoiceReq.SetInvoiceDuration(30 * 60) // 30 mins
+func CreateNewShipment(ctx *gin.Context) {
+	u, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed get user from context")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
 
-	inv, resp, xenditErr := xenditService.Client.InvoiceApi.CreateInvoice(context.Background()).CreateInvoiceRequest(
-		createInvoiceReq,
-	).Execute()
+	user, ok := u.(helpers.AuthPayload)
+	if !ok {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
 
-	if xenditErr != nil {
-		log.Println("EHEEEY", xenditErr.FullError())
-		fmt.Fprintf(os.Stderr, "Error when calling `InvoiceApi.CreateInvoice``: %v\n", xenditErr.Error())
+	var body CreateShipmentDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
 
-		b, _ := json.Marshal(xenditErr.FullError())
-		fmt.Fprintf(os.Stderr, "Full Error Struct: %v\n", string(b))
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
 
-		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", resp)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Failed to create invoice",
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	if body.IsInsured && body.DeclaredValue < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Validation failed",
+			"errors": gin.H{
+				"declared_value": "DeclaredValue is required for insured shipments",
+			},
 		})
 		return
 	}
 
-	var payment models.Payment
-	sqlCreatePayment := `
-	INSERT INTO payments (
-		shipment_id,
-		amount,
-		invoice_id,
-		external_id,
-		invoice_url
-	) VALUES ($1, $2, $3, $4, $5)
-	RETURNING id, shipment_id, amount, paid_at, expired_at, invoice_id, external_id, invoice_url, "status", created_at, updated_at
-	`
-	err = tx.QueryRow(sqlCreatePayment, newShipment.ID, inv.Amount, inv.Id, inv.ExternalId, inv.InvoiceUrl).Scan(
-		&payment.ID,
-		&payment.ShipmentID,
-		&payment.Amount,
-		&payment.PaidAt,
-		&payment.ExpiredAt,
-		&payment.InvoiceID,
-		&payment.ExternalID,
-		&payment.InvoiceURL,
-		&payment.Status,
-		&payment.CreatedAt,
-		&payment.UpdatedAt,
-	)
+	pickupMethod := body.PickupMethod
+	if pickupMethod == "" {
+		pickupMethod = models.PickupMethodCourier
+	}
+
+	var originBranch models.Branch
+	if pickupMethod == models.PickupMethodDropOff {
+		originBranch, ok = findDropOffBranch(ctx, body.OriginBranchID)
+		if !ok {
+			return
+		}
+	} else {
+		body.OriginBranchID = nil
+	}
+
+	// Route planning is best effort, a shipment is still accepted when its branches or route cannot be found
+	originBranchID := body.OriginBranchID
+	if pickupMethod == models.PickupMethodCourier {
+		originBranchID, err = network.NearestBranchID(db.DB, body.SenderAddress)
+		if err != nil {
+			log.Println("Failed finding the sender's nearest branch", err)
+		}
+	}
+	destinationBranchID, err := network.NearestBranchID(db.DB, body.RecipientAddress)
+	if err != nil {
+		log.Println("Failed finding the recipient's nearest branch", err)
+	}
+
+	// Pickup windows are booked at the branch the courier is dispatched from
+	if body.PickupWindowStart != nil {
+		if pickupMethod != models.PickupMethodCourier {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Pickup windows are only available for courier pickups",
+			})
+			return
+		}
+		if originBranchID == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "No branch picks up from the sender address, pickup windows are not available",
+			})
+			return
+		}
+	}
+
+	distance, err := googlemap.CalculateDistance(&maps.DistanceMatrixRequest{
+		Origins:      []string{body.SenderAddress},
+		Destinations: []string{body.RecipientAddress},
+	})
 	if err != nil {
-		log.Println("Failed creating payment", err)
+		log.Println("Failed to calculate distance", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	var initialHistory models.ShipmentHistory
-	sqlInitHistory := `
-	INSERT INTO shipment_histories (shipment_id, status, "desc")
-	VALUES ($1, $2, $3)
-	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
-	`
-
-	desc := fmt.Sprintf("%s has requested a shipment. Shipment currently is %s", user.Username, newShipment.Status)
+	serviceType := body.ServiceType
+	if serviceType == "" {
+		serviceType = models.ServiceRegular
+	}
+	level := service.Get(serviceType)
 
-	err = tx.QueryRow(sqlInitHistory, newShipment.ID, newShipment.Status, desc).Scan(
-		&initialHistory.ID,
-		&initialHistory.ShipmentID,
-		&initialHistory.Status,
-		&initialHistory.Desc,
-		&initialHistory.CourierID,
-		&initialHistory.BranchID,
-		&initialHistory.Timestamp,
-	)
+	loc, err := sla.Location(db.DB, destinationBranchID)
 	if err != nil {
-		log.Println("Failed initializing first history", err)
+		log.Println("Failed to get destination time zone", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
+	err = level.CheckEligibility(distance.Meters, time.Now(), loc)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
 
-	err = tx.Commit()
+	newShipment := models.Shipment{
+		SenderID:            int(user.ID),
+		SenderName:          body.SenderName,
+		SenderPhone:         body.SenderPhone,
+		SenderAddress:       body.SenderAddress,
+		RecipientName:       body.RecipientName,
+		RecipientAddress:    body.RecipientAddress,
+		RecipientPhone:      body.RecipientPhone,
+		ItemName:            body.ItemName,
+		ItemWeight:          body.ItemWeight,
+		DeclaredValue:       body.DeclaredValue,
+		IsInsured:           body.IsInsured,
+		PickupMethod:        pickupMethod,
+		ServiceType:         serviceType,
+		OriginBranchID:      originBranchID,
+		DestinationBranchID: destinationBranchID,
+	}
+	priceShipment(&newShipment, distance.Meters, level)
+
+	var isCreditAccount bool
+	err = db.DB.QueryRow(`SELECT is_credit_account FROM users WHERE id = $1`, user.ID).Scan(&isCreditAccount)
 	if err != nil {
-		log.Println(err)
+		log.Println("Failed to get sender account", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	newShipment.Payment = &payment
-	newShipment.Histories = append(newShipment.Histories, initialHistory)
-
-	ctx.JSON(http.StatusCreated, gin.H{
-		"message": "Shipment created successfully",
-		"data":    newShipment,
-	})
-}
+	// Business (credit) accounts are billed monthly unless they pick another method
+	paymentMethod := body.PaymentMethod
+	if paymentMethod == "" {
+		paymentMethod = models.PaymentMethodInvoice
+		if isCreditAccount {
+			paymentMethod = models.PaymentMethodPostpaid
+		}
+	}
 
-func GetShipmentsList(ctx *gin.Context) {
-	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
-	if err != nil {
+	if paymentMethod == models.PaymentMethodPostpaid && !isCreditAccount {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": err.Error(),
+			"message": "Postpaid billing is only available for credit accounts",
 		})
 		return
 	}
 
-	var shipments []models.Shipment
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
 
-	sqlGetShipments := `
+	// The promotion row stays locked until commit so usage limits hold under concurrent checkouts
+	var promo models.Promotion
+	if body.PromoCode != "" {
+		var discountPrice int
+		promo, discountPrice, err = promotion.Apply(tx, body.PromoCode, promotion.Quote{
+			UserID:           user.ID,
+			Subtotal:         newShipment.TotalPrice - newShipment.InsurancePrice,
+			Distance:         distance.Meters,
+			Weight:           body.ItemWeight,
+			SenderAddress:    body.SenderAddress,
+			RecipientAddress: body.RecipientAddress,
+		})
+		if err != nil {
+			log.Println("Failed applying promo code", err)
+			tx.Rollback()
+			if errors.Is(err, promotion.ErrRejected) {
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		newShipment.DiscountPrice = discountPrice
+		newShipment.TotalPrice -= discountPrice
+		newShipment.PromoCode = &promo.Code
+	}
+
+	newShipment.Status = initialStatus(paymentMethod, newShipment.TotalPrice)
+	err = openShipment(tx, &newShipment)
+	if err != nil {
+		log.Println("Failed creating shipment", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if body.PickupWindowStart != nil {
+		slot, err := pickup.Book(tx, newShipment.ID, *originBranchID, body.PickupWindowStart.UTC())
+		if err != nil {
+			log.Println("Failed booking pickup window", err)
+			tx.Rollback()
+			if errors.Is(err, pickup.ErrUnavailable) {
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		windowStart, windowEnd := slot.Start.Format(time.RFC3339), slot.End.Format(time.RFC3339)
+		newShipment.PickupWindowStart, newShipment.PickupWindowEnd = &windowStart, &windowEnd
+	}
+
+	if newShipment.PromoCode != nil {
+		err = promotion.Redeem(tx, promo, user.ID, newShipment.ID, newShipment.DiscountPrice)
+		if err != nil {
+			log.Println("Failed redeeming promo code", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+	}
+
+	payment, err := chargeShipment(tx, user.ID, newShipment, paymentMethod)
+	if err != nil {
+		log.Println("Failed taking shipment payment", err)
+		tx.Rollback()
+		if errors.Is(err, wallet.ErrInsufficientBalance) {
+			ctx.JSON(http.StatusPaymentRequired, gin.H{
+				"message": "Insufficient wallet balance",
+			})
+			return
+		}
+		if errors.Is(err, errCreditLimitExceeded) {
+			ctx.JSON(http.StatusPaymentRequired, gin.H{
+				"message": "Credit limit exceeded",
+			})
+			return
+		}
+		message := "Internal server error"
+		if paymentMethod == models.PaymentMethodInvoice && newShipment.TotalPrice > 0 {
+			message = "Failed to create invoice"
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": message,
+		})
+		return
+	}
+
+	var initialHistory models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc")
+	VALUES ($1, $2, $3)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+
+	desc := fmt.Sprintf("%s has requested a shipment. Shipment currently is %s", user.Username, newShipment.Status)
+	switch {
+	case newShipment.TotalPrice == 0:
+		desc = fmt.Sprintf("%s has requested a shipment at no charge after the promotion. Shipment currently is %s", user.Username, newShipment.Status)
+	case paymentMethod == models.PaymentMethodWallet:
+		desc = fmt.Sprintf("%s has requested a shipment and paid it with wallet balance. Shipment currently is %s", user.Username, newShipment.Status)
+	case paymentMethod == models.PaymentMethodPostpaid:
+		desc = fmt.Sprintf("%s has requested a shipment billed to the monthly account. Shipment currently is %s", user.Username, newShipment.Status)
+	}
+	if pickupMethod == models.PickupMethodDropOff {
+		desc += fmt.Sprintf(". The package will be dropped off at branch %s [%d]", originBranch.Name, originBranch.ID)
+	}
+
+	err = tx.QueryRow(sqlInitHistory, newShipment.ID, newShipment.Status, desc).Scan(
+		&initialHistory.ID,
+		&initialHistory.ShipmentID,
+		&initialHistory.Status,
+		&initialHistory.Desc,
+		&initialHistory.CourierID,
+		&initialHistory.BranchID,
+		&initialHistory.Timestamp,
+	)
+	if err != nil {
+		log.Println("Failed initializing first history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if newShipment.Status == models.StatusReadyToPickup {
+		err = dispatch.CreatePickupTask(tx, newShipment.ID)
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed creating pickup task", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(initialHistory.ID)
+
+	newShipment.Payment = &payment
+	newShipment.Histories = append(newShipment.Histories, initialHistory)
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Shipment created successfully",
+		"data":    newShipment,
+	})
+}
+
+func GetShipmentsList(ctx *gin.Context) {
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Branch admins only see their own branches, other staff can opt in with ?my_branches=true
+	var branchFilter []int64
+	branchIDs, scoped := middlewares.BranchScope(ctx)
+	if scoped || ctx.Query("my_branches") == "true" {
+		branchFilter = []int64{}
+		for _, id := range branchIDs {
+			branchFilter = append(branchFilter, int64(id))
+		}
+	}
+
+	// Shipments entering, leaving, routed through or last scanned at one of the branches
+	sqlBranchFilter := `
+		WHERE $1::INT[] IS NULL
+		OR origin_branch_id = ANY($1)
+		OR destination_branch_id = ANY($1)
+		OR EXISTS (
+			SELECT 1 FROM shipment_route_legs l
+			WHERE l.shipment_id = shipments.id AND (l.from_branch_id = ANY($1) OR l.to_branch_id = ANY($1))
+		)
+		OR (
+			SELECT h.branch_id FROM shipment_histories h
+			WHERE h.shipment_id = shipments.id AND h.branch_id IS NOT NULL
+			ORDER BY h.timestamp DESC, h.id DESC
+			LIMIT 1
+		) = ANY($1)
+	`
+
+	var shipments []models.Shipment
+
+	sqlGetShipments := `
 		SELECT
 			id,
 			tracking_number,
@@ -319,14 +689,17 @@ func GetShipmentsList(ctx *gin.Context) {
 			item_name,
 			item_weight,
 			distance,
+			service_type,
+			estimated_delivery_at,
 			"status",
 			created_at,
 			updated_at
 		FROM shipments
-		LIMIT $1 OFFSET $2
+	` + sqlBranchFilter + `
+		LIMIT $2 OFFSET $3
 	`
 
-	rows, err := db.DB.Query(sqlGetShipments, pageSize, (page-1)*pageSize)
+	rows, err := db.DB.Query(sqlGetShipments, pq.Array(branchFilter), pageSize, (page-1)*pageSize)
 	if err != nil {
 		log.Println("Failed to get shipments", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -351,6 +724,8 @@ func GetShipmentsList(ctx *gin.Context) {
 			&shipment.ItemName,
 			&shipment.ItemWeight,
 			&shipment.Distance,
+			&shipment.ServiceType,
+			&shipment.EstimatedDeliveryAt,
 			&shipment.Status,
 			&shipment.CreatedAt,
 			&shipment.UpdatedAt,
@@ -366,7 +741,7 @@ func GetShipmentsList(ctx *gin.Context) {
 	}
 
 	var totalShipments int
-	err = db.DB.QueryRow("SELECT COUNT(id) FROM shipments").Scan(&totalShipments)
+	err = db.DB.QueryRow("SELECT COUNT(id) FROM shipments "+sqlBranchFilter, pq.Array(branchFilter)).Scan(&totalShipments)
 	if err != nil {
 		log.Println("Failed to get total shipments", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -399,137 +774,457 @@ func GetShipmentsList(ctx *gin.Context) {
 	})
 }
 
-func GetShipmentByID(ctx *gin.Context) {
-	strId := ctx.Param("id")
-	id, err := strconv.Atoi(strId)
+// QuoteShipment prices a prospective shipment with every service level and tells which ones can carry it.
+// Promotions and insurance are left out, they are applied at creation.
+func QuoteShipment(ctx *gin.Context) {
+	var body QuoteShipmentDto
+	err := ctx.ShouldBind(&body)
 	if err != nil {
-		log.Println(strId)
-		log.Println(err)
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid shipment ID",
-		})
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
 		return
 	}
 
-	var s models.Shipment
-	var p models.Payment
-	sqlGetShipment := `
-		SELECT
-			s.id,
-			s.tracking_number,
-			s.sender_id,
-			s.sender_name,
-			s.sender_phone,
-			s.sender_address,
-			s.recipient_name,
-			s.recipient_address,
-			s.recipient_phone,
-			s.item_name,
-			s.item_weight,
-			s.distance,
-			s.status,
-			s.created_at,
-			s.updated_at,
-			p.id,
-			p.shipment_id,
-			p.amount,
-			p.paid_at,
-			p.expired_at,
-			p.invoice_id,
-			p.external_id,
-			p.invoice_url,
-			p.status,
-			p.created_at,
-			p.updated_at
-		FROM shipments s
-		JOIN payments p ON p.shipment_id = s.id
-		WHERE s.id = $1
-	`
+	pickupMethod := body.PickupMethod
+	if pickupMethod == "" {
+		pickupMethod = models.PickupMethodCourier
+	}
 
-	err = db.DB.QueryRow(sqlGetShipment, id).Scan(
-		&s.ID,
-		&s.TrackingNumber,
-		&s.SenderID,
-		&s.SenderName,
-		&s.SenderPhone,
-		&s.SenderAddress,
-		&s.RecipientName,
-		&s.RecipientAddress,
-		&s.RecipientPhone,
-		&s.ItemName,
-		&s.ItemWeight,
-		&s.Distance,
-		&s.Status,
-		&s.CreatedAt,
-		&s.UpdatedAt,
-		&p.ID,
-		&p.ShipmentID,
-		&p.Amount,
-		&p.PaidAt,
-		&p.ExpiredAt,
-		&p.InvoiceID,
-		&p.ExternalID,
-		&p.InvoiceURL,
-		&p.Status,
-		&p.CreatedAt,
-		&p.UpdatedAt,
-	)
+	// Branches and route only refine the estimates, quoting still works without them
+	originBranchID := body.OriginBranchID
+	if pickupMethod == models.PickupMethodDropOff {
+		if originBranchID == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Validation failed",
+				"errors": gin.H{
+					"origin_branch_id": "OriginBranchID is required for drop-off shipments",
+				},
+			})
+			return
+		}
+	} else {
+		originBranchID, err = network.NearestBranchID(db.DB, body.SenderAddress)
+		if err != nil {
+			log.Println("Failed finding the sender's nearest branch", err)
+		}
+	}
+	destinationBranchID, err := network.NearestBranchID(db.DB, body.RecipientAddress)
 	if err != nil {
-		log.Println("Failed to get shipment by ID", err)
+		log.Println("Failed finding the recipient's nearest branch", err)
+	}
+
+	distance, err := googlemap.CalculateDistance(&maps.DistanceMatrixRequest{
+		Origins:      []string{body.SenderAddress},
+		Destinations: []string{body.RecipientAddress},
+	})
+	if err != nil {
+		log.Println("Failed to calculate distance", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	var histories []models.ShipmentHistory
-	sqlGetHistories := `
-		SELECT
-			id,
-			shipment_id,
-			status,
-			"desc",
-			courier_id,
-			branch_id,
-			timestamp
-		FROM shipment_histories
-		WHERE shipment_id = $1
-		ORDER BY timestamp ASC
-	`
+	var legs []models.ShipmentRouteLeg
+	if originBranchID != nil && destinationBranchID != nil {
+		legs, err = network.PlanRoute(db.DB, *originBranchID, *destinationBranchID)
+		if err != nil && !errors.Is(err, network.ErrNoRoute) {
+			log.Println("Failed planning shipment route", err)
+		}
+	}
 
-	rows, err := db.DB.Query(sqlGetHistories, id)
+	loc, err := sla.Location(db.DB, destinationBranchID)
 	if err != nil {
-		log.Println("Failed to get shipment histories", err)
+		log.Println("Failed to get destination time zone", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	defer rows.Close()
-	for rows.Next() {
-		var history models.ShipmentHistory
-		err := rows.Scan(
-			&history.ID,
-			&history.ShipmentID,
-			&history.Status,
-			&history.Desc,
-			&history.CourierID,
-			&history.BranchID,
-			&history.Timestamp,
-		)
-		if err != nil {
-			log.Println("Failed to scan shipment history", err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error",
-			})
-			return
+	now := time.Now()
+	basePrice, distancePrice, weightPrice := calculatePrices(distance.Meters, body.ItemWeight, pickupMethod)
+	quotes := []models.ShipmentQuote{}
+	for _, serviceType := range service.Types {
+		level := service.Get(serviceType)
+		q := models.ShipmentQuote{
+			ServiceType:   level.Type,
+			ServiceName:   level.Name,
+			Eligible:      true,
+			BasePrice:     basePrice,
+			DistancePrice: distancePrice,
+			WeightPrice:   weightPrice,
+			ServicePrice:  level.Surcharge(basePrice + distancePrice + weightPrice),
 		}
-		histories = append(histories, history)
-	}
+		q.TotalPrice = basePrice + distancePrice + weightPrice + q.ServicePrice
 
-	s.Payment = &p
+		if err := level.CheckEligibility(distance.Meters, now, loc); err != nil {
+			reason := err.Error()
+			q.Eligible, q.Reason = false, &reason
+		} else {
+			deadline := sla.EstimateDelivery(level, now, float64(distance.Meters), legs, loc)
+			q.EstimatedDeliveryAt = &deadline
+		}
+
+		quotes = append(quotes, q)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment quoted successfully",
+		"data": gin.H{
+			"distance": distance.Meters,
+			"quotes":   quotes,
+		},
+	})
+}
+
+// GetLateShipments lists the shipments that breached their SLA, longest overdue first.
+// Only undelivered shipments are listed unless include_delivered=true.
+func GetLateShipments(ctx *gin.Context) {
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Branch admins only see the late shipments entering, leaving or sitting at their branches
+	var branchFilter []int64
+	branchIDs, scoped := middlewares.BranchScope(ctx)
+	if scoped || ctx.Query("my_branches") == "true" {
+		branchFilter = []int64{}
+		for _, id := range branchIDs {
+			branchFilter = append(branchFilter, int64(id))
+		}
+	}
+
+	includeDelivered := ctx.Query("include_delivered") == "true"
+
+	sqlLateFilter := `
+		WHERE sla_breached_at IS NOT NULL
+		AND ($1::BOOLEAN OR status NOT IN ('DELIVERED', 'CANCELLED', 'WRITTEN_OFF'))
+		AND (
+			$2::INT[] IS NULL
+			OR origin_branch_id = ANY($2)
+			OR destination_branch_id = ANY($2)
+			OR current_branch_id = ANY($2)
+		)
+	`
+
+	sqlGetShipments := `
+		SELECT
+			id,
+			tracking_number,
+			sender_id,
+			sender_name,
+			sender_phone,
+			sender_address,
+			recipient_name,
+			recipient_address,
+			recipient_phone,
+			item_name,
+			item_weight,
+			distance,
+			service_type,
+			origin_branch_id,
+			destination_branch_id,
+			current_branch_id,
+			current_courier_id,
+			located_at,
+			estimated_delivery_at,
+			sla_breached_at,
+			"status",
+			created_at,
+			updated_at
+		FROM shipments
+	` + sqlLateFilter + `
+		ORDER BY estimated_delivery_at ASC, id ASC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := db.DB.Query(sqlGetShipments, includeDelivered, pq.Array(branchFilter), pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println("Failed to get late shipments", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	shipments := []models.Shipment{}
+	for rows.Next() {
+		var shipment models.Shipment
+		err := rows.Scan(
+			&shipment.ID,
+			&shipment.TrackingNumber,
+			&shipment.SenderID,
+			&shipment.SenderName,
+			&shipment.SenderPhone,
+			&shipment.SenderAddress,
+			&shipment.RecipientName,
+			&shipment.RecipientAddress,
+			&shipment.RecipientPhone,
+			&shipment.ItemName,
+			&shipment.ItemWeight,
+			&shipment.Distance,
+			&shipment.ServiceType,
+			&shipment.OriginBranchID,
+			&shipment.DestinationBranchID,
+			&shipment.CurrentBranchID,
+			&shipment.CurrentCourierID,
+			&shipment.LocatedAt,
+			&shipment.EstimatedDeliveryAt,
+			&shipment.SlaBreachedAt,
+			&shipment.Status,
+			&shipment.CreatedAt,
+			&shipment.UpdatedAt,
+		)
+		if err != nil {
+			log.Println("Failed to scan late shipment", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		shipments = append(shipments, shipment)
+	}
+
+	var totalShipments int
+	err = db.DB.QueryRow("SELECT COUNT(id) FROM shipments "+sqlLateFilter, includeDelivered, pq.Array(branchFilter)).Scan(&totalShipments)
+	if err != nil {
+		log.Println("Failed to get total late shipments", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Late shipments retrieved successfully",
+		"data":    shipments,
+		"meta": gin.H{
+			"total":     totalShipments,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func GetShipmentByID(ctx *gin.Context) {
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		log.Println(strId)
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid shipment ID",
+		})
+		return
+	}
+
+	var s models.Shipment
+	var p models.Payment
+	sqlGetShipment := `
+		SELECT
+			s.id,
+			s.tracking_number,
+			s.sender_id,
+			s.sender_name,
+			s.sender_phone,
+			s.sender_address,
+			s.recipient_name,
+			s.recipient_address,
+			s.recipient_phone,
+			s.item_name,
+			s.item_weight,
+			s.distance,
+			s.pickup_method,
+			s.pickup_window_start,
+			s.pickup_window_end,
+			s.delivery_window_start,
+			s.delivery_window_end,
+			s.delivery_instructions,
+			s.is_self_collect,
+			s.is_returned_to_sender,
+			s.return_of_shipment_id,
+			s.return_payer,
+			s.service_type,
+			s.origin_branch_id,
+			s.destination_branch_id,
+			s.current_branch_id,
+			s.current_courier_id,
+			s.located_at,
+			s.estimated_delivery_at,
+			s.sla_breached_at,
+			s.status,
+			s.created_at,
+			s.updated_at,
+			p.id,
+			p.shipment_id,
+			p.amount,
+			p.paid_at,
+			p.expired_at,
+			p.invoice_id,
+			p.external_id,
+			p.invoice_url,
+			p.method,
+			p.status,
+			p.created_at,
+			p.updated_at
+		FROM shipments s
+		JOIN payments p ON p.shipment_id = s.id
+		WHERE s.id = $1
+	`
+
+	err = db.DB.QueryRow(sqlGetShipment, id).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.SenderID,
+		&s.SenderName,
+		&s.SenderPhone,
+		&s.SenderAddress,
+		&s.RecipientName,
+		&s.RecipientAddress,
+		&s.RecipientPhone,
+		&s.ItemName,
+		&s.ItemWeight,
+		&s.Distance,
+		&s.PickupMethod,
+		&s.PickupWindowStart,
+		&s.PickupWindowEnd,
+		&s.DeliveryWindowStart,
+		&s.DeliveryWindowEnd,
+		&s.DeliveryInstructions,
+		&s.IsSelfCollect,
+		&s.IsReturnedToSender,
+		&s.ReturnOfShipmentID,
+		&s.ReturnPayer,
+		&s.ServiceType,
+		&s.OriginBranchID,
+		&s.DestinationBranchID,
+		&s.CurrentBranchID,
+		&s.CurrentCourierID,
+		&s.LocatedAt,
+		&s.EstimatedDeliveryAt,
+		&s.SlaBreachedAt,
+		&s.Status,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+		&p.ID,
+		&p.ShipmentID,
+		&p.Amount,
+		&p.PaidAt,
+		&p.ExpiredAt,
+		&p.InvoiceID,
+		&p.ExternalID,
+		&p.InvoiceURL,
+		&p.Method,
+		&p.Status,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		log.Println("Failed to get shipment by ID", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var histories []models.ShipmentHistory
+	sqlGetHistories := `
+		SELECT
+			id,
+			shipment_id,
+			status,
+			"desc",
+			courier_id,
+			branch_id,
+			is_off_route,
+			timestamp
+		FROM shipment_histories
+		WHERE shipment_id = $1
+		ORDER BY timestamp ASC, id ASC
+	`
+
+	rows, err := db.DB.Query(sqlGetHistories, id)
+	if err != nil {
+		log.Println("Failed to get shipment histories", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var history models.ShipmentHistory
+		err := rows.Scan(
+			&history.ID,
+			&history.ShipmentID,
+			&history.Status,
+			&history.Desc,
+			&history.CourierID,
+			&history.BranchID,
+			&history.IsOffRoute,
+			&history.Timestamp,
+		)
+		if err != nil {
+			log.Println("Failed to scan shipment history", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		histories = append(histories, history)
+	}
+
+	routeLegs, err := network.GetShipmentRoute(db.DB, id)
+	if err != nil {
+		log.Println("Failed to get shipment route", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	adjustments, err := adjustment.ListByShipment(db.DB, id)
+	if err != nil {
+		log.Println("Failed to get shipment adjustments", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	returnOf, returns, err := reverse.Links(db.DB, s)
+	if err != nil {
+		log.Println("Failed to get linked return shipments", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	s.Payment = &p
+	s.Adjustments = adjustments
+	s.ReturnOf = returnOf
+	s.Returns = returns
 	s.Histories = histories
+	s.RouteLegs = routeLegs
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Shipment retrieved successfully",
@@ -613,6 +1308,16 @@ func CancelShipmentByID(ctx *gin.Context) {
 		return
 	}
 
+	err = promotion.Release(tx, id)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to release promo code usage", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
 	var cancelHistory models.ShipmentHistory
 	sqlInitHistory := `
 	INSERT INTO shipment_histories (shipment_id, status, "desc")
@@ -648,12 +1353,15 @@ func CancelShipmentByID(ctx *gin.Context) {
 		return
 	}
 
+	realtime.PublishHistories(cancelHistory.ID)
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "Shipment cancelled successfully",
 	})
 }
 
-func PickupPackageByShipmentID(ctx *gin.Context) {
+// SchedulePickupByShipmentID books or moves the courier pickup window of a shipment that is not picked up yet.
+func SchedulePickupByShipmentID(ctx *gin.Context) {
 	u, ok := ctx.Get("user")
 	if !ok {
 		log.Println("Failed get user from context")
@@ -683,76 +1391,176 @@ func PickupPackageByShipmentID(ctx *gin.Context) {
 		return
 	}
 
+	var body SchedulePickupDto
+	err = ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
 	tx, txErr := db.DB.BeginTx(ctx, nil)
 	if txErr != nil {
-		log.Fatalf("Error beginning transaction: %v", txErr)
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
 	}
 	defer db.CloseTx(tx, txErr)
 
 	var currentShipment models.Shipment
-	err = tx.QueryRow(`SELECT id, status FROM shipments WHERE id = $1 FOR UPDATE`, id).Scan(&currentShipment.ID, &currentShipment.Status)
+	err = tx.QueryRow(`SELECT id, sender_id, status, pickup_method, origin_branch_id FROM shipments WHERE id = $1 FOR UPDATE`, id).Scan(
+		&currentShipment.ID,
+		&currentShipment.SenderID,
+		&currentShipment.Status,
+		&currentShipment.PickupMethod,
+		&currentShipment.OriginBranchID,
+	)
 	if err != nil {
-		log.Println("Failed to get shipment for pickup", err)
+		tx.Rollback()
+		log.Println("Failed to get shipment for pickup scheduling", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	if currentShipment.Status == models.StatusPickedUp ||
-		currentShipment.Status == models.StatusInTransit ||
-		currentShipment.Status == models.StatusDelivered {
+	if user.Role != roles.RoleSuperAdmin && user.Role != roles.RoleAdmin && user.ID != uint(currentShipment.SenderID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to schedule this pickup",
+		})
+		return
+	}
+
+	if currentShipment.PickupMethod != models.PickupMethodCourier || currentShipment.OriginBranchID == nil {
+		tx.Rollback()
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Shipment is already picked up",
+			"message": "Pickup windows are only available for courier pickups",
 		})
 		return
 	}
 
-	if currentShipment.Status != models.StatusReadyToPickup {
+	if currentShipment.Status != models.StatusPendingPayment && currentShipment.Status != models.StatusReadyToPickup {
+		tx.Rollback()
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Shipment can only be picked up if it's in paid status",
+			"message": "Pickup can only be scheduled before the shipment is picked up",
 		})
 		return
 	}
 
-	_, err = tx.Exec(`UPDATE shipments SET status = $1 WHERE id = $2`, models.StatusPickedUp, id)
+	slot, err := pickup.Book(tx, id, *currentShipment.OriginBranchID, body.PickupWindowStart.UTC())
 	if err != nil {
-		log.Println("Failed to update shipment status to picked up", err)
+		tx.Rollback()
+		log.Println("Failed booking pickup window", err)
+		if errors.Is(err, pickup.ErrUnavailable) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	var pickupHistory models.ShipmentHistory
-	sqlInitHistory := `
-	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id)
-	VALUES ($1, $2, $3, $4)
-	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
-	`
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
 
-	desc := fmt.Sprintf("%s has picked up the package. Shipment currently is %s", user.Username, models.StatusPickedUp)
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Pickup scheduled successfully",
+		"data":    slot,
+	})
+}
 
-	err = tx.QueryRow(sqlInitHistory, id, models.StatusPickedUp, desc, user.ID).Scan(
-		&pickupHistory.ID,
-		&pickupHistory.ShipmentID,
-		&pickupHistory.Status,
-		&pickupHistory.Desc,
-		&pickupHistory.CourierID,
-		&pickupHistory.BranchID,
-		&pickupHistory.Timestamp,
+// CreateRecipientLinkByShipmentID hands the sender the access link to share with the recipient,
+// through which the recipient manages the delivery without an account.
+func CreateRecipientLinkByShipmentID(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		log.Println(strId)
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid shipment ID",
+		})
+		return
+	}
+
+	var s models.Shipment
+	err = db.DB.QueryRow(`SELECT id, tracking_number, sender_id, recipient_phone, status FROM shipments WHERE id = $1`, id).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.SenderID,
+		&s.RecipientPhone,
+		&s.Status,
 	)
 	if err != nil {
-		log.Println("Failed to insert picked up history", err)
+		log.Println("Failed to get shipment for recipient link", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	err = tx.Commit()
+	if user.Role != roles.RoleSuperAdmin && user.Role != roles.RoleAdmin && user.ID != uint(s.SenderID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to share this shipment",
+		})
+		return
+	}
+
+	if s.Status == models.StatusDelivered || s.Status == models.StatusCancelled || s.Status == models.StatusWrittenOff {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is already " + s.Status,
+		})
+		return
+	}
+
+	link, err := recipient.Link(s.TrackingNumber, s.RecipientPhone)
 	if err != nil {
-		log.Println(err)
+		log.Println("Failed creating recipient link", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
@@ -760,23 +1568,18 @@ func PickupPackageByShipmentID(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Shipment picked up successfully",
+		"message": "Recipient link created successfully",
+		"data":    link,
 	})
 }
 
-func TransitPackageByShipmentID(ctx *gin.Context) {
-	u, ok := ctx.Get("user")
-	if !ok {
-		log.Println("Failed get user from context")
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Unauthorized",
-		})
-		return
-	}
-
-	user, ok := u.(helpers.AuthPayload)
-	if !ok {
-		log.Println("Failed convert user from context to AuthPayload")
+// CorrectRecipientByShipmentID fixes the recipient details of a shipment that is not delivered yet.
+// A new address is priced again and the difference is charged or credited, the previous values are kept in the history.
+func CorrectRecipientByShipmentID(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println(err)
 		ctx.JSON(http.StatusUnauthorized, gin.H{
 			"message": "Unauthorized",
 		})
@@ -794,14 +1597,14 @@ func TransitPackageByShipmentID(ctx *gin.Context) {
 		return
 	}
 
-	var body TransitShipmentDto
+	var body CorrectRecipientDto
 	err = ctx.ShouldBind(&body)
 	if err != nil {
 		validationErrors, ok := err.(validator.ValidationErrors)
 		if !ok {
 			log.Println(err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error",
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
 			})
 			return
 		}
@@ -813,213 +1616,2331 @@ func TransitPackageByShipmentID(ctx *gin.Context) {
 		return
 	}
 
+	if body.RecipientName == nil && body.RecipientPhone == nil && body.RecipientAddress == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Nothing to correct, give a recipient_name, recipient_phone or recipient_address",
+		})
+		return
+	}
+
 	tx, txErr := db.DB.BeginTx(ctx, nil)
 	if txErr != nil {
-		log.Fatalf("Error beginning transaction: %v", txErr)
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
 	}
 	defer db.CloseTx(tx, txErr)
 
-	var currentShipment models.Shipment
-	err = tx.QueryRow(`SELECT id, status FROM shipments WHERE id = $1 FOR UPDATE`, id).Scan(&currentShipment.ID, &currentShipment.Status)
+	var s models.Shipment
+	var createdAt time.Time
+	sqlGetShipment := `
+	SELECT
+		id,
+		tracking_number,
+		sender_id,
+		sender_address,
+		recipient_name,
+		recipient_phone,
+		recipient_address,
+		item_weight,
+		distance,
+		discount_price,
+		insurance_price,
+		total_price,
+		pickup_method,
+		service_type,
+		origin_branch_id,
+		destination_branch_id,
+		current_branch_id,
+		is_self_collect,
+		is_returned_to_sender,
+		status,
+		created_at
+	FROM shipments
+	WHERE id = $1
+	FOR UPDATE
+	`
+	err = tx.QueryRow(sqlGetShipment, id).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.SenderID,
+		&s.SenderAddress,
+		&s.RecipientName,
+		&s.RecipientPhone,
+		&s.RecipientAddress,
+		&s.ItemWeight,
+		&s.Distance,
+		&s.DiscountPrice,
+		&s.InsurancePrice,
+		&s.TotalPrice,
+		&s.PickupMethod,
+		&s.ServiceType,
+		&s.OriginBranchID,
+		&s.DestinationBranchID,
+		&s.CurrentBranchID,
+		&s.IsSelfCollect,
+		&s.IsReturnedToSender,
+		&s.Status,
+		&createdAt,
+	)
 	if err != nil {
-		log.Println("Failed to get shipment for transit", err)
+		tx.Rollback()
+		log.Println("Failed to get shipment for recipient correction", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	var transitBranch models.Branch
-	err = tx.QueryRow(`SELECT id, name, address FROM branches WHERE id = $1 LIMIT 1`, body.BranchID).Scan(&transitBranch.ID, &transitBranch.Name, &transitBranch.Address)
-	if err != nil {
-		log.Println("Failed to get branch for transit", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error",
+	if user.Role != roles.RoleSuperAdmin && user.Role != roles.RoleAdmin && user.ID != uint(s.SenderID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to correct this shipment",
 		})
 		return
 	}
 
-	if currentShipment.Status == models.StatusDelivered {
+	// Held packages can be corrected too, that is how an address problem gets fixed before resuming
+	switch s.Status {
+	case models.StatusPendingPayment, models.StatusReadyToPickup, models.StatusPickedUp, models.StatusReceivedAtBranch, models.StatusInTransit, models.StatusOnHold:
+	default:
+		tx.Rollback()
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Shipment is already in delivered",
+			"message": "Recipient details can only be corrected before delivery, shipment is " + s.Status,
 		})
 		return
 	}
 
-	if currentShipment.Status != models.StatusReadyToPickup && currentShipment.Status != models.StatusPickedUp && currentShipment.Status != models.StatusInTransit {
+	if s.IsReturnedToSender {
+		tx.Rollback()
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Shipment can only be transited if it's in picked up or in transit status",
+			"message": "The package is on its way back to the sender",
 		})
 		return
 	}
 
-	_, err = tx.Exec(`UPDATE shipments SET status = $1 WHERE id = $2`, models.StatusInTransit, id)
+	out, err := recipient.OutForDelivery(tx, s.ID)
 	if err != nil {
-		log.Println("Failed to update shipment status to in transit", err)
+		tx.Rollback()
+		log.Println("Failed checking the delivery task", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
-
-	var transitHistory models.ShipmentHistory
-	sqlInitHistory := `
-	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id, branch_id)
-	VALUES ($1, $2, $3, $4, $5)
-	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
-	`
-
-	desc := fmt.Sprintf(
-		"%s has transited the package to branch %s [%d | %s]. Shipment currently is %s",
-		user.Username, transitBranch.Name, transitBranch.ID, transitBranch.Address, models.StatusInTransit,
-	)
-
-	err = tx.QueryRow(sqlInitHistory, id, models.StatusInTransit, desc, user.ID, body.BranchID).Scan(
-		&transitHistory.ID,
-		&transitHistory.ShipmentID,
-		&transitHistory.Status,
-		&transitHistory.Desc,
-		&transitHistory.CourierID,
-		&transitHistory.BranchID,
-		&transitHistory.Timestamp,
-	)
-	if err != nil {
-		log.Println("Failed to insert in transit history", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error",
+	if out {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "The package is already out for delivery",
 		})
 		return
 	}
 
-	err = tx.Commit()
+	var payment models.Payment
+	sqlGetPayment := `SELECT id, amount, method, status FROM payments WHERE shipment_id = $1 ORDER BY id ASC LIMIT 1`
+	err = tx.QueryRow(sqlGetPayment, s.ID).Scan(&payment.ID, &payment.Amount, &payment.Method, &payment.Status)
 	if err != nil {
-		log.Println(err)
+		tx.Rollback()
+		log.Println("Failed to get shipment payment", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Shipment transited successfully",
-	})
-
-}
-
-func DeliverPackageByShipmentID(ctx *gin.Context) {
-	u, ok := ctx.Get("user")
-	if !ok {
-		log.Println("Failed get user from context")
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Unauthorized",
-		})
-		return
-	}
-
-	user, ok := u.(helpers.AuthPayload)
-	if !ok {
-		log.Println("Failed convert user from context to AuthPayload")
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Unauthorized",
-		})
-		return
+	previous := s
+	if body.RecipientName != nil {
+		s.RecipientName = strings.TrimSpace(*body.RecipientName)
 	}
-
-	strId := ctx.Param("id")
-	id, err := strconv.Atoi(strId)
-	if err != nil {
-		log.Println(strId)
-		log.Println(err)
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid shipment ID",
-		})
-		return
+	if body.RecipientPhone != nil {
+		s.RecipientPhone = strings.TrimSpace(*body.RecipientPhone)
 	}
-
-	tx, txErr := db.DB.BeginTx(ctx, nil)
-	if txErr != nil {
-		log.Fatalf("Error beginning transaction: %v", txErr)
+	if body.RecipientAddress != nil {
+		s.RecipientAddress = strings.TrimSpace(*body.RecipientAddress)
 	}
-	defer db.CloseTx(tx, txErr)
+	addressChanged := s.RecipientAddress != previous.RecipientAddress
 
-	var currentShipment models.Shipment
-	err = tx.QueryRow(`SELECT id, status FROM shipments WHERE id = $1 FOR UPDATE`, id).Scan(&currentShipment.ID, &currentShipment.Status)
+	sqlUpdateRecipient := `UPDATE shipments SET recipient_name = $2, recipient_phone = $3, updated_at = NOW() WHERE id = $1`
+	_, err = tx.Exec(sqlUpdateRecipient, s.ID, s.RecipientName, s.RecipientPhone)
 	if err != nil {
-		log.Println("Failed to get shipment for delivery", err)
+		tx.Rollback()
+		log.Println("Failed correcting recipient", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	if currentShipment.Status == models.StatusDelivered {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Shipment is already delivered",
+	var adj *models.ShipmentAdjustment
+	if addressChanged {
+		distance, err := googlemap.CalculateDistance(&maps.DistanceMatrixRequest{
+			Origins:      []string{s.SenderAddress},
+			Destinations: []string{s.RecipientAddress},
 		})
-		return
-	}
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed to calculate distance", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		s.Distance = float64(distance.Meters)
 
-	if currentShipment.Status != models.StatusInTransit {
-		ctx.JSON(http.StatusBadRequest, gin.H{
+		// Self-collected packages stay at the branch the recipient chose
+		if !s.IsSelfCollect {
+			destinationBranchID, err := network.NearestBranchID(tx, s.RecipientAddress)
+			if err != nil {
+				log.Println("Failed finding the recipient's nearest branch", err)
+			}
+			if destinationBranchID != nil {
+				s.DestinationBranchID = destinationBranchID
+			}
+		}
+
+		// The service level was promised at creation, the new distance has to qualify for it as of then
+		level := service.Get(s.ServiceType)
+		loc, err := sla.Location(tx, s.DestinationBranchID)
+		if err == nil {
+			err = level.CheckEligibility(distance.Meters, createdAt, loc)
+			if err != nil {
+				tx.Rollback()
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed to get destination time zone", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		// The promotion redeemed at creation still applies, capped at the new subtotal
+		s.BasePrice, s.DistancePrice, s.WeightPrice = calculatePrices(distance.Meters, s.ItemWeight, s.PickupMethod)
+		s.ServicePrice = level.Surcharge(s.BasePrice + s.DistancePrice + s.WeightPrice)
+		subtotal := s.BasePrice + s.DistancePrice + s.WeightPrice + s.ServicePrice
+		s.DiscountPrice = min(s.DiscountPrice, subtotal)
+		s.TotalPrice = subtotal - s.DiscountPrice + s.InsurancePrice
+
+		sqlUpdatePrice := `
+		UPDATE shipments
+		SET recipient_address = $2,
+			recipient_latitude = NULL,
+			recipient_longitude = NULL,
+			distance = $3,
+			base_price = $4,
+			distance_price = $5,
+			weight_price = $6,
+			service_price = $7,
+			discount_price = $8,
+			total_price = $9
+		WHERE id = $1
+		`
+		_, err = tx.Exec(sqlUpdatePrice, s.ID, s.RecipientAddress, distance.Meters, s.BasePrice, s.DistancePrice, s.WeightPrice, s.ServicePrice, s.DiscountPrice, s.TotalPrice)
+		if err == nil {
+			adj, err = adjustment.Settle(tx, s, payment, user.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed repricing shipment", err)
+			if errors.Is(err, adjustment.ErrRejected) {
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+			if errors.Is(err, wallet.ErrInsufficientBalance) {
+				ctx.JSON(http.StatusPaymentRequired, gin.H{
+					"message": "Insufficient wallet balance to pay the price difference",
+				})
+				return
+			}
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		rerouted := s.DestinationBranchID != nil && (previous.DestinationBranchID == nil || *s.DestinationBranchID != *previous.DestinationBranchID)
+		legs, err := network.GetShipmentRoute(tx, s.ID)
+		if err == nil && rerouted {
+			legs, err = recipient.Reroute(tx, s, *s.DestinationBranchID)
+		}
+		if err == nil {
+			if len(legs) == 0 {
+				legs = nil
+			}
+			_, err = sla.SetEstimate(tx, s, legs)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed rerouting shipment", err)
+			if errors.Is(err, recipient.ErrRejected) {
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+	}
+
+	desc := fmt.Sprintf(
+		"%s has corrected the recipient details, previously %s (%s), %s",
+		user.Username, previous.RecipientName, previous.RecipientPhone, previous.RecipientAddress,
+	)
+	if s.TotalPrice != previous.TotalPrice {
+		desc += fmt.Sprintf(". The price changed from %s to %s", formatRupiah(previous.TotalPrice), formatRupiah(s.TotalPrice))
+		switch {
+		case adj == nil:
+			desc += ", the new price is billed with the monthly statement"
+		case adj.Amount < 0:
+			desc += fmt.Sprintf(", %s was credited to the sender's wallet", formatRupiah(-adj.Amount))
+		case adj.Method == models.PaymentMethodWallet:
+			desc += fmt.Sprintf(", %s was paid with wallet balance", formatRupiah(adj.Amount))
+		default:
+			desc += fmt.Sprintf(", a supplementary invoice of %s was issued", formatRupiah(adj.Amount))
+		}
+	}
+	desc += ". Shipment currently is " + s.Status
+
+	var correctionHistory models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", branch_id)
+	VALUES ($1, $2, $3, $4)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+	err = tx.QueryRow(sqlInitHistory, s.ID, s.Status, desc, s.CurrentBranchID).Scan(
+		&correctionHistory.ID,
+		&correctionHistory.ShipmentID,
+		&correctionHistory.Status,
+		&correctionHistory.Desc,
+		&correctionHistory.CourierID,
+		&correctionHistory.BranchID,
+		&correctionHistory.Timestamp,
+	)
+	if err == nil {
+		s.Adjustments, err = adjustment.ListByShipment(tx, s.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to record recipient correction", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(correctionHistory.ID)
+
+	s.Histories = []models.ShipmentHistory{correctionHistory}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Recipient corrected successfully",
+		"data":    s,
+	})
+}
+
+// CreateReturnByShipmentID sends a delivered package back to the merchant on a new shipment linked to it.
+// The recipient asks through their access link, the merchant with their own account, and the merchant pays unless told otherwise.
+func CreateReturnByShipmentID(ctx *gin.Context) {
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		log.Println(strId)
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid shipment ID",
+		})
+		return
+	}
+
+	// Every field has a default, the body can be left out
+	var body CreateReturnDto
+	err = ctx.ShouldBind(&body)
+	if err != nil && !errors.Is(err, io.EOF) {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	var user helpers.AuthPayload
+	recipientToken := ctx.GetString("recipient_token")
+	if recipientToken == "" {
+		err = helpers.ParseJWTUserFromCtx(ctx, &user)
+		if err != nil {
+			log.Println(err)
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"message": "Unauthorized",
+			})
+			return
+		}
+	}
+
+	if recipientToken != "" && body.PaymentMethod != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only the merchant can choose how a return is paid",
+		})
+		return
+	}
+	if body.Payer == models.ReturnPayerRecipient && body.PaymentMethod != "" && body.PaymentMethod != models.PaymentMethodInvoice {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Recipients pay for returns by invoice",
+		})
+		return
+	}
+
+	pickupMethod := body.PickupMethod
+	if pickupMethod == "" {
+		pickupMethod = models.PickupMethodCourier
+	}
+
+	var originBranch models.Branch
+	if pickupMethod == models.PickupMethodDropOff {
+		var ok bool
+		originBranch, ok = findDropOffBranch(ctx, body.OriginBranchID)
+		if !ok {
+			return
+		}
+	} else {
+		body.OriginBranchID = nil
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	// The delivered shipment stays locked so two requests cannot both open a return
+	var s models.Shipment
+	sqlGetShipment := `
+	SELECT
+		id,
+		tracking_number,
+		sender_id,
+		sender_name,
+		sender_phone,
+		sender_address,
+		recipient_name,
+		recipient_phone,
+		recipient_address,
+		item_name,
+		item_weight,
+		declared_value,
+		is_insured,
+		is_returned_to_sender,
+		return_of_shipment_id,
+		status,
+		created_at
+	FROM shipments
+	WHERE id = $1
+	FOR UPDATE
+	`
+	err = tx.QueryRow(sqlGetShipment, id).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.SenderID,
+		&s.SenderName,
+		&s.SenderPhone,
+		&s.SenderAddress,
+		&s.RecipientName,
+		&s.RecipientPhone,
+		&s.RecipientAddress,
+		&s.ItemName,
+		&s.ItemWeight,
+		&s.DeclaredValue,
+		&s.IsInsured,
+		&s.IsReturnedToSender,
+		&s.ReturnOfShipmentID,
+		&s.Status,
+		&s.CreatedAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get shipment to return", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	requestedBy := user.Username
+	if recipientToken != "" {
+		// The link has to be for this shipment and its current recipient phone number
+		trackingNumber, phoneDigest, err := helpers.VerifyRecipientToken(recipientToken)
+		if err != nil || trackingNumber != s.TrackingNumber || phoneDigest != recipient.PhoneDigest(s.RecipientPhone) {
+			tx.Rollback()
+			log.Println("Invalid recipient token for return", err)
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+		requestedBy = "The recipient " + s.RecipientName
+	} else if user.Role != roles.RoleSuperAdmin && user.Role != roles.RoleAdmin && user.ID != uint(s.SenderID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to return this shipment",
+		})
+		return
+	}
+
+	err = reverse.CheckReturnable(tx, s)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Return rejected", err)
+		if errors.Is(err, reverse.ErrRejected) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var isCreditAccount bool
+	var returnWindowDays *int
+	err = tx.QueryRow(`SELECT is_credit_account, return_window_days FROM users WHERE id = $1`, s.SenderID).Scan(&isCreditAccount, &returnWindowDays)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get merchant account", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	// Recipients have no account, they can only pay by invoice
+	payer := body.Payer
+	paymentMethod := models.PaymentMethodInvoice
+	if recipientToken != "" {
+		// A recipient only bills the merchant within the return window the merchant opted in to
+		covered := false
+		if returnWindowDays != nil {
+			var deliveredAt *time.Time
+			err = tx.QueryRow(`SELECT MAX(timestamp) FROM shipment_histories WHERE shipment_id = $1 AND status = $2`, s.ID, models.StatusDelivered).Scan(&deliveredAt)
+			if err != nil {
+				tx.Rollback()
+				log.Println("Failed to get delivery date", err)
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"message": "Internal server error",
+				})
+				return
+			}
+			// Timestamps are stored without a zone, in UTC like NOW()
+			covered = deliveredAt != nil && time.Now().UTC().Sub(*deliveredAt) <= time.Duration(*returnWindowDays)*24*time.Hour
+		}
+
+		if payer == "" {
+			payer = models.ReturnPayerRecipient
+			if covered {
+				payer = models.ReturnPayerMerchant
+			}
+		}
+		if payer == models.ReturnPayerMerchant && !covered {
+			tx.Rollback()
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "The merchant does not pay for this return, request it with payer RECIPIENT",
+			})
+			return
+		}
+
+		// The merchant is not there to pay an invoice, covered returns are charged to their account right away
+		if payer == models.ReturnPayerMerchant {
+			paymentMethod = models.PaymentMethodWallet
+			if isCreditAccount {
+				paymentMethod = models.PaymentMethodPostpaid
+			}
+		}
+	} else {
+		if payer == "" {
+			payer = models.ReturnPayerMerchant
+		}
+		if payer == models.ReturnPayerMerchant {
+			if body.PaymentMethod != "" {
+				paymentMethod = body.PaymentMethod
+			} else if isCreditAccount {
+				paymentMethod = models.PaymentMethodPostpaid
+			}
+
+			if paymentMethod == models.PaymentMethodPostpaid && !isCreditAccount {
+				tx.Rollback()
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"message": "Postpaid billing is only available for credit accounts",
+				})
+				return
+			}
+		}
+	}
+
+	// Sender and recipient are swapped, the package leaves from where it was delivered
+	r := models.Shipment{
+		SenderID:           s.SenderID,
+		SenderName:         s.RecipientName,
+		SenderPhone:        s.RecipientPhone,
+		SenderAddress:      s.RecipientAddress,
+		RecipientName:      s.SenderName,
+		RecipientPhone:     s.SenderPhone,
+		RecipientAddress:   s.SenderAddress,
+		ItemName:           s.ItemName,
+		ItemWeight:         s.ItemWeight,
+		DeclaredValue:      s.DeclaredValue,
+		IsInsured:          s.IsInsured,
+		PickupMethod:       pickupMethod,
+		ReturnOfShipmentID: &s.ID,
+		ReturnPayer:        &payer,
+	}
+
+	// A return without nearby branches is still accepted, it just gets no planned route
+	r.OriginBranchID = body.OriginBranchID
+	if pickupMethod == models.PickupMethodCourier {
+		r.OriginBranchID, err = network.NearestBranchID(tx, r.SenderAddress)
+		if err != nil {
+			log.Println("Failed finding the return's pickup branch", err)
+		}
+	}
+	r.DestinationBranchID, err = network.NearestBranchID(tx, r.RecipientAddress)
+	if err != nil {
+		log.Println("Failed finding the merchant's nearest branch", err)
+	}
+
+	distance, err := googlemap.CalculateDistance(&maps.DistanceMatrixRequest{
+		Origins:      []string{r.SenderAddress},
+		Destinations: []string{r.RecipientAddress},
+	})
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to calculate distance", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	r.ServiceType = body.ServiceType
+	if r.ServiceType == "" {
+		r.ServiceType = models.ServiceRegular
+	}
+	level := service.Get(r.ServiceType)
+
+	loc, err := sla.Location(tx, r.DestinationBranchID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get destination time zone", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	err = level.CheckEligibility(distance.Meters, time.Now(), loc)
+	if err != nil {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Returns carry the same item and cover, promotions don't apply to them
+	priceShipment(&r, distance.Meters, level)
+	r.Status = initialStatus(paymentMethod, r.TotalPrice)
+	err = openShipment(tx, &r)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed creating return shipment", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	paidWith := fmt.Sprintf("to be paid by the %s by invoice", strings.ToLower(payer))
+	switch {
+	case r.TotalPrice == 0:
+		paidWith = "at no charge"
+	case paymentMethod == models.PaymentMethodWallet:
+		paidWith = "paid with the merchant's wallet balance"
+	case paymentMethod == models.PaymentMethodPostpaid:
+		paidWith = "billed to the merchant's monthly account"
+	}
+
+	payment, err := chargeShipment(tx, uint(s.SenderID), r, paymentMethod)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed taking return payment", err)
+		if errors.Is(err, wallet.ErrInsufficientBalance) || errors.Is(err, errCreditLimitExceeded) {
+			message := "Insufficient wallet balance"
+			if errors.Is(err, errCreditLimitExceeded) {
+				message = "Credit limit exceeded"
+			}
+			if recipientToken != "" {
+				message = "The merchant cannot pay for this return right now, request it with payer RECIPIENT"
+			}
+			ctx.JSON(http.StatusPaymentRequired, gin.H{
+				"message": message,
+			})
+			return
+		}
+		message := "Internal server error"
+		if paymentMethod == models.PaymentMethodInvoice && r.TotalPrice > 0 {
+			message = "Failed to create invoice"
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": message,
+		})
+		return
+	}
+
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc")
+	VALUES ($1, $2, $3)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+
+	desc := fmt.Sprintf("%s has requested the return of shipment %s, %s. Shipment currently is %s", requestedBy, s.TrackingNumber, paidWith, r.Status)
+	if pickupMethod == models.PickupMethodDropOff {
+		desc += fmt.Sprintf(". The package will be dropped off at branch %s [%d]", originBranch.Name, originBranch.ID)
+	}
+
+	var initialHistory models.ShipmentHistory
+	err = tx.QueryRow(sqlInitHistory, r.ID, r.Status, desc).Scan(
+		&initialHistory.ID,
+		&initialHistory.ShipmentID,
+		&initialHistory.Status,
+		&initialHistory.Desc,
+		&initialHistory.CourierID,
+		&initialHistory.BranchID,
+		&initialHistory.Timestamp,
+	)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed initializing return history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	// The delivered shipment's tracking points at the return too
+	var returnHistory models.ShipmentHistory
+	desc = fmt.Sprintf("%s has requested a return, the package goes back with shipment %s. Shipment currently is %s", requestedBy, r.TrackingNumber, s.Status)
+	err = tx.QueryRow(sqlInitHistory, s.ID, s.Status, desc).Scan(
+		&returnHistory.ID,
+		&returnHistory.ShipmentID,
+		&returnHistory.Status,
+		&returnHistory.Desc,
+		&returnHistory.CourierID,
+		&returnHistory.BranchID,
+		&returnHistory.Timestamp,
+	)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed recording return on the delivered shipment", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if r.Status == models.StatusReadyToPickup {
+		err = dispatch.CreatePickupTask(tx, r.ID)
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed creating pickup task", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(initialHistory.ID, returnHistory.ID)
+
+	r.Payment = &payment
+	r.Histories = []models.ShipmentHistory{initialHistory}
+	r.ReturnOf = &models.LinkedShipment{ID: s.ID, TrackingNumber: s.TrackingNumber, Status: s.Status, CreatedAt: s.CreatedAt}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Return shipment created successfully",
+		"data":    r,
+	})
+}
+
+func PickupPackageByShipmentID(ctx *gin.Context) {
+	u, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed get user from context")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	user, ok := u.(helpers.AuthPayload)
+	if !ok {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		log.Println(strId)
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid shipment ID",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Fatalf("Error beginning transaction: %v", txErr)
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var currentShipment models.Shipment
+	err = tx.QueryRow(`SELECT id, status, pickup_method, origin_branch_id FROM shipments WHERE id = $1 FOR UPDATE`, id).Scan(
+		&currentShipment.ID,
+		&currentShipment.Status,
+		&currentShipment.PickupMethod,
+		&currentShipment.OriginBranchID,
+	)
+	if err != nil {
+		log.Println("Failed to get shipment for pickup", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	// Branch admins pick up for the branch the package will enter the network at
+	if _, scoped := middlewares.BranchScope(ctx); scoped && (currentShipment.OriginBranchID == nil || !middlewares.CanAccessBranch(ctx, *currentShipment.OriginBranchID)) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only scan shipments at the branches you are assigned to",
+		})
+		return
+	}
+
+	if currentShipment.Status == models.StatusPickedUp ||
+		currentShipment.Status == models.StatusReceivedAtBranch ||
+		currentShipment.Status == models.StatusInTransit ||
+		currentShipment.Status == models.StatusDelivered {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is already picked up",
+		})
+		return
+	}
+
+	if currentShipment.Status != models.StatusReadyToPickup {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment can only be picked up if it's in paid status",
+		})
+		return
+	}
+
+	if currentShipment.PickupMethod == models.PickupMethodDropOff {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is dropped off by the customer at a branch and cannot be picked up",
+		})
+		return
+	}
+
+	// Couriers may only handle the shipments dispatched to them
+	if user.Role == roles.RoleCourier {
+		assigned, err := dispatch.IsAssignedTo(tx, id, models.TaskTypePickup, int(user.ID))
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed to check courier task", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		if !assigned {
+			tx.Rollback()
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "This shipment is not assigned to you",
+			})
+			return
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET status = $1 WHERE id = $2`, models.StatusPickedUp, id)
+	if err != nil {
+		log.Println("Failed to update shipment status to picked up", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var pickupHistory models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id)
+	VALUES ($1, $2, $3, $4)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+
+	desc := fmt.Sprintf("%s has picked up the package. Shipment currently is %s", user.Username, models.StatusPickedUp)
+
+	err = tx.QueryRow(sqlInitHistory, id, models.StatusPickedUp, desc, user.ID).Scan(
+		&pickupHistory.ID,
+		&pickupHistory.ShipmentID,
+		&pickupHistory.Status,
+		&pickupHistory.Desc,
+		&pickupHistory.CourierID,
+		&pickupHistory.BranchID,
+		&pickupHistory.Timestamp,
+	)
+	if err != nil {
+		log.Println("Failed to insert picked up history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = inventory.MoveToCourier(tx, id, int(user.ID))
+	if err == nil {
+		err = dispatch.CompleteTask(tx, id, models.TaskTypePickup)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to update shipment location", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(pickupHistory.ID)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment picked up successfully",
+	})
+}
+
+// ReceivePackageAtBranch is the branch counter scan for packages customers bring in themselves
+func ReceivePackageAtBranch(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body ReceiveAtBranchDto
+	err = ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, body.BranchID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only scan shipments at the branches you are assigned to",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var currentShipment models.Shipment
+	err = tx.QueryRow(
+		`SELECT id, status, pickup_method, origin_branch_id, destination_branch_id FROM shipments WHERE tracking_number = $1 FOR UPDATE`,
+		strings.TrimSpace(body.TrackingNumber),
+	).Scan(&currentShipment.ID, &currentShipment.Status, &currentShipment.PickupMethod, &currentShipment.OriginBranchID, &currentShipment.DestinationBranchID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get shipment for branch drop-off", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var receivingBranch models.Branch
+	err = tx.QueryRow(`SELECT id, name, address FROM branches WHERE id = $1 AND deleted_at IS NULL`, body.BranchID).Scan(&receivingBranch.ID, &receivingBranch.Name, &receivingBranch.Address)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get branch for drop-off", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if currentShipment.Status == models.StatusPendingPayment {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment has not been paid yet",
+		})
+		return
+	}
+
+	if currentShipment.Status != models.StatusReadyToPickup {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is already in the network",
+		})
+		return
+	}
+
+	// Customers may bring the package to another branch than the one they picked, the actual branch becomes the origin
+	_, err = tx.Exec(
+		`UPDATE shipments SET status = $1, pickup_method = $2, origin_branch_id = $3, updated_at = NOW() WHERE id = $4`,
+		models.StatusReceivedAtBranch, models.PickupMethodDropOff, receivingBranch.ID, currentShipment.ID,
+	)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to update shipment status to received at branch", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	originChanged := currentShipment.OriginBranchID == nil || *currentShipment.OriginBranchID != int(receivingBranch.ID)
+	if originChanged && currentShipment.DestinationBranchID != nil {
+		_, err = network.PlanShipmentRoute(tx, currentShipment.ID, int(receivingBranch.ID), *currentShipment.DestinationBranchID)
+		if errors.Is(err, network.ErrNoRoute) {
+			log.Printf("No route from branch %d to branch %d for shipment %d\n", receivingBranch.ID, *currentShipment.DestinationBranchID, currentShipment.ID)
+			err = nil
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed replanning shipment route", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+	}
+
+	var receivedHistory models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", branch_id)
+	VALUES ($1, $2, $3, $4)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+
+	desc := fmt.Sprintf(
+		"%s has received the package at branch %s [%d | %s]. Shipment currently is %s",
+		user.Username, receivingBranch.Name, receivingBranch.ID, receivingBranch.Address, models.StatusReceivedAtBranch,
+	)
+
+	err = tx.QueryRow(sqlInitHistory, currentShipment.ID, models.StatusReceivedAtBranch, desc, receivingBranch.ID).Scan(
+		&receivedHistory.ID,
+		&receivedHistory.ShipmentID,
+		&receivedHistory.Status,
+		&receivedHistory.Desc,
+		&receivedHistory.CourierID,
+		&receivedHistory.BranchID,
+		&receivedHistory.Timestamp,
+	)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to insert received at branch history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var ready *collection.Ready
+	err = inventory.MoveToBranch(tx, currentShipment.ID, int(receivingBranch.ID))
+	if err == nil {
+		// The customer brought the package in themselves, no courier has to go and get it anymore
+		err = dispatch.CancelTask(tx, currentShipment.ID, models.TaskTypePickup)
+	}
+	if err == nil {
+		// Sender and recipient may share the branch, a self-collect package then waits right where it was dropped off
+		ready, err = collection.OpenOnArrival(tx, currentShipment.ID, int(receivingBranch.ID))
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to update shipment location", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(receivedHistory.ID)
+	if ready != nil {
+		realtime.PublishHistories(ready.HistoryID)
+		ready.Notify()
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment received at branch successfully",
+		"data":    receivedHistory,
+	})
+}
+
+// CollectPackageAtBranch hands a package waiting for collection to the recipient at the branch counter,
+// once they showed the one-time code they were texted.
+func CollectPackageAtBranch(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body CollectAtBranchDto
+	err = ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var currentShipment models.Shipment
+	err = tx.QueryRow(
+		`SELECT id, status, current_branch_id FROM shipments WHERE tracking_number = $1 FOR UPDATE`,
+		strings.TrimSpace(body.TrackingNumber),
+	).Scan(&currentShipment.ID, &currentShipment.Status, &currentShipment.CurrentBranchID)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to get shipment for collection", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if currentShipment.Status != models.StatusReadyForCollection || currentShipment.CurrentBranchID == nil {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is not waiting for collection",
+		})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, *currentShipment.CurrentBranchID) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only scan shipments at the branches you are assigned to",
+		})
+		return
+	}
+
+	err = collection.Verify(tx, currentShipment.ID, body.Code)
+	if errors.Is(err, collection.ErrWrongCode) || errors.Is(err, collection.ErrLocked) {
+		// Failed attempts are kept, otherwise the attempt limit could be bypassed
+		if txErr = tx.Commit(); txErr != nil {
+			log.Println(txErr)
+		}
+		status := http.StatusBadRequest
+		if errors.Is(err, collection.ErrLocked) {
+			status = http.StatusTooManyRequests
+		}
+		ctx.JSON(status, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed verifying collection code", err)
+		if errors.Is(err, collection.ErrRejected) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var collectionBranch models.Branch
+	err = tx.QueryRow(`SELECT id, name, address FROM branches WHERE id = $1`, *currentShipment.CurrentBranchID).Scan(&collectionBranch.ID, &collectionBranch.Name, &collectionBranch.Address)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE shipments SET status = $1, updated_at = NOW() WHERE id = $2`, models.StatusDelivered, currentShipment.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to update shipment status to delivered", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var collectedHistory models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", branch_id)
+	VALUES ($1, $2, $3, $4)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+
+	desc := fmt.Sprintf(
+		"%s has handed the package to the recipient at branch %s [%d | %s] after checking their collection code. Shipment currently is %s",
+		user.Username, collectionBranch.Name, collectionBranch.ID, collectionBranch.Address, models.StatusDelivered,
+	)
+
+	err = tx.QueryRow(sqlInitHistory, currentShipment.ID, models.StatusDelivered, desc, collectionBranch.ID).Scan(
+		&collectedHistory.ID,
+		&collectedHistory.ShipmentID,
+		&collectedHistory.Status,
+		&collectedHistory.Desc,
+		&collectedHistory.CourierID,
+		&collectedHistory.BranchID,
+		&collectedHistory.Timestamp,
+	)
+	if err == nil {
+		err = inventory.ClearLocation(tx, currentShipment.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to record collection", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(collectedHistory.ID)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment collected successfully",
+		"data":    collectedHistory,
+	})
+}
+
+func TransitPackageByShipmentID(ctx *gin.Context) {
+	u, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed get user from context")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	user, ok := u.(helpers.AuthPayload)
+	if !ok {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		log.Println(strId)
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid shipment ID",
+		})
+		return
+	}
+
+	var body TransitShipmentDto
+	err = ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	if !middlewares.CanAccessBranch(ctx, int(body.BranchID)) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only scan shipments at the branches you are assigned to",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Fatalf("Error beginning transaction: %v", txErr)
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var currentShipment models.Shipment
+	err = tx.QueryRow(`SELECT id, status FROM shipments WHERE id = $1 FOR UPDATE`, id).Scan(&currentShipment.ID, &currentShipment.Status)
+	if err != nil {
+		log.Println("Failed to get shipment for transit", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var transitBranch models.Branch
+	err = tx.QueryRow(`SELECT id, name, address FROM branches WHERE id = $1 AND deleted_at IS NULL LIMIT 1`, body.BranchID).Scan(&transitBranch.ID, &transitBranch.Name, &transitBranch.Address)
+	if err != nil {
+		log.Println("Failed to get branch for transit", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if currentShipment.Status == models.StatusDelivered {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is already in delivered",
+		})
+		return
+	}
+
+	if currentShipment.Status != models.StatusReadyToPickup &&
+		currentShipment.Status != models.StatusPickedUp &&
+		currentShipment.Status != models.StatusReceivedAtBranch &&
+		currentShipment.Status != models.StatusInTransit {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment can only be transited if it's in picked up, received at branch or in transit status",
+		})
+		return
+	}
+
+	bag, err := inventory.SealedBag(tx, id)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to check shipment bag", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if bag != "" {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is sealed in bag " + bag + ", it moves with the bag",
+		})
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET status = $1 WHERE id = $2`, models.StatusInTransit, id)
+	if err != nil {
+		log.Println("Failed to update shipment status to in transit", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	onRoute, err := network.RecordArrival(tx, id, int(transitBranch.ID))
+	if err != nil {
+		log.Println("Failed to check shipment route", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var transitHistory models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id, branch_id, is_off_route)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, is_off_route, timestamp
+	`
+
+	desc := fmt.Sprintf(
+		"%s has transited the package to branch %s [%d | %s]. Shipment currently is %s",
+		user.Username, transitBranch.Name, transitBranch.ID, transitBranch.Address, models.StatusInTransit,
+	)
+	if !onRoute {
+		desc += ". The branch is not on the planned route"
+	}
+
+	err = tx.QueryRow(sqlInitHistory, id, models.StatusInTransit, desc, user.ID, body.BranchID, !onRoute).Scan(
+		&transitHistory.ID,
+		&transitHistory.ShipmentID,
+		&transitHistory.Status,
+		&transitHistory.Desc,
+		&transitHistory.CourierID,
+		&transitHistory.BranchID,
+		&transitHistory.IsOffRoute,
+		&transitHistory.Timestamp,
+	)
+	if err != nil {
+		log.Println("Failed to insert in transit history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var ready *collection.Ready
+	err = inventory.MoveToBranch(tx, id, int(transitBranch.ID))
+	if err == nil {
+		err = dispatch.CreateDeliveryTaskOnArrival(tx, id, int(transitBranch.ID))
+	}
+	if err == nil {
+		ready, err = collection.OpenOnArrival(tx, id, int(transitBranch.ID))
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to update shipment location", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(transitHistory.ID)
+	if ready != nil {
+		realtime.PublishHistories(ready.HistoryID)
+		ready.Notify()
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment transited successfully",
+		"data":    transitHistory,
+	})
+
+}
+
+func DeliverPackageByShipmentID(ctx *gin.Context) {
+	u, ok := ctx.Get("user")
+	if !ok {
+		log.Println("Failed get user from context")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	user, ok := u.(helpers.AuthPayload)
+	if !ok {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		log.Println(strId)
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid shipment ID",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Fatalf("Error beginning transaction: %v", txErr)
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var currentShipment models.Shipment
+	err = tx.QueryRow(`SELECT id, status, destination_branch_id FROM shipments WHERE id = $1 FOR UPDATE`, id).Scan(
+		&currentShipment.ID,
+		&currentShipment.Status,
+		&currentShipment.DestinationBranchID,
+	)
+	if err != nil {
+		log.Println("Failed to get shipment for delivery", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	// Branch admins deliver for the branch closest to the recipient
+	if _, scoped := middlewares.BranchScope(ctx); scoped && (currentShipment.DestinationBranchID == nil || !middlewares.CanAccessBranch(ctx, *currentShipment.DestinationBranchID)) {
+		tx.Rollback()
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only scan shipments at the branches you are assigned to",
+		})
+		return
+	}
+
+	if currentShipment.Status == models.StatusDelivered {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is already delivered",
+		})
+		return
+	}
+
+	if currentShipment.Status != models.StatusInTransit {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": "Shipment can only be delivered if it's in transit status",
 		})
 		return
 	}
 
-	_, err = tx.Exec(`UPDATE shipments SET status = $1 WHERE id = $2`, models.StatusDelivered, id)
+	bag, err := inventory.SealedBag(tx, id)
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to check shipment bag", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if bag != "" {
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Shipment is sealed in bag " + bag + ", it moves with the bag",
+		})
+		return
+	}
+
+	// Couriers may only handle the shipments dispatched to them
+	if user.Role == roles.RoleCourier {
+		assigned, err := dispatch.IsAssignedTo(tx, id, models.TaskTypeDelivery, int(user.ID))
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed to check courier task", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		if !assigned {
+			tx.Rollback()
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "This shipment is not assigned to you",
+			})
+			return
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE shipments SET status = $1 WHERE id = $2`, models.StatusDelivered, id)
+	if err != nil {
+		log.Println("Failed to update shipment status to delivered", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var deliveredHistory models.ShipmentHistory
+	sqlInitHistory := `
+	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id)
+	VALUES ($1, $2, $3, $4)
+	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	`
+
+	desc := fmt.Sprintf("%s has delivered the package. Shipment currently is %s", user.Username, models.StatusDelivered)
+
+	err = tx.QueryRow(sqlInitHistory, id, models.StatusDelivered, desc, user.ID).Scan(
+		&deliveredHistory.ID,
+		&deliveredHistory.ShipmentID,
+		&deliveredHistory.Status,
+		&deliveredHistory.Desc,
+		&deliveredHistory.CourierID,
+		&deliveredHistory.BranchID,
+		&deliveredHistory.Timestamp,
+	)
+	if err != nil {
+		log.Println("Failed to insert delivered history", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = inventory.ClearLocation(tx, id)
+	if err == nil {
+		err = dispatch.CompleteTask(tx, id, models.TaskTypeDelivery)
+	}
+	if err == nil {
+		err = sla.RecordDelivery(tx, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to update shipment location", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	realtime.PublishHistories(deliveredHistory.ID)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Shipment delivered successfully",
+	})
+
+}
+
+func TrackShipmentHistoriesByTrackingNumber(ctx *gin.Context) {
+	trackingNumber := ctx.Param("tracking_number")
+
+	var s models.Shipment
+	sqlGetShipment := `
+		SELECT
+			s.id,
+			s.tracking_number,
+			s.sender_id,
+			s.sender_name,
+			s.sender_phone,
+			s.sender_address,
+			s.recipient_name,
+			s.recipient_address,
+			s.recipient_phone,
+			s.item_name,
+			s.item_weight,
+			s.distance,
+			s.service_type,
+			s.return_of_shipment_id,
+			s.estimated_delivery_at,
+			s.sla_breached_at,
+			s.status,
+			s.created_at,
+			s.updated_at
+		FROM shipments s
+		WHERE s.tracking_number = $1
+		LIMIT 1
+	`
+
+	err := db.DB.QueryRow(sqlGetShipment, trackingNumber).Scan(
+		&s.ID,
+		&s.TrackingNumber,
+		&s.SenderID,
+		&s.SenderName,
+		&s.SenderPhone,
+		&s.SenderAddress,
+		&s.RecipientName,
+		&s.RecipientAddress,
+		&s.RecipientPhone,
+		&s.ItemName,
+		&s.ItemWeight,
+		&s.Distance,
+		&s.ServiceType,
+		&s.ReturnOfShipmentID,
+		&s.EstimatedDeliveryAt,
+		&s.SlaBreachedAt,
+		&s.Status,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		log.Println("Failed to get shipment by tracking number", err)
+		if err.Error() == sql.ErrNoRows.Error() {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	var histories []models.ShipmentHistory
+	sqlGetHistories := `
+		SELECT
+			sh.id,
+			sh.shipment_id,
+			sh.status,
+			sh."desc",
+			sh.courier_id,
+			sh.branch_id,
+			sh.timestamp,
+			u.id,
+			u.username,
+			u.email,
+			u.role,
+			b.id,
+			b.name,
+			b.address,
+			b.phone
+		FROM shipment_histories sh
+		LEFT JOIN users u ON u.id = sh.courier_id
+		LEFT JOIN branches b ON b.id = sh.branch_id
+		WHERE sh.shipment_id = $1
+		ORDER BY sh.timestamp DESC, sh.id DESC
+	`
+
+	rows, err := db.DB.Query(sqlGetHistories, s.ID)
+	if err != nil {
+		log.Println("Failed to get shipment histories", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var h models.ShipmentHistory
+		var c models.ShCourier
+		var b models.ShBranch
+		err := rows.Scan(
+			&h.ID,
+			&h.ShipmentID,
+			&h.Status,
+			&h.Desc,
+			&h.CourierID,
+			&h.BranchID,
+			&h.Timestamp,
+			&c.ID, &c.Username, &c.Email, &c.Role, // possible nulls
+			&b.ID, &b.Name, &b.Address, &b.Phone, // possible nulls
+		)
+		if err != nil {
+			log.Println("Failed to scan shipment history", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		if h.CourierID != nil {
+			h.Courier = &c
+		}
+		if h.BranchID != nil {
+			h.Branch = &b
+		}
+
+		histories = append(histories, h)
+	}
+
+	var courierPosition *models.CourierPosition
+	if s.Status == models.StatusInTransit {
+		courierPosition, err = location.DeliveryPosition(s.ID)
+		if err != nil {
+			// The position is a nice-to-have, tracking still works without it
+			log.Println("Failed to get courier position", err)
+		}
+	}
+
+	// Returns and the shipments they send back can be tracked from either side
+	returnOf, returns, err := reverse.Links(db.DB, s)
+	if err != nil {
+		log.Println("Failed to get linked return shipments", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":               "Tracked successfully",
+		"data":                  histories,
+		"service_type":          s.ServiceType,
+		"estimated_delivery_at": s.EstimatedDeliveryAt,
+		"is_late":               s.SlaBreachedAt != nil,
+		"courier_location":      courierPosition,
+		"return_of":             returnOf,
+		"returns":               returns,
+	})
+}
+
+// Interval of the keep-alive sent on tracking streams so proxies don't close idle connections
+const streamKeepAlive = 25 * time.Second
+
+// openTrackingStream looks the shipment up and subscribes to its updates, the first events bring the subscriber
+// up to date with what the tracking endpoint would show for the courier right now.
+func openTrackingStream(ctx *gin.Context) ([]realtime.Event, <-chan realtime.Event, func(), bool) {
+	trackingNumber := ctx.Param("tracking_number")
+
+	var id int
+	var status string
+	err := db.DB.QueryRow(`SELECT id, status FROM shipments WHERE tracking_number = $1 LIMIT 1`, trackingNumber).Scan(&id, &status)
+	if err != nil {
+		log.Println("Failed to get shipment by tracking number", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "Shipment not found",
+			})
+			return nil, nil, nil, false
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return nil, nil, nil, false
+	}
+
+	// Subscribe before reading the current position so no update slips in between
+	events, unsubscribe := realtime.Subscribe(realtime.TrackingTopic(trackingNumber))
+
+	var initial []realtime.Event
+	if status == models.StatusInTransit {
+		position, err := location.DeliveryPosition(id)
+		if err != nil {
+			log.Println("Failed to get courier position", err)
+		}
+		if position != nil {
+			data, _ := json.Marshal(position)
+			initial = append(initial, realtime.Event{Type: realtime.EventCourierLocation, Data: data})
+		}
+	}
+
+	return initial, events, unsubscribe, true
+}
+
+// StreamShipmentTracking pushes new history entries and courier positions of a shipment as Server-Sent Events
+func StreamShipmentTracking(ctx *gin.Context) {
+	initial, events, unsubscribe, ok := openTrackingStream(ctx)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no") // nginx would otherwise hold the events back
+	ctx.Status(http.StatusOK)
+
+	for _, e := range initial {
+		ctx.SSEvent(e.Type, e.Data)
+	}
+	ctx.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent(e.Type, e.Data)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// StreamShipmentTrackingWS is the WebSocket equivalent of StreamShipmentTracking, every message is an
+// {"event": ..., "data": ...} JSON object. Messages sent by the client are ignored.
+func StreamShipmentTrackingWS(ctx *gin.Context) {
+	initial, events, unsubscribe, ok := openTrackingStream(ctx)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	// Tracking is public, so connections are accepted from any origin
+	server := websocket.Server{Handler: func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		closed := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, conn)
+			close(closed)
+		}()
+
+		for _, e := range initial {
+			if websocket.JSON.Send(conn, e) != nil {
+				return
+			}
+		}
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			var err error
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				err = websocket.JSON.Send(conn, e)
+			case <-keepAlive.C:
+				err = websocket.JSON.Send(conn, realtime.Event{Type: "keep_alive"})
+			case <-closed:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}}
+	server.ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+var errCreditLimitExceeded = errors.New("credit limit exceeded")
+
+// deferShipmentPayment records a pending POSTPAID payment that is settled by the sender's monthly billing invoice.
+// The sender row is locked so concurrent shipments cannot overrun the credit limit together.
+func deferShipmentPayment(tx *sql.Tx, userID uint, s models.Shipment) (models.Payment, error) {
+	var payment models.Payment
+
+	var creditLimit int
+	err := tx.QueryRow(`SELECT credit_limit FROM users WHERE id = $1 AND is_credit_account FOR UPDATE`, userID).Scan(&creditLimit)
+	if err != nil {
+		return payment, err
+	}
+
+	var outstanding int
+	sqlGetOutstanding := `
+	SELECT COALESCE(SUM(p.amount), 0)
+	FROM payments p
+	JOIN shipments s ON s.id = p.shipment_id
+	WHERE s.sender_id = $1 AND p.method = $2 AND p.status = $3
+	`
+	err = tx.QueryRow(sqlGetOutstanding, userID, models.PaymentMethodPostpaid, models.PaymentStatusPending).Scan(&outstanding)
+	if err != nil {
+		return payment, err
+	}
+
+	if outstanding+s.TotalPrice > creditLimit {
+		return payment, errCreditLimitExceeded
+	}
+
+	sqlCreatePayment := `
+	INSERT INTO payments (
+		shipment_id,
+		amount,
+		invoice_id,
+		external_id,
+		method
+	) VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, shipment_id, amount, paid_at, expired_at, invoice_id, external_id, invoice_url, method, "status", created_at, updated_at
+	`
+	err = tx.QueryRow(sqlCreatePayment, s.ID, s.TotalPrice, "POSTPAID-"+s.TrackingNumber, "INV-"+s.TrackingNumber, models.PaymentMethodPostpaid).Scan(
+		&payment.ID,
+		&payment.ShipmentID,
+		&payment.Amount,
+		&payment.PaidAt,
+		&payment.ExpiredAt,
+		&payment.InvoiceID,
+		&payment.ExternalID,
+		&payment.InvoiceURL,
+		&payment.Method,
+		&payment.Status,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	)
+
+	return payment, err
+}
+
+func createShipmentInvoice(tx *sql.Tx, s models.Shipment) (models.Payment, error) {
+	var payment models.Payment
+
+	createInvoiceReq := *invoice.NewCreateInvoiceRequest(
+		"INV-"+s.TrackingNumber,
+		float64(s.TotalPrice),
+	)
+
+	createInvoiceReq.SetInvoiceDuration(30 * 60) // 30 mins
+
+	inv, resp, xenditErr := xenditService.Client.InvoiceApi.CreateInvoice(context.Background()).CreateInvoiceRequest(
+		createInvoiceReq,
+	).Execute()
+
+	if xenditErr != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `InvoiceApi.CreateInvoice``: %v\n", xenditErr.Error())
+
+		b, _ := json.Marshal(xenditErr.FullError())
+		fmt.Fprintf(os.Stderr, "Full Error Struct: %v\n", string(b))
+
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", resp)
+		return payment, xenditErr
+	}
+
+	sqlCreatePayment := `
+	INSERT INTO payments (
+		shipment_id,
+		amount,
+		invoice_id,
+		external_id,
+		invoice_url,
+		method
+	) VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, shipment_id, amount, paid_at, expired_at, invoice_id, external_id, invoice_url, method, "status", created_at, updated_at
+	`
+	err := tx.QueryRow(sqlCreatePayment, s.ID, inv.Amount, inv.Id, inv.ExternalId, inv.InvoiceUrl, models.PaymentMethodInvoice).Scan(
+		&payment.ID,
+		&payment.ShipmentID,
+		&payment.Amount,
+		&payment.PaidAt,
+		&payment.ExpiredAt,
+		&payment.InvoiceID,
+		&payment.ExternalID,
+		&payment.InvoiceURL,
+		&payment.Method,
+		&payment.Status,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	)
+
+	return payment, err
+}
+
+// recordFreePayment marks a shipment with nothing to pay as PAID, wallets and invoices cannot carry a zero amount.
+func recordFreePayment(tx *sql.Tx, s models.Shipment, method string) (models.Payment, error) {
+	var payment models.Payment
+
+	sqlCreatePayment := `
+	INSERT INTO payments (
+		shipment_id,
+		amount,
+		invoice_id,
+		external_id,
+		method,
+		"status",
+		paid_at
+	) VALUES ($1, 0, $2, $3, $4, $5, NOW())
+	RETURNING id, shipment_id, amount, paid_at, expired_at, invoice_id, external_id, invoice_url, method, "status", created_at, updated_at
+	`
+	err := tx.QueryRow(sqlCreatePayment, s.ID, "FREE-"+s.TrackingNumber, "INV-"+s.TrackingNumber, method, models.PaymentStatusPaid).Scan(
+		&payment.ID,
+		&payment.ShipmentID,
+		&payment.Amount,
+		&payment.PaidAt,
+		&payment.ExpiredAt,
+		&payment.InvoiceID,
+		&payment.ExternalID,
+		&payment.InvoiceURL,
+		&payment.Method,
+		&payment.Status,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	)
+
+	return payment, err
+}
+
+// payShipmentWithWallet debits the sender's wallet into the shipment revenue account and records a PAID payment.
+func payShipmentWithWallet(tx *sql.Tx, userID uint, s models.Shipment) (models.Payment, error) {
+	var payment models.Payment
+
+	account, err := wallet.GetOrCreateUserAccount(tx, userID)
 	if err != nil {
-		log.Println("Failed to update shipment status to delivered", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error",
-		})
-		return
+		return payment, err
 	}
 
-	var deliveredHistory models.ShipmentHistory
-	sqlInitHistory := `
-	INSERT INTO shipment_histories (shipment_id, status, "desc", courier_id)
-	VALUES ($1, $2, $3, $4)
-	RETURNING  id, shipment_id, status, "desc", courier_id, branch_id, timestamp
+	revenueAccountID, err := wallet.GetSystemAccountID(tx, wallet.AccountCodeShipmentRevenue)
+	if err != nil {
+		return payment, err
+	}
+
+	reference := "WALLET-" + s.TrackingNumber
+	_, err = wallet.Transfer(
+		tx,
+		wallet.TransactionTypeShipmentPayment,
+		reference,
+		"Payment for shipment "+s.TrackingNumber,
+		account.ID,
+		revenueAccountID,
+		s.TotalPrice,
+	)
+	if err != nil {
+		return payment, err
+	}
+
+	sqlCreatePayment := `
+	INSERT INTO payments (
+		shipment_id,
+		amount,
+		invoice_id,
+		external_id,
+		method,
+		"status",
+		paid_at
+	) VALUES ($1, $2, $3, $4, $5, $6, NOW())
+	RETURNING id, shipment_id, amount, paid_at, expired_at, invoice_id, external_id, invoice_url, method, "status", created_at, updated_at
 	`
+	err = tx.QueryRow(sqlCreatePayment, s.ID, s.TotalPrice, reference, "INV-"+s.TrackingNumber, models.PaymentMethodWallet, models.PaymentStatusPaid).Scan(
+		&payment.ID,
+		&payment.ShipmentID,
+		&payment.Amount,
+		&payment.PaidAt,
+		&payment.ExpiredAt,
+		&payment.InvoiceID,
+		&payment.ExternalID,
+		&payment.InvoiceURL,
+		&payment.Method,
+		&payment.Status,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	)
 
-	desc := fmt.Sprintf("%s has delivered the package. Shipment currently is %s", user.Username, models.StatusDelivered)
+	return payment, err
+}
 
-	err = tx.QueryRow(sqlInitHistory, id, models.StatusDelivered, desc, user.ID).Scan(
-		&deliveredHistory.ID,
-		&deliveredHistory.ShipmentID,
-		&deliveredHistory.Status,
-		&deliveredHistory.Desc,
-		&deliveredHistory.CourierID,
-		&deliveredHistory.BranchID,
-		&deliveredHistory.Timestamp,
+// issueReceipt returns the shipment's receipt, assigning the next receipt number of the year the first time.
+func issueReceipt(ctx context.Context, shipmentID int) (models.Receipt, error) {
+	var receipt models.Receipt
+
+	sqlGetReceipt := `SELECT id, shipment_id, receipt_number, issued_at FROM receipts WHERE shipment_id = $1`
+	err := db.DB.QueryRow(sqlGetReceipt, shipmentID).Scan(&receipt.ID, &receipt.ShipmentID, &receipt.ReceiptNumber, &receipt.IssuedAt)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return receipt, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return receipt, err
+	}
+	defer tx.Rollback()
+
+	year := time.Now().Year()
+	var number int
+	sqlNextNumber := `
+	INSERT INTO receipt_counters (year, last_number) VALUES ($1, 1)
+	ON CONFLICT (year) DO UPDATE SET last_number = receipt_counters.last_number + 1
+	RETURNING last_number
+	`
+	err = tx.QueryRow(sqlNextNumber, year).Scan(&number)
+	if err != nil {
+		return receipt, err
+	}
+
+	sqlCreateReceipt := `
+	INSERT INTO receipts (shipment_id, receipt_number) VALUES ($1, $2)
+	ON CONFLICT (shipment_id) DO NOTHING
+	RETURNING id, shipment_id, receipt_number, issued_at
+	`
+	err = tx.QueryRow(sqlCreateReceipt, shipmentID, fmt.Sprintf("RCP/%d/%06d", year, number)).Scan(
+		&receipt.ID,
+		&receipt.ShipmentID,
+		&receipt.ReceiptNumber,
+		&receipt.IssuedAt,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		// A concurrent request issued it first, rolling back returns our number to the counter
+		tx.Rollback()
+		err = db.DB.QueryRow(sqlGetReceipt, shipmentID).Scan(&receipt.ID, &receipt.ShipmentID, &receipt.ReceiptNumber, &receipt.IssuedAt)
+		return receipt, err
+	}
 	if err != nil {
-		log.Println("Failed to insert delivered history", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error",
-		})
-		return
+		return receipt, err
 	}
 
 	err = tx.Commit()
-	if err != nil {
-		log.Println(err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Internal server error",
-		})
-		return
+	return receipt, err
+}
+
+func formatRupiah(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Shipment delivered successfully",
-	})
+	digits := strconv.Itoa(amount)
+	var grouped []byte
+	for i := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped = append(grouped, '.')
+		}
+		grouped = append(grouped, digits[i])
+	}
 
+	return sign + "Rp " + string(grouped)
 }
 
-func TrackShipmentHistoriesByTrackingNumber(ctx *gin.Context) {
-	trackingNumber := ctx.Param("tracking_number")
+func DownloadShipmentReceipt(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	strId := ctx.Param("id")
+	id, err := strconv.Atoi(strId)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid shipment ID",
+		})
+		return
+	}
 
 	var s models.Shipment
+	var p models.Payment
 	sqlGetShipment := `
 		SELECT
 			s.id,
@@ -1033,16 +3954,27 @@ func TrackShipmentHistoriesByTrackingNumber(ctx *gin.Context) {
 			s.recipient_phone,
 			s.item_name,
 			s.item_weight,
+			s.declared_value,
 			s.distance,
-			s.status,
-			s.created_at,
-			s.updated_at
+			s.base_price,
+			s.distance_price,
+			s.weight_price,
+			s.service_type,
+			s.service_price,
+			s.discount_price,
+			s.insurance_price,
+			s.total_price,
+			s.promo_code,
+			p.amount,
+			p.invoice_id,
+			p.method,
+			p.status,
+			p.paid_at
 		FROM shipments s
-		WHERE s.tracking_number = $1
-		LIMIT 1
+		JOIN payments p ON p.shipment_id = s.id
+		WHERE s.id = $1
 	`
-
-	err := db.DB.QueryRow(sqlGetShipment, trackingNumber).Scan(
+	err = db.DB.QueryRow(sqlGetShipment, id).Scan(
 		&s.ID,
 		&s.TrackingNumber,
 		&s.SenderID,
@@ -1054,14 +3986,26 @@ func TrackShipmentHistoriesByTrackingNumber(ctx *gin.Context) {
 		&s.RecipientPhone,
 		&s.ItemName,
 		&s.ItemWeight,
+		&s.DeclaredValue,
 		&s.Distance,
-		&s.Status,
-		&s.CreatedAt,
-		&s.UpdatedAt,
+		&s.BasePrice,
+		&s.DistancePrice,
+		&s.WeightPrice,
+		&s.ServiceType,
+		&s.ServicePrice,
+		&s.DiscountPrice,
+		&s.InsurancePrice,
+		&s.TotalPrice,
+		&s.PromoCode,
+		&p.Amount,
+		&p.InvoiceID,
+		&p.Method,
+		&p.Status,
+		&p.PaidAt,
 	)
 	if err != nil {
-		log.Println("Failed to get shipment by tracking number", err)
-		if err.Error() == sql.ErrNoRows.Error() {
+		log.Println("Failed to get shipment for receipt", err)
+		if errors.Is(err, sql.ErrNoRows) {
 			ctx.JSON(http.StatusNotFound, gin.H{
 				"message": "Shipment not found",
 			})
@@ -1073,76 +4017,141 @@ func TrackShipmentHistoriesByTrackingNumber(ctx *gin.Context) {
 		return
 	}
 
-	var histories []models.ShipmentHistory
-	sqlGetHistories := `
-		SELECT
-			sh.id,
-			sh.shipment_id,
-			sh.status,
-			sh."desc",
-			sh.courier_id,
-			sh.branch_id,
-			sh.timestamp,
-			u.id,
-			u.username,
-			u.email,
-			u.role,
-			b.id,
-			b.name,
-			b.address,
-			b.phone
-		FROM shipment_histories sh
-		LEFT JOIN users u ON u.id = sh.courier_id
-		LEFT JOIN branches b ON b.id = sh.branch_id
-		WHERE sh.shipment_id = $1
-		ORDER BY timestamp DESC
-	`
+	if user.Role != roles.RoleSuperAdmin && user.Role != roles.RoleAdmin && user.ID != uint(s.SenderID) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You are not authorized to access this receipt",
+		})
+		return
+	}
 
-	rows, err := db.DB.Query(sqlGetHistories, s.ID)
+	if p.Status != models.PaymentStatusPaid || p.PaidAt == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Receipts are only available for paid shipments",
+		})
+		return
+	}
+
+	receipt, err := issueReceipt(ctx, s.ID)
 	if err != nil {
-		log.Println("Failed to get shipment histories", err)
+		log.Println("Failed issuing receipt", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Internal server error",
 		})
 		return
 	}
 
-	defer rows.Close()
-	for rows.Next() {
-		var h models.ShipmentHistory
-		var c models.ShCourier
-		var b models.ShBranch
-		err := rows.Scan(
-			&h.ID,
-			&h.ShipmentID,
-			&h.Status,
-			&h.Desc,
-			&h.CourierID,
-			&h.BranchID,
-			&h.Timestamp,
-			&c.ID, &c.Username, &c.Email, &c.Role, // possible nulls
-			&b.ID, &b.Name, &b.Address, &b.Phone, // possible nulls
-		)
-		if err != nil {
-			log.Println("Failed to scan shipment history", err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "Internal server error",
-			})
-			return
+	adjustments, err := adjustment.ListByShipment(db.DB, s.ID)
+	if err != nil {
+		log.Println("Failed to get shipment adjustments", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	// Corrections after checkout are settled on their own, the receipt adds up what was actually paid
+	totalPaid := p.Amount
+	var corrections []models.ShipmentAdjustment
+	var revisedAt *time.Time
+	for _, a := range adjustments {
+		if a.Status != models.PaymentStatusPaid {
+			continue
+		}
+		corrections = append(corrections, a)
+		totalPaid += a.Amount
+		if a.PaidAt != nil && (revisedAt == nil || a.PaidAt.After(*revisedAt)) {
+			revisedAt = a.PaidAt
 		}
+	}
 
-		if h.CourierID != nil {
-			h.Courier = &c
+	row := func(label string, amount int) string {
+		return fmt.Sprintf("%-40s %20s", label, formatRupiah(amount))
+	}
+
+	doc := pdf.New()
+	doc.Title(helpers.Company.Name)
+	if helpers.Company.Address != "" {
+		doc.Text(helpers.Company.Address)
+	}
+	if helpers.Company.Phone != "" {
+		doc.Text("Phone: " + helpers.Company.Phone)
+	}
+	if helpers.Company.TaxID != "" {
+		doc.Text("Tax ID: " + helpers.Company.TaxID)
+	}
+	doc.Space()
+
+	doc.Heading("RECEIPT / TAX INVOICE")
+	doc.Text("Receipt no.     : " + receipt.ReceiptNumber)
+	doc.Text("Issued at       : " + receipt.IssuedAt.Format("02 Jan 2006 15:04"))
+	doc.Text("Paid at         : " + p.PaidAt.Format("02 Jan 2006 15:04"))
+	if revisedAt != nil {
+		doc.Text("Revised at      : " + revisedAt.Format("02 Jan 2006 15:04"))
+	}
+	doc.Text("Payment method  : " + p.Method)
+	doc.Text("Payment ref.    : " + p.InvoiceID)
+	doc.Space()
+
+	doc.Heading("Billed to")
+	doc.Text(s.SenderName + " (" + s.SenderPhone + ")")
+	doc.Text(s.SenderAddress)
+	doc.Space()
+
+	doc.Heading("Shipment")
+	doc.Text("Tracking number : " + s.TrackingNumber)
+	doc.Text("Service         : " + service.Get(s.ServiceType).Name)
+	doc.Text("Recipient       : " + s.RecipientName + " (" + s.RecipientPhone + ")")
+	doc.Text("Deliver to      : " + s.RecipientAddress)
+	doc.Text(fmt.Sprintf("Item            : %s, %.2f kg", s.ItemName, s.ItemWeight))
+	doc.Text(fmt.Sprintf("Distance        : %.2f km", s.Distance/1000))
+	if s.DeclaredValue > 0 {
+		doc.Text("Declared value  : " + formatRupiah(s.DeclaredValue))
+	}
+	doc.Space()
+
+	doc.Heading("Charges")
+	doc.Mono(row("Base price", s.BasePrice))
+	doc.Mono(row("Distance surcharge", s.DistancePrice))
+	doc.Mono(row("Weight surcharge", s.WeightPrice))
+	if s.ServicePrice != 0 {
+		doc.Mono(row(service.Get(s.ServiceType).Name+" service", s.ServicePrice))
+	}
+	if s.DiscountPrice > 0 {
+		label := "Discount"
+		if s.PromoCode != nil {
+			label += " (" + *s.PromoCode + ")"
 		}
-		if h.BranchID != nil {
-			h.Branch = &b
+		doc.Mono(row(label, -s.DiscountPrice))
+	}
+	if s.InsurancePrice > 0 {
+		doc.Mono(row("Insurance premium", s.InsurancePrice))
+	}
+	if len(corrections) > 0 || totalPaid != s.TotalPrice {
+		doc.Mono(row("TOTAL", s.TotalPrice))
+		doc.Mono(row("Paid at checkout", p.Amount))
+		for _, a := range corrections {
+			label := "Price correction"
+			if a.Amount < 0 {
+				label = "Price correction credit"
+			}
+			if a.PaidAt != nil {
+				label += " " + a.PaidAt.Format("02 Jan 2006")
+			}
+			doc.Mono(row(label, a.Amount))
 		}
+	}
+	doc.Mono(row("TOTAL PAID", totalPaid))
+	if outstanding := s.TotalPrice - totalPaid; outstanding > 0 {
+		doc.Mono(row("Outstanding", outstanding))
+	}
 
-		histories = append(histories, h)
+	// Prices are tax inclusive, the tax lines break down the share of the total
+	for _, taxLine := range helpers.Company.TaxLines {
+		taxAmount := int(math.Round(float64(totalPaid) * taxLine.RatePercent / (100 + taxLine.RatePercent)))
+		doc.Mono(row(fmt.Sprintf("  incl. %s %g%%", taxLine.Name, taxLine.RatePercent), taxAmount))
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Tracked successfully",
-		"data":    histories,
-	})
+	fileName := strings.ReplaceAll(receipt.ReceiptNumber, "/", "-") + ".pdf"
+	ctx.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	ctx.Data(http.StatusOK, "application/pdf", doc.Bytes())
 }
@@ -1,5 +1,7 @@
 package shipments
 
+import "time"
+
 // CREATE TABLE IF NOT EXISTS shipments (
 //   id SERIAL PRIMARY KEY,
 //   tracking_number VARCHAR(255) UNIQUE,
@@ -20,17 +22,64 @@ package shipments
 // );
 
 type CreateShipmentDto struct {
-	SenderName       string  `json:"sender_name" binding:"required"`
-	SenderPhone      string  `json:"sender_phone" binding:"required"`
+	SenderName        string     `json:"sender_name" binding:"required"`
+	SenderPhone       string     `json:"sender_phone" binding:"required"`
+	SenderAddress     string     `json:"sender_address" binding:"required"`
+	RecipientName     string     `json:"recipient_name" binding:"required"`
+	RecipientAddress  string     `json:"recipient_address" binding:"required"`
+	RecipientPhone    string     `json:"recipient_phone" binding:"required"`
+	ItemName          string     `json:"item_name" binding:"required"`
+	ItemWeight        float64    `json:"item_weight" binding:"required"` // in KG
+	DeclaredValue     int        `json:"declared_value" binding:"gte=0"` // in IDR
+	IsInsured         bool       `json:"is_insured"`
+	PromoCode         string     `json:"promo_code"`
+	PaymentMethod     string     `json:"payment_method" binding:"omitempty,oneof=INVOICE WALLET POSTPAID"`        // defaults to POSTPAID for credit accounts, INVOICE otherwise
+	PickupMethod      string     `json:"pickup_method" binding:"omitempty,oneof=PICKUP DROP_OFF"`                 // defaults to PICKUP by a courier
+	OriginBranchID    *int       `json:"origin_branch_id"`                                                        // required for DROP_OFF
+	ServiceType       string     `json:"service_type" binding:"omitempty,oneof=ECONOMY REGULAR EXPRESS SAME_DAY"` // defaults to REGULAR
+	PickupWindowStart *time.Time `json:"pickup_window_start"`                                                     // one of the windows listed by /api/pickup-slots, courier pickups only
+	// Distance         float64 `json:"distance" binding:"required"`
+}
+
+type QuoteShipmentDto struct {
 	SenderAddress    string  `json:"sender_address" binding:"required"`
-	RecipientName    string  `json:"recipient_name" binding:"required"`
 	RecipientAddress string  `json:"recipient_address" binding:"required"`
-	RecipientPhone   string  `json:"recipient_phone" binding:"required"`
-	ItemName         string  `json:"item_name" binding:"required"`
 	ItemWeight       float64 `json:"item_weight" binding:"required"` // in KG
-	// Distance         float64 `json:"distance" binding:"required"`
+	PickupMethod     string  `json:"pickup_method" binding:"omitempty,oneof=PICKUP DROP_OFF"`
+	OriginBranchID   *int    `json:"origin_branch_id"` // required for DROP_OFF
+}
+
+type SchedulePickupDto struct {
+	PickupWindowStart *time.Time `json:"pickup_window_start" binding:"required"`
+}
+
+type ReceiveAtBranchDto struct {
+	TrackingNumber string `json:"tracking_number" binding:"required"`
+	BranchID       int    `json:"branch_id" binding:"required"`
+}
+
+// The recipient shows the one-time code they were texted when the package became ready for collection
+type CollectAtBranchDto struct {
+	TrackingNumber string `json:"tracking_number" binding:"required"`
+	Code           string `json:"code" binding:"required,numeric,len=6"`
 }
 
 type TransitShipmentDto struct {
 	BranchID float64 `json:"branch_id" binding:"required"`
 }
+
+// Only the given fields are corrected, at least one is required
+type CorrectRecipientDto struct {
+	RecipientName    *string `json:"recipient_name" binding:"omitempty,min=1"`
+	RecipientPhone   *string `json:"recipient_phone" binding:"omitempty,min=1,max=20"`
+	RecipientAddress *string `json:"recipient_address" binding:"omitempty,min=1"`
+}
+
+// Returns go back to the merchant that sent the delivered shipment
+type CreateReturnDto struct {
+	Payer          string `json:"payer" binding:"omitempty,oneof=MERCHANT RECIPIENT"`                      // recipients only bill the merchant within the return window the merchant opted in to
+	PaymentMethod  string `json:"payment_method" binding:"omitempty,oneof=INVOICE WALLET POSTPAID"`        // merchants only, recipients always pay by invoice
+	PickupMethod   string `json:"pickup_method" binding:"omitempty,oneof=PICKUP DROP_OFF"`                 // defaults to PICKUP by a courier
+	OriginBranchID *int   `json:"origin_branch_id"`                                                        // required for DROP_OFF
+	ServiceType    string `json:"service_type" binding:"omitempty,oneof=ECONOMY REGULAR EXPRESS SAME_DAY"` // defaults to REGULAR
+}
@@ -8,12 +8,23 @@ import (
 
 func Routes(rg *gin.RouterGroup) {
 	rg.POST("/", middlewares.JwtAuthMiddleware(), CreateNewShipment)
-	rg.GET("/", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), GetShipmentsList)
+	rg.POST("/quote", middlewares.JwtAuthMiddleware(), QuoteShipment)
+	rg.GET("/", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), GetShipmentsList)
+	rg.GET("/late", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), GetLateShipments)
 	rg.GET("/:id", middlewares.JwtAuthMiddleware(), GetShipmentByID)
+	rg.GET("/:id/receipt.pdf", middlewares.JwtAuthMiddleware(), DownloadShipmentReceipt)
 	rg.POST("/:id/cancel", middlewares.JwtAuthMiddleware(), CancelShipmentByID)
-	rg.POST("/:id/pick-up", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleCourier), PickupPackageByShipmentID)
-	rg.POST("/:id/transit", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleCourier), TransitPackageByShipmentID)
-	rg.POST("/:id/deliver", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleCourier), DeliverPackageByShipmentID)
+	rg.PUT("/:id/pickup-window", middlewares.JwtAuthMiddleware(), SchedulePickupByShipmentID)
+	rg.POST("/:id/recipient-link", middlewares.JwtAuthMiddleware(), CreateRecipientLinkByShipmentID)
+	rg.PATCH("/:id/recipient", middlewares.JwtAuthMiddleware(), CorrectRecipientByShipmentID)
+	rg.POST("/:id/return", middlewares.RecipientOrJwtAuthMiddleware(), CreateReturnByShipmentID)
+	rg.POST("/:id/pick-up", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), PickupPackageByShipmentID)
+	rg.POST("/receive", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), ReceivePackageAtBranch)
+	rg.POST("/collect", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin), middlewares.BranchScopeMiddleware(), CollectPackageAtBranch)
+	rg.POST("/:id/transit", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), TransitPackageByShipmentID)
+	rg.POST("/:id/deliver", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin, roles.RoleBranchAdmin, roles.RoleCourier), middlewares.BranchScopeMiddleware(), DeliverPackageByShipmentID)
 
 	rg.GET("/track/:tracking_number", TrackShipmentHistoriesByTrackingNumber)
+	rg.GET("/track/:tracking_number/stream", StreamShipmentTracking)
+	rg.GET("/track/:tracking_number/ws", StreamShipmentTrackingWS)
 }
@@ -1,14 +1,17 @@
 package users
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
 	"slices"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/masadamsahid/golang-gin-goldship-api/db"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/branch"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
 	"github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
 )
@@ -208,3 +211,322 @@ func ChangeUserRole(ctx *gin.Context) {
 	})
 
 }
+
+func SetCreditAccount(ctx *gin.Context) {
+	targetUsername := ctx.Param("username")
+
+	var body SetCreditAccountDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	if *body.IsCreditAccount && body.CreditLimit < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Credit accounts need a positive credit limit",
+		})
+		return
+	}
+
+	creditLimit := body.CreditLimit
+	if !*body.IsCreditAccount {
+		creditLimit = 0
+	}
+
+	var targetUser models.User
+	sqlUpdateCredit := `
+	UPDATE users SET is_credit_account = $2, credit_limit = $3, updated_at = NOW()
+	WHERE username = $1
+	RETURNING id, username, email, role, is_credit_account, credit_limit, created_at, updated_at
+	`
+	err = db.DB.QueryRow(sqlUpdateCredit, targetUsername, *body.IsCreditAccount, creditLimit).Scan(
+		&targetUser.ID,
+		&targetUser.Username,
+		&targetUser.Email,
+		&targetUser.Role,
+		&targetUser.IsCreditAccount,
+		&targetUser.CreditLimit,
+		&targetUser.CreatedAt,
+		&targetUser.UpdatedAt,
+	)
+	if err != nil {
+		log.Println("Failed to update credit account", err)
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "User not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Credit account updated successfully",
+		"data":    targetUser,
+	})
+}
+
+// SetReturnWindow lets merchants opt in to paying for the returns their recipients request, admins can set it for them.
+func SetReturnWindow(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	targetUsername := ctx.Param("username")
+	if user.Role != roles.RoleSuperAdmin && user.Role != roles.RoleAdmin && user.Username != targetUsername {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only set your own return window",
+		})
+		return
+	}
+
+	var body SetReturnWindowDto
+	err = ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	var targetUser models.User
+	sqlUpdateReturnWindow := `
+	UPDATE users SET return_window_days = $2, updated_at = NOW()
+	WHERE username = $1
+	RETURNING id, username, email, role, is_credit_account, credit_limit, return_window_days, created_at, updated_at
+	`
+	err = db.DB.QueryRow(sqlUpdateReturnWindow, targetUsername, body.ReturnWindowDays).Scan(
+		&targetUser.ID,
+		&targetUser.Username,
+		&targetUser.Email,
+		&targetUser.Role,
+		&targetUser.IsCreditAccount,
+		&targetUser.CreditLimit,
+		&targetUser.ReturnWindowDays,
+		&targetUser.CreatedAt,
+		&targetUser.UpdatedAt,
+	)
+	if err != nil {
+		log.Println("Failed to update return window", err)
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "User not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Return window updated successfully",
+		"data":    targetUser,
+	})
+}
+
+func getStaffBranches(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, userID uint) ([]models.Branch, error) {
+	sqlGetBranches := `
+	SELECT b.id, b.name, b.phone, b.address, b.created_at, b.updated_at
+	FROM branch_staff bs
+	JOIN branches b ON b.id = bs.branch_id
+	WHERE bs.user_id = $1
+	ORDER BY b.id
+	`
+	rows, err := q.Query(sqlGetBranches, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	branches := []models.Branch{}
+	for rows.Next() {
+		var b models.Branch
+		if err := rows.Scan(&b.ID, &b.Name, &b.Phone, &b.Address, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		branches = append(branches, b)
+	}
+
+	return branches, rows.Err()
+}
+
+func GetStaffBranches(ctx *gin.Context) {
+	targetUsername := ctx.Param("username")
+
+	var targetUser models.User
+	err := db.DB.QueryRow(`SELECT id FROM users WHERE username = $1`, targetUsername).Scan(&targetUser.ID)
+	if err != nil {
+		log.Println("Failed to get target user", err)
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "User not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	branches, err := getStaffBranches(db.DB, targetUser.ID)
+	if err != nil {
+		log.Println("Failed to get staff branches", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Success retrieving staff branches",
+		"data":    branches,
+	})
+}
+
+func SetStaffBranches(ctx *gin.Context) {
+	targetUsername := ctx.Param("username")
+
+	var body SetStaffBranchesDto
+	err := ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid request body",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	var targetUser models.User
+	err = db.DB.QueryRow(`SELECT id, role FROM users WHERE username = $1`, targetUsername).Scan(&targetUser.ID, &targetUser.Role)
+	if err != nil {
+		log.Println("Failed to get target user", err)
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "User not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if targetUser.Role == roles.RoleCustomer {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Only staff can be assigned to branches",
+		})
+		return
+	}
+
+	active, err := branch.AllActive(db.DB, body.BranchIDs...)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	if !active {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Branch not found",
+		})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	_, err = tx.Exec(`DELETE FROM branch_staff WHERE user_id = $1`, targetUser.ID)
+	for _, branchID := range body.BranchIDs {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(`INSERT INTO branch_staff (user_id, branch_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, targetUser.ID, branchID)
+	}
+	var branches []models.Branch
+	if err == nil {
+		branches, err = getStaffBranches(tx, targetUser.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Failed to assign staff branches", err)
+		if strings.Contains(err.Error(), "foreign key constraint") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Branch not found",
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Println(txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Staff branches updated successfully",
+		"data":    branches,
+	})
+}
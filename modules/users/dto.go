@@ -5,3 +5,18 @@ import "github.com/masadamsahid/golang-gin-goldship-api/modules/users/roles"
 type ChangeUserRoleDto struct {
 	Role roles.UserRoles `json:"role" binding:"required"`
 }
+
+type SetCreditAccountDto struct {
+	IsCreditAccount *bool `json:"is_credit_account" binding:"required"`
+	CreditLimit     int   `json:"credit_limit" binding:"gte=0"`
+}
+
+// The merchant pays for returns their recipients request within ReturnWindowDays of delivery, null turns it off
+type SetReturnWindowDto struct {
+	ReturnWindowDays *int `json:"return_window_days" binding:"omitempty,gte=1,lte=365"`
+}
+
+// Replaces every branch assignment of the user, an empty list unassigns them
+type SetStaffBranchesDto struct {
+	BranchIDs []int `json:"branch_ids" binding:"required"`
+}
@@ -3,15 +3,17 @@ package roles
 type UserRoles = string
 
 const (
-	RoleSuperAdmin UserRoles = "SUPERADMIN"
-	RoleAdmin      UserRoles = "ADMIN"
-	RoleCourier    UserRoles = "COURIER"
-	RoleCustomer   UserRoles = "CUSTOMER"
+	RoleSuperAdmin  UserRoles = "SUPERADMIN"
+	RoleAdmin       UserRoles = "ADMIN"
+	RoleBranchAdmin UserRoles = "BRANCH_ADMIN" // ADMIN limited to the branches they are assigned to
+	RoleCourier     UserRoles = "COURIER"
+	RoleCustomer    UserRoles = "CUSTOMER"
 )
 
 var ROLE_LIST []UserRoles = []UserRoles{
 	RoleSuperAdmin,
 	RoleAdmin,
+	RoleBranchAdmin,
 	RoleCourier,
 	RoleCustomer,
 }
@@ -9,4 +9,8 @@ import (
 func Routes(rg *gin.RouterGroup) {
 	rg.GET("/my-shipments", middlewares.JwtAuthMiddleware(), GetMyShipments)
 	rg.POST("/:username/change-role", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), ChangeUserRole)
+	rg.GET("/:username/branches", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), GetStaffBranches)
+	rg.PUT("/:username/branches", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), SetStaffBranches)
+	rg.PUT("/:username/credit-account", middlewares.JwtAuthMiddleware(roles.RoleSuperAdmin, roles.RoleAdmin), SetCreditAccount)
+	rg.PUT("/:username/return-window", middlewares.JwtAuthMiddleware(), SetReturnWindow)
 }
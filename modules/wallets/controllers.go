@@ -0,0 +1,264 @@
+package wallets
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/wallet"
+	xenditService "github.com/masadamsahid/golang-gin-goldship-api/helpers/xendit-service"
+	"github.com/xendit/xendit-go/v7/invoice"
+)
+
+func GetMyWallet(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	// Reading does not open the wallet, top-ups and payments do
+	account, err := wallet.GetUserAccount(db.DB, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		account, err = wallet.EmptyUserAccount(user.ID), nil
+	}
+	if err != nil {
+		log.Println("Failed to get wallet account", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Wallet retrieved successfully",
+		"data":    account,
+	})
+}
+
+func GetMyWalletEntries(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	page, pageSize, err := helpers.ParsePaginationFromQueryParams(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	sqlGetEntries := `
+	SELECT
+		e.id,
+		e.transaction_id,
+		e.account_id,
+		e.direction,
+		e.amount,
+		e.balance_after,
+		e.created_at,
+		t.id,
+		t.type,
+		t.reference,
+		t."desc",
+		t.created_at
+	FROM ledger_entries e
+	JOIN ledger_accounts a ON a.id = e.account_id
+	JOIN ledger_transactions t ON t.id = e.transaction_id
+	WHERE a.user_id = $1
+	ORDER BY e.created_at DESC, e.id DESC
+	LIMIT $2
+	OFFSET $3
+	`
+
+	rows, err := db.DB.Query(sqlGetEntries, user.ID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Println("Failed to get wallet entries", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.LedgerEntry{}
+	for rows.Next() {
+		var e models.LedgerEntry
+		var t models.LedgerTransaction
+		var desc *string
+		err := rows.Scan(
+			&e.ID,
+			&e.TransactionID,
+			&e.AccountID,
+			&e.Direction,
+			&e.Amount,
+			&e.BalanceAfter,
+			&e.CreatedAt,
+			&t.ID,
+			&t.Type,
+			&t.Reference,
+			&desc,
+			&t.CreatedAt,
+		)
+		if err != nil {
+			log.Println("Failed to scan wallet entry", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+		if desc != nil {
+			t.Desc = *desc
+		}
+		e.Transaction = &t
+		entries = append(entries, e)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Wallet entries retrieved successfully",
+		"data":    entries,
+		"meta": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func TopUpMyWallet(ctx *gin.Context) {
+	var user helpers.AuthPayload
+	err := helpers.ParseJWTUserFromCtx(ctx, &user)
+	if err != nil {
+		log.Println("Failed convert user from context to AuthPayload")
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "Unauthorized",
+		})
+		return
+	}
+
+	var body TopUpWalletDto
+	err = ctx.ShouldBind(&body)
+	if err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			log.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Internal server error",
+			})
+			return
+		}
+
+		log.Printf("%+v\n", validationErrors)
+		errs := helpers.HandleValidationErrors(validationErrors)
+
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": errs})
+		return
+	}
+
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	account, err := wallet.GetOrCreateUserAccount(tx, user.ID)
+	if err != nil {
+		log.Println("Failed to get wallet account", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	externalID := fmt.Sprintf("%s%d-%d", wallet.TopUpExternalIDPrefix, user.ID, time.Now().UnixNano())
+	createInvoiceReq := *invoice.NewCreateInvoiceRequest(externalID, float64(body.Amount))
+	createInvoiceReq.SetDescription("Goldship wallet top-up for " + user.Username)
+	createInvoiceReq.SetInvoiceDuration(24 * 60 * 60) // 1 day
+
+	inv, resp, xenditErr := xenditService.Client.InvoiceApi.CreateInvoice(context.Background()).CreateInvoiceRequest(
+		createInvoiceReq,
+	).Execute()
+	if xenditErr != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `InvoiceApi.CreateInvoice``: %v\n", xenditErr.Error())
+
+		b, _ := json.Marshal(xenditErr.FullError())
+		fmt.Fprintf(os.Stderr, "Full Error Struct: %v\n", string(b))
+
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", resp)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to create invoice",
+		})
+		return
+	}
+
+	var topUp models.WalletTopUp
+	sqlCreateTopUp := `
+	INSERT INTO wallet_top_ups (user_id, account_id, amount, invoice_id, external_id, invoice_url)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, user_id, account_id, amount, invoice_id, external_id, invoice_url, status, ledger_transaction_id, paid_at, expired_at, created_at, updated_at
+	`
+	err = tx.QueryRow(sqlCreateTopUp, user.ID, account.ID, body.Amount, inv.Id, inv.ExternalId, inv.InvoiceUrl).Scan(
+		&topUp.ID,
+		&topUp.UserID,
+		&topUp.AccountID,
+		&topUp.Amount,
+		&topUp.InvoiceID,
+		&topUp.ExternalID,
+		&topUp.InvoiceURL,
+		&topUp.Status,
+		&topUp.LedgerTransactionID,
+		&topUp.PaidAt,
+		&topUp.ExpiredAt,
+		&topUp.CreatedAt,
+		&topUp.UpdatedAt,
+	)
+	if err != nil {
+		log.Println("Failed creating wallet top-up", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Top-up invoice created successfully",
+		"data":    topUp,
+	})
+}
@@ -0,0 +1,5 @@
+package wallets
+
+type TopUpWalletDto struct {
+	Amount int `json:"amount" binding:"required,gte=10000"`
+}
@@ -0,0 +1,12 @@
+package wallets
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/middlewares"
+)
+
+func Routes(rg *gin.RouterGroup) {
+	rg.GET("/me", middlewares.JwtAuthMiddleware(), GetMyWallet)
+	rg.GET("/me/entries", middlewares.JwtAuthMiddleware(), GetMyWalletEntries)
+	rg.POST("/me/top-up", middlewares.JwtAuthMiddleware(), TopUpMyWallet)
+}
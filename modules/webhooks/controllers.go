@@ -1,12 +1,21 @@
 package webhooks
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/masadamsahid/golang-gin-goldship-api/db"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/adjustment"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/billing"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/dispatch"
 	"github.com/masadamsahid/golang-gin-goldship-api/helpers/models"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/promotion"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/realtime"
+	"github.com/masadamsahid/golang-gin-goldship-api/helpers/wallet"
 	xenditService "github.com/masadamsahid/golang-gin-goldship-api/helpers/xendit-service"
 	"github.com/xendit/xendit-go/v7/invoice"
 )
@@ -26,6 +35,21 @@ func XenditInvoiceNotification(ctx *gin.Context) {
 		return
 	}
 
+	if strings.HasPrefix(body.ExternalID, wallet.TopUpExternalIDPrefix) {
+		handleWalletTopUpNotification(ctx, body)
+		return
+	}
+
+	if strings.HasPrefix(body.ExternalID, billing.ExternalIDPrefix) {
+		handleBillingInvoiceNotification(ctx, body)
+		return
+	}
+
+	if strings.HasPrefix(body.ExternalID, adjustment.ExternalIDPrefix) {
+		handleAdjustmentNotification(ctx, body)
+		return
+	}
+
 	var payment models.Payment
 	sqlStatement := `SELECT id, shipment_id, status FROM payments WHERE invoice_id = $1 LIMIT 1`
 	err := db.DB.QueryRow(sqlStatement, body.ID).Scan(&payment.ID, &payment.ShipmentID, &payment.Status)
@@ -48,6 +72,7 @@ func XenditInvoiceNotification(ctx *gin.Context) {
 	defer db.CloseTx(tx, txErr)
 
 	var updatedPayment models.Payment
+	var historyID int
 	switch body.Status {
 	case string(invoice.INVOICESTATUS_PAID):
 		fallthrough
@@ -74,7 +99,7 @@ func XenditInvoiceNotification(ctx *gin.Context) {
 		RETURNING id
 		`
 
-		_, txErr = tx.Exec(sqlInsertHistory, updatedPayment.ShipmentID, models.StatusReadyToPickup, desc)
+		txErr = tx.QueryRow(sqlInsertHistory, updatedPayment.ShipmentID, models.StatusReadyToPickup, desc).Scan(&historyID)
 		if txErr != nil {
 			log.Printf("Error inserting shipment history: %v\n", txErr)
 			ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -92,7 +117,17 @@ func XenditInvoiceNotification(ctx *gin.Context) {
 			})
 			return
 		}
+
+		txErr = dispatch.CreatePickupTask(tx, updatedPayment.ShipmentID)
+		if txErr != nil {
+			log.Printf("Error creating pickup task: %v\n", txErr)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to create pickup task",
+			})
+			return
+		}
 		tx.Commit()
+		realtime.PublishHistories(historyID)
 	case string(invoice.INVOICESTATUS_EXPIRED):
 		sqlUpdatePaymentStatus := `UPDATE payments SET status = $2 WHERE invoice_id = $1 RETURNING id, shipment_id, status`
 		txErr = tx.QueryRow(sqlUpdatePaymentStatus, body.ID, body.Status).Scan(
@@ -116,7 +151,7 @@ func XenditInvoiceNotification(ctx *gin.Context) {
 		RETURNING id
 		`
 
-		_, txErr = tx.Exec(sqlInsertHistory, updatedPayment.ShipmentID, models.StatusCancelled, desc)
+		txErr = tx.QueryRow(sqlInsertHistory, updatedPayment.ShipmentID, models.StatusCancelled, desc).Scan(&historyID)
 		if txErr != nil {
 			log.Printf("Error inserting shipment history: %v\n", txErr)
 			ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -134,7 +169,17 @@ func XenditInvoiceNotification(ctx *gin.Context) {
 			})
 			return
 		}
+
+		txErr = promotion.Release(tx, updatedPayment.ShipmentID)
+		if txErr != nil {
+			log.Printf("Error releasing promo code usage: %v\n", txErr)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to release promo code usage",
+			})
+			return
+		}
 		tx.Commit()
+		realtime.PublishHistories(historyID)
 	default:
 		log.Printf("Unhandled invoice status: %s\n", body.Status)
 		ctx.JSON(http.StatusBadRequest, gin.H{
@@ -147,3 +192,286 @@ func XenditInvoiceNotification(ctx *gin.Context) {
 		"message": "Notification received",
 	})
 }
+
+func handleWalletTopUpNotification(ctx *gin.Context, body XenditInvoiceNotificationDto) {
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to begin transaction",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var topUp models.WalletTopUp
+	sqlGetTopUp := `SELECT id, account_id, amount, external_id, status FROM wallet_top_ups WHERE invoice_id = $1 FOR UPDATE`
+	txErr = tx.QueryRow(sqlGetTopUp, body.ID).Scan(&topUp.ID, &topUp.AccountID, &topUp.Amount, &topUp.ExternalID, &topUp.Status)
+	if txErr != nil {
+		log.Printf("Error getting wallet top-up: %v\n", txErr)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get wallet top-up",
+		})
+		return
+	}
+
+	// Xendit may retry notifications, only a pending top-up can change state
+	if topUp.Status != models.PaymentStatusPending {
+		tx.Rollback()
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Notification received",
+		})
+		return
+	}
+
+	switch body.Status {
+	case string(invoice.INVOICESTATUS_PAID), string(invoice.INVOICESTATUS_SETTLED):
+		gatewayAccountID, err := wallet.GetSystemAccountID(tx, wallet.AccountCodePaymentGateway)
+		if err != nil {
+			log.Printf("Error getting payment gateway account: %v\n", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to credit wallet",
+			})
+			return
+		}
+
+		ledgerTx, err := wallet.Transfer(
+			tx,
+			wallet.TransactionTypeTopUp,
+			topUp.ExternalID,
+			"Wallet top-up via invoice "+body.ID,
+			gatewayAccountID,
+			topUp.AccountID,
+			topUp.Amount,
+		)
+		if err != nil {
+			log.Printf("Error crediting wallet: %v\n", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to credit wallet",
+			})
+			return
+		}
+
+		sqlUpdateTopUp := `UPDATE wallet_top_ups SET status = $2, paid_at = $3, ledger_transaction_id = $4, updated_at = NOW() WHERE id = $1`
+		_, err = tx.Exec(sqlUpdateTopUp, topUp.ID, models.PaymentStatusPaid, body.PaidAt, ledgerTx.ID)
+		if err != nil {
+			log.Printf("Error updating wallet top-up status: %v\n", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to update wallet top-up status",
+			})
+			return
+		}
+	case string(invoice.INVOICESTATUS_EXPIRED):
+		sqlUpdateTopUp := `UPDATE wallet_top_ups SET status = $2, expired_at = NOW(), updated_at = NOW() WHERE id = $1`
+		_, err := tx.Exec(sqlUpdateTopUp, topUp.ID, models.PaymentStatusExpired)
+		if err != nil {
+			log.Printf("Error updating wallet top-up status: %v\n", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to update wallet top-up status",
+			})
+			return
+		}
+	default:
+		log.Printf("Unhandled invoice status: %s\n", body.Status)
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Unhandled invoice status",
+		})
+		return
+	}
+
+	err := tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to commit transaction",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Notification received",
+	})
+}
+
+func handleBillingInvoiceNotification(ctx *gin.Context, body XenditInvoiceNotificationDto) {
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to begin transaction",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var billingInvoice models.BillingInvoice
+	sqlGetBillingInvoice := `SELECT id, status FROM billing_invoices WHERE invoice_id = $1 FOR UPDATE`
+	err := tx.QueryRow(sqlGetBillingInvoice, body.ID).Scan(&billingInvoice.ID, &billingInvoice.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The invoice was reissued, this notification belongs to the superseded payment link
+		tx.Rollback()
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Notification ignored",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting billing invoice: %v\n", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get billing invoice",
+		})
+		return
+	}
+
+	if billingInvoice.Status != models.PaymentStatusPending {
+		tx.Rollback()
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Notification received",
+		})
+		return
+	}
+
+	switch body.Status {
+	case string(invoice.INVOICESTATUS_PAID), string(invoice.INVOICESTATUS_SETTLED):
+		sqlUpdateBillingInvoice := `UPDATE billing_invoices SET status = $2, paid_at = $3, updated_at = NOW() WHERE id = $1`
+		_, err = tx.Exec(sqlUpdateBillingInvoice, billingInvoice.ID, models.PaymentStatusPaid, body.PaidAt)
+		if err != nil {
+			log.Printf("Error updating billing invoice status: %v\n", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to update billing invoice status",
+			})
+			return
+		}
+
+		// Settle the deferred payment of every shipment on the statement
+		sqlSettlePayments := `
+		UPDATE payments SET status = $2, paid_at = $3, updated_at = NOW()
+		WHERE method = $4 AND shipment_id IN (SELECT shipment_id FROM billing_invoice_items WHERE billing_invoice_id = $1)
+		`
+		_, err = tx.Exec(sqlSettlePayments, billingInvoice.ID, models.PaymentStatusPaid, body.PaidAt, models.PaymentMethodPostpaid)
+		if err != nil {
+			log.Printf("Error settling postpaid payments: %v\n", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to update payment status",
+			})
+			return
+		}
+	case string(invoice.INVOICESTATUS_EXPIRED):
+		sqlUpdateBillingInvoice := `UPDATE billing_invoices SET status = $2, expired_at = NOW(), updated_at = NOW() WHERE id = $1`
+		_, err = tx.Exec(sqlUpdateBillingInvoice, billingInvoice.ID, models.PaymentStatusExpired)
+		if err != nil {
+			log.Printf("Error updating billing invoice status: %v\n", err)
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to update billing invoice status",
+			})
+			return
+		}
+	default:
+		log.Printf("Unhandled invoice status: %s\n", body.Status)
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Unhandled invoice status",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to commit transaction",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Notification received",
+	})
+}
+
+func handleAdjustmentNotification(ctx *gin.Context, body XenditInvoiceNotificationDto) {
+	tx, txErr := db.DB.BeginTx(ctx, nil)
+	if txErr != nil {
+		log.Printf("Error beginning transaction: %v\n", txErr)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to begin transaction",
+		})
+		return
+	}
+	defer db.CloseTx(tx, txErr)
+
+	var adj models.ShipmentAdjustment
+	sqlGetAdjustment := `SELECT id, status FROM shipment_adjustments WHERE invoice_id = $1 FOR UPDATE`
+	err := tx.QueryRow(sqlGetAdjustment, body.ID).Scan(&adj.ID, &adj.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Notification ignored",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting shipment adjustment: %v\n", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get shipment adjustment",
+		})
+		return
+	}
+
+	if adj.Status != models.PaymentStatusPending {
+		tx.Rollback()
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Notification received",
+		})
+		return
+	}
+
+	// A supplementary invoice only settles the price difference, the shipment carries on either way
+	switch body.Status {
+	case string(invoice.INVOICESTATUS_PAID), string(invoice.INVOICESTATUS_SETTLED):
+		sqlUpdateAdjustment := `UPDATE shipment_adjustments SET status = $2, paid_at = $3, updated_at = NOW() WHERE id = $1`
+		_, err = tx.Exec(sqlUpdateAdjustment, adj.ID, models.PaymentStatusPaid, body.PaidAt)
+	case string(invoice.INVOICESTATUS_EXPIRED):
+		sqlUpdateAdjustment := `UPDATE shipment_adjustments SET status = $2, expired_at = NOW(), updated_at = NOW() WHERE id = $1`
+		_, err = tx.Exec(sqlUpdateAdjustment, adj.ID, models.PaymentStatusExpired)
+	default:
+		log.Printf("Unhandled invoice status: %s\n", body.Status)
+		tx.Rollback()
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Unhandled invoice status",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating shipment adjustment status: %v\n", err)
+		tx.Rollback()
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update shipment adjustment status",
+		})
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to commit transaction",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Notification received",
+	})
+}